@@ -1,20 +1,34 @@
 package cli
 
 import (
-"context"
-"fmt"
-"log/slog"
-"os"
-"os/signal"
-"strconv"
-"syscall"
-"time"
-
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/proxmox"
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
-"github.com/spf13/cobra"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/control"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/journal"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/lock"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/proxmox"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/snmp"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/systemd"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 type BuildInfo struct {
@@ -24,8 +38,9 @@ type BuildInfo struct {
 }
 
 var (
-cfgFile   string
-buildInfo BuildInfo
+	cfgFile   string
+	buildInfo BuildInfo
+	forceLock bool
 )
 
 var rootCmd = &cobra.Command{
@@ -45,7 +60,12 @@ var validateCmd = &cobra.Command{
 		}
 
 		fmt.Println("✅ Configuration syntax: OK")
-		fmt.Printf("   UPS: %s@%s\n", cfg.UPS.Name, cfg.UPS.Host)
+		for _, src := range cfg.UPSSources() {
+			fmt.Printf("   UPS: %s\n", src.Label())
+		}
+		if len(cfg.UPS.Sources) > 0 {
+			fmt.Printf("   UPS policy: %s\n", cfg.UPS.Policy)
+		}
 		fmt.Printf("   Phases: %d\n", len(cfg.Phases))
 
 		totalActions := 0
@@ -53,6 +73,9 @@ var validateCmd = &cobra.Command{
 			totalActions += len(p.Actions)
 		}
 		fmt.Printf("   Actions: %d\n", totalActions)
+		if len(cfg.EmergencyPhases) > 0 {
+			fmt.Printf("   Emergency phases: %d\n", len(cfg.EmergencyPhases))
+		}
 
 		fmt.Println("✅ All validations passed")
 		return nil
@@ -71,37 +94,24 @@ var planCmd = &cobra.Command{
 		fmt.Println("📋 Shutdown Plan:")
 		fmt.Println()
 
-		for i, phase := range cfg.Phases {
-			mode := "sequential"
-			if phase.Parallel {
-				mode = "parallel"
-			}
-			fmt.Printf("Phase %d: %s (%s)\n", i+1, phase.Name, mode)
-			if phase.Timeout > 0 {
-				fmt.Printf("  Timeout: %s\n", phase.Timeout)
-			}
+		printPhases(cfg.Phases)
 
-			for j, action := range phase.Actions {
-				fmt.Printf("  %d.%d [%s] ", i+1, j+1, action.Type)
-				switch action.Type {
-				case "ssh":
-					fmt.Printf("%s@%s: %s", action.User, action.Host, truncate(action.Command, 40))
-				case "local":
-					fmt.Printf("%s", truncate(action.Command, 50))
-				case "proxmox-guest":
-					fmt.Printf("%s ", action.Action)
-					if action.Selector != nil {
-						if len(action.Selector.VMIDRange) > 0 {
-							fmt.Printf("vmid=%v ", action.Selector.VMIDRange)
-						}
-						if action.Selector.Type != "" {
-							fmt.Printf("type=%s", action.Selector.Type)
-						}
-					}
-				}
-				fmt.Println()
-			}
+		if len(cfg.EmergencyPhases) > 0 {
+			fmt.Println("🚨 Emergency Plan:")
 			fmt.Println()
+			printPhases(cfg.EmergencyPhases)
+		}
+
+		fmt.Printf("⏱️  Estimated shutdown duration: %s\n", cfg.EstimateShutdownDuration())
+		fmt.Printf("🔚 Final action: %s after %s\n", cfg.FinalAction.Type, cfg.FinalAction.delay())
+		minRuntime, _ := cfg.MinRuntime()
+		if minRuntime > 0 {
+			fmt.Printf("🔋 Runtime trigger: shutdown when battery runtime < %s\n", minRuntime)
+		} else {
+			fmt.Println("🔋 Runtime trigger: disabled (set ups.thresholds.min_runtime)")
+		}
+		if cfg.UPS.Thresholds.OnBatteryMax > 0 {
+			fmt.Printf("⏳ Grace timer: shutdown after %s on battery\n", cfg.UPS.Thresholds.OnBatteryMax)
 		}
 
 		return nil
@@ -119,31 +129,61 @@ the UPS via NUT and triggering shutdown when thresholds are reached.`,
 			return err
 		}
 
-		fmt.Println("👁️ Starting daemon mode...")
-		fmt.Printf("📡 Connecting to NUT at %s...\n", cfg.UPS.Host)
+		release, err := acquireLock(cfg)
+		if err != nil {
+			return err
+		}
+		defer release()
 
-		// Create NUT client
-		nutClient := ups.NewClient(cfg.UPS.Host+":3493", cfg.UPS.Name)
-		if err := nutClient.Connect(); err != nil {
-			return fmt.Errorf("failed to connect to NUT: %w", err)
+		fmt.Println("👁️ Starting daemon mode...")
+		if cfg.Options.DryRun {
+			fmt.Println("🧪 Dry-run mode: shutdowns will only be simulated (options.dry_run)")
+		}
+		for _, src := range cfg.UPSSources() {
+			if src.Driver == ups.DriverSimulate {
+				fmt.Printf("🎭 Simulating %s from %s (speed %gx), final action disabled\n",
+					src.Label(), src.Simulate.Scenario, simulateSpeed(src.Simulate))
+				continue
+			}
+			fmt.Printf("📡 Connecting to %s at %s...\n", driverName(src), src.Label())
 		}
-		defer nutClient.Close()
 
-		fmt.Println("✅ Connected to NUT server")
+		m := newGuardianMetrics()
 
 		// Create Proxmox client for shutdown operations
 		pxClient, err := proxmox.NewClient(proxmox.Config{
-APIURL:      cfg.Proxmox.APIURL,
-TokenID:     cfg.Proxmox.TokenID,
-TokenSecret: cfg.Proxmox.TokenSecret,
-InsecureTLS: cfg.Proxmox.InsecureTLS,
-})
+			APIURL:      cfg.Proxmox.APIURL,
+			TokenID:     cfg.Proxmox.TokenID,
+			TokenSecret: cfg.Proxmox.TokenSecret,
+			InsecureTLS: cfg.Proxmox.InsecureTLS,
+			OnRequest:   m.APIRequest,
+		})
 		if err != nil {
 			return fmt.Errorf("failed to create Proxmox client: %w", err)
 		}
 
+		// Setup signal handling
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		// Start UPS monitors
+		group, clients, err := newUPSGroup(cfg, func(ups string, err error) {
+			m.PollError(ups)
+		})
+		if err != nil {
+			return err
+		}
+		if err := group.Start(ctx); err != nil {
+			return fmt.Errorf("failed to connect to UPS: %w", err)
+		}
+		defer group.Stop()
+
+		fmt.Println("✅ Connected to UPS monitoring")
+		if len(clients) > 1 {
+			fmt.Printf("🔀 Monitoring %d UPS units (policy: %s)\n", len(clients), cfg.UPS.Policy)
+		}
+
 		// Test Proxmox connection
-		ctx := context.Background()
 		version, err := pxClient.GetVersion(ctx)
 		if err != nil {
 			fmt.Printf("⚠️ Warning: Cannot connect to Proxmox API: %v\n", err)
@@ -151,125 +191,283 @@ InsecureTLS: cfg.Proxmox.InsecureTLS,
 			fmt.Printf("✅ Connected to Proxmox %s\n", version)
 		}
 
-		// Setup signal handling
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-		// Start monitoring loop
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-
-		var onBatteryStart time.Time
-
-		fmt.Println("🔋 Starting UPS monitoring loop...")
+		if minRuntime, _ := cfg.MinRuntime(); minRuntime > 0 {
+			fmt.Printf("⏱️ Runtime trigger: shutdown when battery runtime < %s\n", minRuntime)
+		}
+		if cfg.UPS.Thresholds.OnBatteryMax > 0 {
+			fmt.Printf("⏱️ Grace timer: shutdown after %s on battery\n", cfg.UPS.Thresholds.OnBatteryMax)
+		}
 
 		// Get initial status
-		status, err := nutClient.GetStatus(ctx)
-		if err != nil {
-			fmt.Printf("⚠️ Initial status check failed: %v\n", err)
-		} else {
-			fmt.Printf("🔋 Initial: Battery %d%% | Runtime %ds | Status: %s\n",
-status.BatteryCharge, status.Runtime, status.Status)
-		}
-
-		for {
-			select {
-			case <-sigChan:
-				fmt.Println("\n⚠️ Received shutdown signal, stopping...")
-				return nil
-			case <-ticker.C:
-				status, err := nutClient.GetStatus(ctx)
-				if err != nil {
-					fmt.Printf("❌ Error getting UPS status: %v\n", err)
-					continue
-				}
-
-				fmt.Printf("🔋 Battery: %d%% | Runtime: %ds | Status: %s | Load: %d%%\n",
-status.BatteryCharge, status.Runtime, status.Status, status.Load)
+		for i, src := range cfg.UPSSources() {
+			status, err := clients[i].GetStatus(ctx)
+			if err != nil {
+				fmt.Printf("⚠️ %s: Initial status check failed: %v\n", src.Label(), err)
+				continue
+			}
+			fmt.Printf("🔋 Initial %s: Battery %d%% | Runtime %ds | Status: %s\n",
+				src.Label(), status.BatteryCharge, status.Runtime, status.Status)
+		}
 
-				if status.IsOnBattery() {
-					if onBatteryStart.IsZero() {
-						onBatteryStart = time.Now()
-						fmt.Println("⚡ Power outage detected! Starting monitoring...")
-					}
+		logger := &slogLogger{slog.Default()}
+		store := &configStore{cfg: cfg}
+		var d *daemon.Daemon
+		recovery := &recoveryGuard{}
+		shutdown := func(ctx context.Context, plan daemon.Plan, trigger daemon.Trigger, reason string, abort <-chan struct{}) error {
+			// A manual recovery must not start guests the shutdown stops
+			defer recovery.hold()()
 
-					shouldShutdown := false
-					reason := ""
+			upsStatus := func() *ups.Status { return lowestStatus(d.Statuses()) }
+			return runShutdown(ctx, store.Get(), pxClient, plan, trigger, reason, abort, m, upsStatus)
+		}
 
-					if status.BatteryCharge <= cfg.UPS.Thresholds.Critical {
-						shouldShutdown = true
-						reason = fmt.Sprintf("battery at %d%% (critical threshold: %d%%)",
-status.BatteryCharge, cfg.UPS.Thresholds.Critical)
-					}
+		d = daemon.NewDaemon(daemonConfig(cfg), group, shutdown, logger, store)
+		d.SetJournal(store)
+
+		// Serve the control API used by `ctl` and `notify`
+		handler := &daemonControl{
+			ctx:      ctx,
+			config:   store,
+			daemon:   d,
+			group:    group,
+			pxClient: pxClient,
+			logger:   logger,
+			metrics:  m,
+			recovery: recovery,
+		}
+		ctl := control.NewServer(cfg.Options.ControlSocket, handler)
+		if err := ctl.Start(); err != nil {
+			return err
+		}
+		defer ctl.Close()
+		fmt.Printf("🎛️ Control socket: %s\n", cfg.Options.ControlSocket)
+		if cfg.Options.ControlListen != "" {
+			if err := ctl.StartTCP(cfg.Options.ControlListen, cfg.Options.ControlToken); err != nil {
+				return err
+			}
+			fmt.Printf("🎛️ Control API: http://%s\n", cfg.Options.ControlListen)
+		}
 
-					if status.IsLowBattery() {
-						shouldShutdown = true
-						reason = "UPS reports low battery"
-					}
+		if cfg.Options.MetricsListen != "" {
+			m.collectDaemon(d)
+			if err := m.serveMetrics(ctx, cfg.Options.MetricsListen); err != nil {
+				return fmt.Errorf("starting metrics listener: %w", err)
+			}
+			fmt.Printf("📈 Metrics: http://%s/metrics\n", cfg.Options.MetricsListen)
+		}
 
-					if shouldShutdown {
-						fmt.Printf("🚨 SHUTDOWN TRIGGERED: %s\n", reason)
-
-						// Build orchestrator phases from config
-						phases, err := buildPhasesFromConfig(cfg, pxClient)
-						if err != nil {
-							fmt.Printf("❌ Failed to build phases: %v\n", err)
-							return fmt.Errorf("building phases: %w", err)
-						}
-
-						// Create orchestrator
-						logger := &slogLogger{slog.Default()}
-						orch := orchestrator.NewOrchestrator(phases, cfg.Options.StateFile, logger, &noopNotifier{})
-
-						// Execute shutdown sequence
-						fmt.Println("📋 Executing shutdown phases...")
-						shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 15*time.Minute)
-						defer shutdownCancel()
-
-						if err := orch.Execute(shutdownCtx, reason); err != nil {
-							fmt.Printf("❌ Shutdown sequence failed: %v\n", err)
-						} else {
-							fmt.Println("✅ Shutdown sequence completed successfully")
-						}
-
-						// Final: shutdown the Proxmox host itself
-						fmt.Println("🔴 Initiating Proxmox host shutdown...")
-						if err := executeHostShutdown(); err != nil {
-							fmt.Printf("❌ Host shutdown failed: %v\n", err)
-						}
-
-						return nil
+		// Reload the configuration on SIGHUP
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hup:
+					logger.Info("SIGHUP received, reloading configuration")
+					if err := handler.Reload(); err != nil {
+						logger.Error("Configuration reload failed", "error", err)
 					}
-				} else if status.IsOnline() && !onBatteryStart.IsZero() {
-					fmt.Println("✅ Power restored!")
-					onBatteryStart = time.Time{}
 				}
 			}
+		}()
+
+		// Tell systemd we are up (Type=notify) and keep its watchdog fed
+		if sent, err := systemd.Notify(systemd.Ready); err != nil {
+			logger.Error("systemd notification failed", "error", err)
+		} else if sent {
+			go runSystemdNotifier(ctx, store, d, group, logger)
+			defer func() { _, _ = systemd.Notify(systemd.Stopping) }()
 		}
+
+		fmt.Println("🔋 Starting UPS monitoring loop...")
+		return d.Run(ctx)
 	},
 }
 
-var notifyCmd = &cobra.Command{
-	Use:   "notify [event]",
-	Short: "Handle NUT notification event",
-	Long: `Called by NUT's NOTIFYCMD when UPS events occur.
-Events: ONLINE, ONBATT, LOWBATT, FSD, COMMOK, COMMBAD, SHUTDOWN, REPLBATT, NOCOMM`,
-	Args: cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		event := args[0]
+// runShutdown executes the phases of the given plan and then powers off
+// the host. Closing abort stops the sequence at the next safe boundary;
+// completed actions are then recovered and daemon.ErrShutdownAborted is
+// returned. Cancelling ctx preempts the plan without powering off. obs, if
+// not nil, observes the orchestrator. upsStatus returns the UPS status seen
+// by phase conditions. With options.dry_run the plan is only simulated and
+// daemon.ErrDryRun is returned instead of powering off, as it is after the
+// phases when a UPS is simulated.
+func runShutdown(ctx context.Context, cfg *Config, pxClient *proxmox.Client, plan daemon.Plan, trigger daemon.Trigger, reason string, abort <-chan struct{}, obs orchestrator.Observer, upsStatus func() *ups.Status) error {
+	cfgPhases := planPhases(cfg, plan)
+	delay := cfg.FinalAction.delay()
+	if plan == daemon.PlanEmergency {
+		fmt.Printf("🚨 EMERGENCY SHUTDOWN TRIGGERED: %s\n", reason)
+		delay = 0
+	} else {
+		fmt.Printf("🚨 SHUTDOWN TRIGGERED: %s\n", reason)
+	}
 
-		cfg, err := loadConfig()
-		if err != nil {
-			return err
+	// Build orchestrator phases from config
+	phases, err := buildPhases(cfg, cfgPhases, pxClient)
+	if err != nil {
+		fmt.Printf("❌ Failed to build phases: %v\n", err)
+		return fmt.Errorf("building phases: %w", err)
+	}
+
+	// Create orchestrator
+	logger := &slogLogger{slog.Default()}
+	orch := orchestrator.NewOrchestrator(phases, cfg.Options.StateFile, logger, &noopNotifier{})
+	if obs != nil {
+		orch.SetObserver(obs)
+	}
+	orch.SetDryRun(cfg.Options.DryRun)
+	orch.SetPlan(string(plan), planFingerprint(cfgPhases))
+	orch.SetTrigger(string(trigger))
+	orch.SetStatusFunc(upsStatus)
+	if cfg.Options.DryRun {
+		fmt.Println("🧪 DRY-RUN MODE - Actions will only be simulated")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-abort:
+			orch.Abort()
+		case <-done:
 		}
+	}()
+
+	// Execute shutdown sequence
+	fmt.Println("📋 Executing shutdown phases...")
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer shutdownCancel()
+
+	err = orch.Execute(shutdownCtx, reason)
+	recordSession(ctx, cfg, orch.GetState(), plan, reason)
+	switch {
+	case errors.Is(err, orchestrator.ErrAborted):
+		return recoverAborted(ctx, orch)
+	case ctx.Err() != nil:
+		fmt.Println("⏭️ Shutdown sequence preempted")
+		return ctx.Err()
+	case err != nil:
+		fmt.Printf("❌ Shutdown sequence failed: %v\n", err)
+	default:
+		fmt.Println("✅ Shutdown sequence completed successfully")
+	}
 
-		_ = cfg
-		fmt.Printf("📨 Received NUT event: %s\n", event)
-		return nil
-	},
+	if cfg.Options.DryRun {
+		fmt.Printf("🧪 Dry run: final action (%s) skipped\n", cfg.FinalAction.Type)
+		return daemon.ErrDryRun
+	}
+	if cfg.Simulated() {
+		fmt.Printf("🎭 Simulated UPS: final action (%s) skipped\n", cfg.FinalAction.Type)
+		return daemon.ErrDryRun
+	}
+
+	// Final: tell NUT we are done and power off the Proxmox host itself
+	if err := executeFinalAction(cfg, trigger, reason, delay, abort); err != nil {
+		if errors.Is(err, orchestrator.ErrAborted) {
+			return recoverAborted(ctx, orch)
+		}
+		fmt.Printf("❌ Final action failed: %v\n", err)
+	}
+
+	return nil
+}
+
+// recordSession appends the outcome of a shutdown session to the journal
+func recordSession(ctx context.Context, cfg *Config, session orchestrator.State, plan daemon.Plan, reason string) {
+	if session.SessionID == "" {
+		return
+	}
+
+	status := session.Status
+	switch {
+	case session.DryRun:
+		status = "dry_run"
+	case ctx.Err() != nil && status == "in_progress":
+		status = "preempted"
+	}
+
+	err := journal.New(cfg.Options.JournalFile).Record(journal.Entry{
+		Time:      session.StartedAt,
+		Type:      journal.EntrySession,
+		SessionID: session.SessionID,
+		Plan:      string(plan),
+		Reason:    reason,
+		Status:    status,
+		Duration:  time.Since(session.StartedAt),
+	})
+	if err != nil {
+		fmt.Printf("⚠️ Failed to record the session in the journal: %v\n", err)
+	}
+}
+
+// planPhases returns the configured phases of a plan
+func planPhases(cfg *Config, plan daemon.Plan) []Phase {
+	if plan == daemon.PlanEmergency {
+		return cfg.EmergencyPhases
+	}
+	return cfg.Phases
+}
+
+// planFingerprint identifies the configuration of phases, so that recovery
+// can tell whether they changed since a session ran them
+func planFingerprint(phases []Phase) string {
+	data, err := yaml.Marshal(phases)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recoveryOrchestrator returns an orchestrator loaded with the last
+// session of the state file and the phases it ran, refusing when their
+// configuration changed since
+func recoveryOrchestrator(cfg *Config, pxClient *proxmox.Client, logger orchestrator.Logger, notifier orchestrator.Notifier) (*orchestrator.Orchestrator, *orchestrator.State, error) {
+	session, err := orchestrator.ReadState(cfg.Options.StateFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading state file: %w", err)
+	}
+	if session == nil || len(session.CompletedActions) == 0 {
+		return nil, nil, errors.New("nothing to recover")
+	}
+
+	plan := daemon.Plan(session.Plan)
+	if plan == "" {
+		plan = daemon.PlanGraceful
+	}
+	cfgPhases := planPhases(cfg, plan)
+	fingerprint := planFingerprint(cfgPhases)
+	if session.Fingerprint != "" && session.Fingerprint != fingerprint {
+		return nil, nil, fmt.Errorf("%s plan of session %s: %w", plan, session.SessionID, orchestrator.ErrPlanChanged)
+	}
+
+	phases, err := buildPhases(cfg, cfgPhases, pxClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("building phases: %w", err)
+	}
+	orch := orchestrator.NewOrchestrator(phases, cfg.Options.StateFile, logger, notifier)
+	orch.SetPlan(string(plan), fingerprint)
+	if err := orch.LoadState(); err != nil {
+		return nil, nil, fmt.Errorf("reading state file: %w", err)
+	}
+	return orch, session, nil
+}
+
+// recoverAborted reverses the actions completed before an abort
+func recoverAborted(ctx context.Context, orch *orchestrator.Orchestrator) error {
+	fmt.Println("✅ Power restored, shutdown sequence aborted")
+	fmt.Println("🔄 Recovering completed actions...")
+
+	if err := orch.Recover(ctx); err != nil {
+		fmt.Printf("❌ Recovery failed: %v\n", err)
+	} else {
+		fmt.Println("✅ Recovery completed")
+	}
+
+	return daemon.ErrShutdownAborted
 }
 
 var versionCmd = &cobra.Command{
@@ -290,6 +488,8 @@ func Execute(info BuildInfo) error {
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "/etc/proxmox-guardian/guardian.yaml", "config file path")
 
+	daemonCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if another instance holds the lock file")
+
 	rootCmd.AddCommand(validateCmd)
 	rootCmd.AddCommand(planCmd)
 	rootCmd.AddCommand(daemonCmd)
@@ -306,10 +506,283 @@ func loadConfig() (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	for _, w := range cfg.Warnings() {
+		fmt.Fprintf(os.Stderr, "⚠️ %s\n", w)
+	}
 
 	return cfg, nil
 }
 
+// acquireLock takes the single instance lock (options.lock_file) and
+// returns its release function. With --force, failing to take it only
+// prints a warning.
+func acquireLock(cfg *Config) (func(), error) {
+	l, err := lock.Acquire(cfg.Options.LockFile)
+	if err != nil {
+		if forceLock {
+			fmt.Printf("⚠️ %v, continuing anyway (--force)\n", err)
+			return func() {}, nil
+		}
+		var locked *lock.LockedError
+		if errors.As(err, &locked) {
+			return nil, fmt.Errorf("another instance is running: %w (use --force to override)", err)
+		}
+		return nil, err
+	}
+	return func() { _ = l.Release() }, nil
+}
+
+// upsAddress appends the default port of the source driver to its host
+// when it has none: 3493 for NUT, 3551 for apcupsd and 161 for SNMP
+func upsAddress(src UPSSource) string {
+	if _, _, err := net.SplitHostPort(src.Host); err == nil {
+		return src.Host
+	}
+	switch src.Driver {
+	case ups.DriverApcupsd:
+		return net.JoinHostPort(src.Host, "3551")
+	case ups.DriverSNMP:
+		return net.JoinHostPort(src.Host, "161")
+	}
+	return net.JoinHostPort(src.Host, "3493")
+}
+
+// newUPSGroup creates a driver and monitor for every configured UPS.
+// The returned drivers are in the same order as cfg.UPSSources().
+// onPollError, if not nil, is called with the UPS label when a poll fails.
+func newUPSGroup(cfg *Config, onPollError func(ups string, err error)) (*ups.Group, []ups.Driver, error) {
+	var members []ups.Member
+	var clients []ups.Driver
+
+	for _, src := range cfg.UPSSources() {
+		client, err := newUPSDriver(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		monitor := ups.NewMonitor(client, monitorThresholds(cfg, src))
+		if onPollError != nil {
+			label := src.Label()
+			monitor.OnPollError(func(err error) { onPollError(label, err) })
+		}
+
+		members = append(members, ups.Member{Label: src.Label(), Source: monitor})
+		clients = append(clients, client)
+	}
+
+	return ups.NewGroup(ups.Policy(cfg.UPS.Policy), cfg.UPS.Quorum, members), clients, nil
+}
+
+// newUPSDriver creates the client of the daemon monitoring a configured UPS
+func newUPSDriver(src UPSSource) (ups.Driver, error) {
+	switch src.Driver {
+	case "", ups.DriverNUT:
+		return newNUTClient(src)
+	case ups.DriverApcupsd:
+		return ups.NewApcupsdClient(upsAddress(src), src.Name), nil
+	case ups.DriverSNMP:
+		client, err := ups.NewSNMPClient(snmpClientConfig(src), src.Name)
+		if err != nil {
+			return nil, fmt.Errorf("ups %s: %w", src.Label(), err)
+		}
+		return client, nil
+	case ups.DriverSimulate:
+		if src.Simulate == nil {
+			return nil, fmt.Errorf("ups %s: simulate.scenario is required", src.Label())
+		}
+		scenario, err := ups.LoadScenario(src.Simulate.Scenario)
+		if err != nil {
+			return nil, fmt.Errorf("ups %s: %w", src.Label(), err)
+		}
+		return ups.NewSimulatedClient(scenario, src.Name, src.Simulate.Speed), nil
+	default:
+		return nil, fmt.Errorf("ups %s: unsupported driver %s", src.Label(), src.Driver)
+	}
+}
+
+// lowestStatus returns the status of the UPS with the least charge left,
+// or nil without any
+func lowestStatus(statuses map[string]*ups.Status) *ups.Status {
+	var lowest *ups.Status
+	for _, status := range statuses {
+		if lowest == nil || status.BatteryCharge < lowest.BatteryCharge {
+			lowest = status
+		}
+	}
+	return lowest
+}
+
+// pollUPSStatus returns a function reading the configured UPS units once
+// per call, for phase conditions evaluated without a daemon
+func pollUPSStatus(ctx context.Context, cfg *Config) func() *ups.Status {
+	return func() *ups.Status {
+		statuses := make(map[string]*ups.Status)
+		for _, src := range cfg.UPSSources() {
+			client, err := newUPSDriver(src)
+			if err == nil {
+				err = client.Connect()
+			}
+			if err != nil {
+				fmt.Printf("⚠️ %s: %v\n", src.Label(), err)
+				continue
+			}
+			pollCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			status, err := client.GetStatus(pollCtx)
+			cancel()
+			client.Close()
+			if err != nil {
+				fmt.Printf("⚠️ %s: %v\n", src.Label(), err)
+				continue
+			}
+			statuses[src.Label()] = status
+		}
+		return lowestStatus(statuses)
+	}
+}
+
+// simulateSpeed returns the time compression of a simulated UPS
+func simulateSpeed(sim *SimulateConfig) float64 {
+	if sim.Speed <= 0 {
+		return 1
+	}
+	return sim.Speed
+}
+
+// snmpClientConfig returns the SNMP client settings of a configured UPS
+func snmpClientConfig(src UPSSource) snmp.Config {
+	cfg := snmp.Config{Address: upsAddress(src), Retries: 1}
+	if s := src.SNMP; s != nil {
+		cfg.Version = strings.TrimPrefix(strings.ToLower(s.Version), "v")
+		cfg.Community = s.Community
+		cfg.User = s.User
+		cfg.AuthProtocol = strings.ToUpper(s.AuthProtocol)
+		cfg.AuthPassword = s.AuthPassword
+		cfg.PrivProtocol = strings.ToUpper(s.PrivProtocol)
+		cfg.PrivPassword = s.PrivPassword
+	}
+	return cfg
+}
+
+// newNUTClient creates a NUT client with the credentials and TLS settings
+// of a configured UPS
+func newNUTClient(src UPSSource) (*ups.Client, error) {
+	opts := ups.Options{
+		Username: src.Username,
+		Password: src.Password,
+		Role:     src.Role,
+	}
+
+	if src.TLS != nil {
+		host, _, err := net.SplitHostPort(upsAddress(src))
+		if err != nil {
+			return nil, fmt.Errorf("ups %s: %w", src.Label(), err)
+		}
+		opts.TLS = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: src.TLS.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+		if src.TLS.ServerName != "" {
+			opts.TLS.ServerName = src.TLS.ServerName
+		}
+		if src.TLS.CAFile != "" {
+			pem, err := os.ReadFile(src.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("ups %s: reading CA file: %w", src.Label(), err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("ups %s: no certificate found in %s", src.Label(), src.TLS.CAFile)
+			}
+			opts.TLS.RootCAs = pool
+		}
+	}
+
+	return ups.NewClientWithOptions(upsAddress(src), src.Name, opts), nil
+}
+
+// monitorThresholds returns the monitor thresholds of a configured UPS
+func monitorThresholds(cfg *Config, src UPSSource) ups.Thresholds {
+	minRuntime, _ := cfg.minRuntime(*src.Thresholds)
+	return ups.Thresholds{
+		Warning:    src.Thresholds.Warning,
+		Critical:   src.Thresholds.Critical,
+		Emergency:  src.Thresholds.Emergency,
+		MinRuntime: minRuntime,
+		CommLoss:   cfg.UPS.CommLoss.shutdownAfter(),
+	}
+}
+
+// daemonConfig returns the state machine settings from the configuration
+func daemonConfig(cfg *Config) daemon.Config {
+	return daemon.Config{
+		AbortOnPowerReturn: cfg.Recovery.Enabled,
+		PowerStableDelay:   cfg.Recovery.PowerStableDelay,
+		OnBatteryMax:       cfg.UPS.Thresholds.OnBatteryMax,
+		OnBatteryWarnings:  cfg.UPS.Thresholds.OnBatteryWarnings,
+		EmergencyPlan:      len(cfg.EmergencyPhases) > 0,
+	}
+}
+
+// printPhases prints the phases and actions of a shutdown plan
+func printPhases(phases []Phase) {
+	for i, phase := range phases {
+		mode := "sequential"
+		if phase.Parallel {
+			mode = "parallel"
+		}
+		fmt.Printf("Phase %d: %s (%s)\n", i+1, phase.Name, mode)
+		if phase.Timeout > 0 {
+			fmt.Printf("  Timeout: %s\n", phase.Timeout)
+		}
+		if phase.Condition != "" {
+			fmt.Printf("  Condition: %s\n", phase.Condition)
+		}
+
+		for j, action := range phase.Actions {
+			fmt.Printf("  %d.%d [%s] ", i+1, j+1, action.Type)
+			switch action.Type {
+			case "ssh":
+				fmt.Printf("%s@%s: %s", action.User, action.Host, truncate(action.Command, 40))
+			case "local":
+				fmt.Printf("%s", truncate(action.Command, 50))
+			case "proxmox-guest":
+				fmt.Printf("%s ", action.Action)
+				if action.Selector != nil {
+					if len(action.Selector.VMIDRange) > 0 {
+						fmt.Printf("vmid=%v ", action.Selector.VMIDRange)
+					}
+					if action.Selector.Type != "" {
+						fmt.Printf("type=%s", action.Selector.Type)
+					}
+				}
+			}
+			if action.ID != "" {
+				fmt.Printf(" (id: %s)", action.ID)
+			}
+			if len(action.DependsOn) > 0 {
+				fmt.Printf(" after %s", strings.Join(action.DependsOn, ", "))
+			}
+			fmt.Println()
+		}
+		fmt.Println()
+	}
+
+	graph, err := orchestrator.NewGraph(graphPhases(phases))
+	if err != nil || !graph.Explicit() {
+		return
+	}
+	levels, _ := graph.Levels()
+	fmt.Println("Execution order:")
+	for i, level := range levels {
+		names := make([]string, len(level))
+		for j, ref := range level {
+			names[j] = graph.Name(ref)
+		}
+		fmt.Printf("  %d. %s\n", i+1, strings.Join(names, ", "))
+	}
+	fmt.Println()
+}
+
 func truncate(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -342,11 +815,11 @@ func (n *noopNotifier) Notify(event string, data map[string]interface{}) error {
 	return nil
 }
 
-// buildPhasesFromConfig converts config phases to orchestrator phases
-func buildPhasesFromConfig(cfg *Config, pxClient *proxmox.Client) ([]orchestrator.Phase, error) {
+// buildPhases converts the given config phases to orchestrator phases
+func buildPhases(cfg *Config, cfgPhases []Phase, pxClient *proxmox.Client) ([]orchestrator.Phase, error) {
 	var phases []orchestrator.Phase
 
-	for _, cfgPhase := range cfg.Phases {
+	for _, cfgPhase := range cfgPhases {
 		phase := orchestrator.Phase{
 			Name:      cfgPhase.Name,
 			Parallel:  cfgPhase.Parallel,
@@ -360,12 +833,17 @@ func buildPhasesFromConfig(cfg *Config, pxClient *proxmox.Client) ([]orchestrato
 			if err != nil {
 				return nil, fmt.Errorf("creating executor for action in phase %s: %w", cfgPhase.Name, err)
 			}
+			if guests, ok := exec.(*executor.ProxmoxGuestExecutor); ok {
+				guests.Parallel = cfgPhase.Parallel
+			}
 
 			action := orchestrator.Action{
-				Type:     cfgAction.Type,
-				Executor: exec,
-				Recovery: cfgAction.Recovery,
-				OnError:  cfgAction.OnError,
+				Type:      cfgAction.Type,
+				Executor:  exec,
+				Recovery:  cfgAction.recovery(),
+				OnError:   cfgAction.OnError,
+				ID:        cfgAction.ID,
+				DependsOn: cfgAction.DependsOn,
 			}
 
 			if cfgAction.Retry != nil {
@@ -388,18 +866,20 @@ func buildPhasesFromConfig(cfg *Config, pxClient *proxmox.Client) ([]orchestrato
 func createExecutor(cfg *Config, action Action, pxClient *proxmox.Client) (executor.Executor, error) {
 	timeout := action.Timeout
 	if timeout == 0 {
-		timeout = 60 * time.Second
+		timeout = defaultActionTimeout
 	}
 
 	switch action.Type {
 	case "ssh":
 		exec := executor.NewSSHExecutor(action.Host, action.User, action.Command)
 		exec.Timeout = timeout
+		exec.Recovery = action.Recovery
 		return exec, nil
 
 	case "local":
 		exec := executor.NewLocalExecutor(action.Command)
 		exec.Timeout = timeout
+		exec.Recovery = action.Recovery
 		return exec, nil
 
 	case "proxmox-guest":
@@ -419,6 +899,7 @@ func createExecutor(cfg *Config, action Action, pxClient *proxmox.Client) (execu
 		adapter := &proxmoxAPIAdapter{client: pxClient}
 		exec := executor.NewProxmoxGuestExecutor(selector, action.Action, adapter)
 		exec.Timeout = timeout
+		exec.Recovery = action.recovery()
 		return exec, nil
 
 	default:
@@ -436,9 +917,34 @@ func (a *proxmoxAPIAdapter) ExecInGuest(ctx context.Context, guestType, guestID,
 }
 
 func (a *proxmoxAPIAdapter) ShutdownGuest(ctx context.Context, guestType, guestID string, timeout time.Duration) error {
+	vmid, node, err := a.findGuest(ctx, guestType, guestID)
+	if err != nil {
+		return err
+	}
+	return a.client.ShutdownGuest(ctx, guestType, vmid, node, timeout)
+}
+
+func (a *proxmoxAPIAdapter) StopGuest(ctx context.Context, guestType, guestID string) error {
+	vmid, node, err := a.findGuest(ctx, guestType, guestID)
+	if err != nil {
+		return err
+	}
+	return a.client.StopGuest(ctx, guestType, vmid, node)
+}
+
+func (a *proxmoxAPIAdapter) StartGuest(ctx context.Context, guestType, guestID string) error {
+	vmid, node, err := a.findGuest(ctx, guestType, guestID)
+	if err != nil {
+		return err
+	}
+	return a.client.StartGuest(ctx, guestType, vmid, node)
+}
+
+// findGuest returns the VMID of a guest and the node it is on
+func (a *proxmoxAPIAdapter) findGuest(ctx context.Context, guestType, guestID string) (int, string, error) {
 	vmid, err := strconv.Atoi(guestID)
 	if err != nil {
-		return fmt.Errorf("invalid guest ID %s: %w", guestID, err)
+		return 0, "", fmt.Errorf("invalid guest ID %s: %w", guestID, err)
 	}
 
 	selector := proxmox.GuestSelector{
@@ -447,13 +953,12 @@ func (a *proxmoxAPIAdapter) ShutdownGuest(ctx context.Context, guestType, guestI
 	}
 	guests, err := a.client.GetGuestsBySelector(ctx, selector)
 	if err != nil {
-		return fmt.Errorf("finding guest %d: %w", vmid, err)
+		return 0, "", fmt.Errorf("finding guest %d: %w", vmid, err)
 	}
 	if len(guests) == 0 {
-		return fmt.Errorf("guest %d not found", vmid)
+		return 0, "", fmt.Errorf("guest %d not found", vmid)
 	}
-
-	return a.client.ShutdownGuest(ctx, guestType, vmid, guests[0].Node, timeout)
+	return vmid, guests[0].Node, nil
 }
 
 func (a *proxmoxAPIAdapter) GetGuestsBySelector(ctx context.Context, selector executor.GuestSelector) ([]executor.Guest, error) {
@@ -473,36 +978,21 @@ func (a *proxmoxAPIAdapter) GetGuestsBySelector(ctx context.Context, selector ex
 	var guests []executor.Guest
 	for _, g := range pxGuests {
 		guests = append(guests, executor.Guest{
-Type:   g.Type,
-VMID:   g.VMID,
-Name:   g.Name,
-Node:   g.Node,
-Status: g.Status,
-Tags:   g.Tags,
-})
+			Type:   g.Type,
+			VMID:   g.VMID,
+			Name:   g.Name,
+			Node:   g.Node,
+			Status: g.Status,
+			Tags:   g.Tags,
+		})
 	}
 
 	return guests, nil
 }
 
-// executeHostShutdown initiates the Proxmox host shutdown
-func executeHostShutdown() error {
-	fmt.Println("⏳ Waiting 10 seconds before host shutdown...")
-	time.Sleep(10 * time.Second)
-
-	exec := executor.NewLocalExecutor("shutdown -h now 'UPS battery critical - emergency shutdown'")
-	exec.Timeout = 30 * time.Second
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// hostShutdownDelay is the default pause before the final host action
+const hostShutdownDelay = 10 * time.Second
 
-	result, err := exec.Execute(ctx)
-	if err != nil {
-		return err
-	}
-	if !result.Success {
-		return fmt.Errorf("shutdown command failed: %s", result.Error)
-	}
-
-	return nil
-}
+// defaultCommLossTimeout is how long a UPS on battery may stop answering
+// before it is assumed critical
+const defaultCommLossTimeout = time.Minute
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+)
+
+func TestRecoveryOrchestrator(t *testing.T) {
+	cfg := &Config{
+		Phases: []Phase{{Name: "graceful", Actions: []Action{
+			{Type: "local", Command: "true", Recovery: "echo graceful"},
+		}}},
+		EmergencyPhases: []Phase{{Name: "emergency", Actions: []Action{
+			{Type: "local", Command: "true", Recovery: "echo emergency"},
+		}}},
+		Options: OptionsConfig{StateFile: filepath.Join(t.TempDir(), "state.json")},
+	}
+
+	if _, _, err := recoveryOrchestrator(cfg, nil, &testLogger{}, nil); err == nil {
+		t.Error("Expected an error without a recorded session")
+	}
+
+	// Run the emergency plan, as runShutdown does
+	phases, err := buildPhases(cfg, cfg.EmergencyPhases, nil)
+	if err != nil {
+		t.Fatalf("buildPhases failed: %v", err)
+	}
+	orch := orchestrator.NewOrchestrator(phases, cfg.Options.StateFile, &testLogger{}, nil)
+	orch.SetDryRun(true)
+	orch.SetPlan(string(daemon.PlanEmergency), planFingerprint(cfg.EmergencyPhases))
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// The changed graceful plan does not prevent recovering the session
+	cfg.Phases[0].Actions[0].Recovery = "echo changed"
+	if _, session, err := recoveryOrchestrator(cfg, nil, &testLogger{}, nil); err != nil {
+		t.Errorf("Expected the emergency session to be recoverable, got %v", err)
+	} else if session.Plan != string(daemon.PlanEmergency) {
+		t.Errorf("Expected an emergency session, got %q", session.Plan)
+	}
+
+	cfg.EmergencyPhases[0].Actions[0].Recovery = "echo changed"
+	if _, _, err := recoveryOrchestrator(cfg, nil, &testLogger{}, nil); !errors.Is(err, orchestrator.ErrPlanChanged) {
+		t.Errorf("Expected ErrPlanChanged, got %v", err)
+	}
+}
+
+func TestShutdownInterruptsRecovery(t *testing.T) {
+	guard := &recoveryGuard{}
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+
+	err := guard.start(context.Background(), func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+	})
+	if err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	<-started
+	if err := guard.start(context.Background(), func(context.Context) {}); err == nil {
+		t.Error("Expected a second recovery to be refused")
+	}
+
+	// The shutdown only proceeds once the recovery has stopped
+	release := guard.hold()
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Expected the recovery to be stopped before the shutdown runs")
+	}
+	if err := guard.start(context.Background(), func(context.Context) {}); err == nil {
+		t.Error("Expected recovery to be refused during a shutdown")
+	}
+
+	release()
+	done := make(chan struct{})
+	if err := guard.start(context.Background(), func(context.Context) { close(done) }); err != nil {
+		t.Errorf("Expected recovery after the shutdown, got %v", err)
+	}
+	<-done
+}
+
+func TestConfigStoreSwap(t *testing.T) {
+	first := &Config{}
+	store := &configStore{cfg: first}
+
+	// Concurrent reloads each get the configuration they replaced
+	const reloads = 10
+	olds := make(chan *Config, reloads)
+	var wg sync.WaitGroup
+	for i := 0; i < reloads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			olds <- store.Swap(&Config{})
+		}()
+	}
+	wg.Wait()
+	close(olds)
+
+	seen := map[*Config]bool{store.Get(): true}
+	for old := range olds {
+		if seen[old] {
+			t.Fatal("Expected each reload to replace a different configuration")
+		}
+		seen[old] = true
+	}
+	if !seen[first] || len(seen) != reloads+1 {
+		t.Errorf("Expected %d distinct configurations including the first, got %d", reloads+1, len(seen))
+	}
+}
+
+type testLogger struct{}
+
+func (l *testLogger) Info(msg string, fields ...interface{})  {}
+func (l *testLogger) Error(msg string, fields ...interface{}) {}
+func (l *testLogger) Debug(msg string, fields ...interface{}) {}
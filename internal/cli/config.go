@@ -1,21 +1,42 @@
 package cli
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/condition"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/snmp"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the main configuration structure
 type Config struct {
-	UPS           UPSConfig            `yaml:"ups"`
-	Proxmox       ProxmoxConfig        `yaml:"proxmox"`
-	Phases        []Phase              `yaml:"phases"`
-	Recovery      RecoveryConfig       `yaml:"recovery"`
-	Notifications []NotificationConfig `yaml:"notifications"`
-	Options       OptionsConfig        `yaml:"options"`
+	UPS     UPSConfig     `yaml:"ups"`
+	Proxmox ProxmoxConfig `yaml:"proxmox"`
+	Phases  []Phase       `yaml:"phases"`
+	// EmergencyPhases is a short plan run instead of Phases at the
+	// emergency threshold or on FSD
+	EmergencyPhases []Phase              `yaml:"emergency_phases,omitempty"`
+	Recovery        RecoveryConfig       `yaml:"recovery"`
+	FinalAction     FinalActionConfig    `yaml:"final_action,omitempty"`
+	Notifications   []NotificationConfig `yaml:"notifications"`
+	Options         OptionsConfig        `yaml:"options"`
+
+	// warnings are problems found while loading that do not prevent
+	// running, such as deprecated settings
+	warnings []string
+}
+
+// Warnings returns the problems found while loading the configuration
+func (c *Config) Warnings() []string {
+	return c.warnings
 }
 
 // UPSConfig holds NUT connection settings
@@ -24,6 +45,122 @@ type UPSConfig struct {
 	Host       string        `yaml:"host"`
 	Name       string        `yaml:"name"`
 	Thresholds UPSThresholds `yaml:"thresholds"`
+
+	// Sources lists several UPS units monitored independently. When empty,
+	// Host and Name describe the only UPS.
+	Sources []UPSSource `yaml:"sources,omitempty"`
+	Policy  string      `yaml:"policy,omitempty"` // "any", "all" or "quorum"
+	Quorum  int         `yaml:"quorum,omitempty"`
+
+	// CommLoss decides what to do when a UPS on battery stops answering
+	CommLoss CommLossConfig `yaml:"comm_loss,omitempty"`
+
+	NUTAuth `yaml:",inline"`
+	// SNMP configures the snmp driver
+	SNMP *SNMPConfig `yaml:"snmp,omitempty"`
+	// Simulate configures the simulate driver
+	Simulate *SimulateConfig `yaml:"simulate,omitempty"`
+}
+
+// NUTAuth holds NUT credentials, login role and TLS settings. The password
+// is best kept in the secrets file.
+type NUTAuth struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Role logs in as a "primary" or "secondary" upsmon so that upsd
+	// counts this host as a client
+	Role string        `yaml:"role,omitempty"`
+	TLS  *NUTTLSConfig `yaml:"tls,omitempty"`
+}
+
+// NUTTLSConfig enables STARTTLS. The server certificate is verified against
+// CAFile, or the system roots when empty.
+type NUTTLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// SNMPConfig holds the SNMP settings of the snmp driver. The community
+// and passwords are best kept in the secrets file.
+type SNMPConfig struct {
+	Version   string `yaml:"version,omitempty"` // "2c" (default) or "3"
+	Community string `yaml:"community,omitempty"`
+
+	// SNMPv3 user-based security
+	User         string `yaml:"user,omitempty"`
+	AuthProtocol string `yaml:"auth_protocol,omitempty"` // MD5 or SHA
+	AuthPassword string `yaml:"auth_password,omitempty"`
+	PrivProtocol string `yaml:"priv_protocol,omitempty"` // DES or AES
+	PrivPassword string `yaml:"priv_password,omitempty"`
+}
+
+// SimulateConfig configures the simulate driver, which replays a scenario
+// file instead of reading a UPS so that outages can be rehearsed
+type SimulateConfig struct {
+	Scenario string `yaml:"scenario"` // YAML or CSV timeline
+	// Speed compresses time: 60 replays a minute of the scenario per second
+	Speed float64 `yaml:"speed,omitempty"`
+}
+
+// Comm loss actions
+const (
+	CommLossShutdown = "shutdown"
+	CommLossWait     = "wait"
+)
+
+// CommLossConfig is the policy for a UPS that stops answering while on
+// battery. Guardian reconnects in the background either way.
+type CommLossConfig struct {
+	// Action is "shutdown" (default) to assume the worst and treat the UPS
+	// as critical after Timeout, or "wait" to keep waiting for it
+	Action  string        `yaml:"action,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// shutdownAfter returns how long a UPS on battery may stay silent before
+// it counts as critical, or 0 to wait forever
+func (c CommLossConfig) shutdownAfter() time.Duration {
+	if c.Action == CommLossWait {
+		return 0
+	}
+	if c.Timeout == 0 {
+		return defaultCommLossTimeout
+	}
+	return c.Timeout
+}
+
+// UPSSource is one UPS unit in a multi-UPS setup
+type UPSSource struct {
+	Driver string `yaml:"driver,omitempty"`
+	Host   string `yaml:"host"`
+	Name   string `yaml:"name"`
+	// Thresholds overrides the non-zero fields of ups.thresholds
+	Thresholds *UPSThresholds `yaml:"thresholds,omitempty"`
+	// NUTAuth overrides the non-empty fields of the ups credentials
+	NUTAuth `yaml:",inline"`
+	// SNMP replaces ups.snmp
+	SNMP *SNMPConfig `yaml:"snmp,omitempty"`
+	// Simulate replaces ups.simulate
+	Simulate *SimulateConfig `yaml:"simulate,omitempty"`
+}
+
+// driverName returns the name of the source driver for display
+func driverName(src UPSSource) string {
+	switch src.Driver {
+	case ups.DriverApcupsd:
+		return "apcupsd"
+	case ups.DriverSNMP:
+		return "SNMP"
+	case ups.DriverSimulate:
+		return "simulated"
+	}
+	return "NUT"
+}
+
+// Label returns a human-readable identifier for the source
+func (s UPSSource) Label() string {
+	return s.Name + "@" + s.Host
 }
 
 // UPSThresholds defines battery level thresholds
@@ -31,6 +168,17 @@ type UPSThresholds struct {
 	Warning   int `yaml:"warning"`
 	Critical  int `yaml:"critical"`
 	Emergency int `yaml:"emergency"`
+
+	// MinRuntime triggers shutdown when battery.runtime drops below it.
+	// Either a duration, "auto" to use the estimated shutdown duration,
+	// or empty to disable the runtime trigger.
+	MinRuntime    string        `yaml:"min_runtime,omitempty"`
+	RuntimeMargin time.Duration `yaml:"runtime_margin,omitempty"`
+
+	// OnBatteryMax triggers shutdown after this long on battery regardless
+	// of charge. OnBatteryWarnings send notifications at intermediate marks.
+	OnBatteryMax      time.Duration   `yaml:"on_battery_max,omitempty"`
+	OnBatteryWarnings []time.Duration `yaml:"on_battery_warnings,omitempty"`
 }
 
 // ProxmoxConfig holds Proxmox API connection settings
@@ -66,6 +214,10 @@ type Action struct {
 	OnError     string            `yaml:"on_error,omitempty"`
 	Retry       *RetryConfig      `yaml:"retry,omitempty"`
 	Env         map[string]string `yaml:"env,omitempty"`
+	// ID names the action in the depends_on of other actions
+	ID string `yaml:"id,omitempty"`
+	// DependsOn lists the actions to wait for, in place of the phase order
+	DependsOn []string `yaml:"depends_on,omitempty"`
 }
 
 // GuestSelector defines how to select Proxmox guests
@@ -97,6 +249,151 @@ type RecoveryConfig struct {
 	OnError          string        `yaml:"on_error"`
 }
 
+// Final action types
+const (
+	FinalPoweroff       = "poweroff"
+	FinalHalt           = "halt"
+	FinalRebootOnReturn = "reboot-on-return"
+	FinalCommand        = "command"
+	FinalNone           = "none"
+)
+
+// FinalActionConfig defines what happens to the host once the phases are done
+type FinalActionConfig struct {
+	// Type is "poweroff" (default), "halt", "reboot-on-return" (poweroff and
+	// ask the UPS to cycle the load when power returns), "command" or "none"
+	Type    string `yaml:"type,omitempty"`
+	Command string `yaml:"command,omitempty"`
+	// Delay before the final action, 10s if unset. The emergency plan
+	// never waits.
+	Delay *time.Duration `yaml:"delay,omitempty"`
+	// NUT tells the UPS this host is done so it can switch off cleanly
+	NUT *FinalNUTConfig `yaml:"nut,omitempty"`
+}
+
+// FinalNUTConfig lists the NUT commands sent to every UPS before the final
+// action. Most of them require a NUT user allowed to run them.
+type FinalNUTConfig struct {
+	// SetVars are written first, e.g. ups.delay.shutdown: "120"
+	SetVars map[string]string `yaml:"set_vars,omitempty"`
+	// InstCmd is an instant command such as "shutdown.return". Defaults to
+	// "shutdown.return" with the reboot-on-return type. It is sent before
+	// the host shuts down, so ups.delay.shutdown must be set and leave the
+	// host time to finish.
+	InstCmd string `yaml:"instcmd,omitempty"`
+	// FSD raises the forced shutdown flag so that secondary hosts shut
+	// down too and the primary upsmon switches the UPS off
+	FSD bool `yaml:"fsd,omitempty"`
+	// PowerdownFlag is the POWERDOWNFLAG of upsmon.conf, e.g.
+	// /etc/killpower. Creating it has the NUT shutdown hook switch the UPS
+	// off at the very end of the host shutdown, in place of InstCmd. The
+	// UPS must be attached to this host.
+	PowerdownFlag string `yaml:"powerdown_flag,omitempty"`
+	// HostShutdownTime is how long the host takes to power off once the
+	// final action starts, 60s if unset
+	HostShutdownTime *time.Duration `yaml:"host_shutdown_time,omitempty"`
+}
+
+// defaultHostShutdownTime is the assumed time the host takes to power off
+const defaultHostShutdownTime = time.Minute
+
+// powerOffMargin is the least time ups.delay.shutdown must leave beyond
+// the host shutdown time
+const powerOffMargin = 30 * time.Second
+
+// immediatePowerOffCommands cut the UPS output without waiting for
+// ups.delay.shutdown
+var immediatePowerOffCommands = map[string]bool{
+	"load.off":        true,
+	"load.cycle":      true,
+	"shutdown.reboot": true,
+}
+
+// delay returns the pause before the final action
+func (f FinalActionConfig) delay() time.Duration {
+	if f.Delay == nil {
+		return hostShutdownDelay
+	}
+	return *f.Delay
+}
+
+// instCmd returns the NUT instant command to send, if any
+func (f FinalActionConfig) instCmd() string {
+	if f.NUT != nil && f.NUT.InstCmd != "" {
+		return f.NUT.InstCmd
+	}
+	if f.Type == FinalRebootOnReturn && (f.NUT == nil || f.NUT.PowerdownFlag == "") {
+		return "shutdown.return"
+	}
+	return ""
+}
+
+// hostShutdownTime returns how long the host takes to power off
+func (f FinalActionConfig) hostShutdownTime() time.Duration {
+	if f.NUT == nil || f.NUT.HostShutdownTime == nil {
+		return defaultHostShutdownTime
+	}
+	return *f.NUT.HostShutdownTime
+}
+
+// validatePowerOff checks that an instant command sent before the host
+// shuts down cannot cut its power while it is still shutting down
+func (f FinalActionConfig) validatePowerOff() error {
+	instCmd := f.instCmd()
+	if instCmd == "" {
+		return nil
+	}
+	if f.NUT != nil && f.NUT.PowerdownFlag != "" {
+		return fmt.Errorf("final_action.nut: use either instcmd or powerdown_flag")
+	}
+	if immediatePowerOffCommands[instCmd] {
+		return fmt.Errorf("final_action.nut.instcmd %s cuts power before the host is down, use shutdown.return or powerdown_flag", instCmd)
+	}
+	if !strings.HasPrefix(instCmd, "shutdown.") && !strings.HasPrefix(instCmd, "load.") {
+		return nil
+	}
+
+	var value string
+	if f.NUT != nil {
+		value = f.NUT.SetVars["ups.delay.shutdown"]
+	}
+	if value == "" {
+		return fmt.Errorf("final_action.nut.set_vars must set ups.delay.shutdown with instcmd %s, or use powerdown_flag", instCmd)
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return fmt.Errorf("final_action.nut.set_vars: invalid ups.delay.shutdown %q", value)
+	}
+	need := f.hostShutdownTime() + powerOffMargin
+	if delay := time.Duration(seconds) * time.Second; delay < need {
+		return fmt.Errorf("final_action.nut.set_vars: ups.delay.shutdown %s leaves the host no time to shut down, use at least %s",
+			delay, need)
+	}
+	return nil
+}
+
+func (f FinalActionConfig) validate() error {
+	switch f.Type {
+	case "", FinalPoweroff, FinalHalt, FinalRebootOnReturn, FinalNone:
+		if f.Command != "" {
+			return fmt.Errorf("final_action.command is only used with type %s", FinalCommand)
+		}
+	case FinalCommand:
+		if f.Command == "" {
+			return fmt.Errorf("final_action.command is required with type %s", FinalCommand)
+		}
+	default:
+		return fmt.Errorf("invalid final_action.type: %s", f.Type)
+	}
+	if f.Delay != nil && *f.Delay < 0 {
+		return fmt.Errorf("final_action.delay must not be negative")
+	}
+	if f.NUT != nil && f.NUT.HostShutdownTime != nil && *f.NUT.HostShutdownTime <= 0 {
+		return fmt.Errorf("final_action.nut.host_shutdown_time must be positive")
+	}
+	return f.validatePowerOff()
+}
+
 // NotificationConfig defines notification channels
 type NotificationConfig struct {
 	Type     string   `yaml:"type"`
@@ -114,6 +411,18 @@ type OptionsConfig struct {
 	LogFile   string `yaml:"log_file"`
 	StateFile string `yaml:"state_file"`
 	LockFile  string `yaml:"lock_file"`
+	// JournalFile records every outage and shutdown session for `history`
+	JournalFile string `yaml:"journal_file"`
+	// ControlSocket is the Unix socket the daemon listens on for commands
+	// such as NUT notifications forwarded by `notify`. ControlListen
+	// optionally serves the same API on a loopback TCP address, to clients
+	// sending ControlToken as a bearer token.
+	ControlSocket string `yaml:"control_socket"`
+	ControlListen string `yaml:"control_listen,omitempty"`
+	ControlToken  string `yaml:"control_token,omitempty"`
+	// MetricsListen is the address serving Prometheus metrics on /metrics,
+	// e.g. "0.0.0.0:9580". Empty disables it.
+	MetricsListen string `yaml:"metrics_listen,omitempty"`
 }
 
 // LoadConfig loads and parses the configuration file
@@ -138,9 +447,30 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Options.StateFile == "" {
 		cfg.Options.StateFile = "/var/lib/proxmox-guardian/state.json"
 	}
+	if cfg.Options.JournalFile == "" {
+		cfg.Options.JournalFile = "/var/lib/proxmox-guardian/journal.jsonl"
+	}
 	if cfg.Options.LockFile == "" {
 		cfg.Options.LockFile = "/var/run/proxmox-guardian.lock"
 	}
+	if cfg.Options.ControlSocket == "" {
+		cfg.Options.ControlSocket = "/var/run/proxmox-guardian.sock"
+	}
+	if cfg.UPS.Thresholds.RuntimeMargin == 0 {
+		cfg.UPS.Thresholds.RuntimeMargin = 60 * time.Second
+	}
+	if cfg.UPS.Policy == "" {
+		cfg.UPS.Policy = "any"
+	}
+	if cfg.FinalAction.Type == "" {
+		cfg.FinalAction.Type = FinalPoweroff
+	}
+
+	if cfg.Proxmox.SecretsFile != "" {
+		if err := cfg.loadSecrets(cfg.Proxmox.SecretsFile); err != nil {
+			return nil, err
+		}
+	}
 
 	// Validate config
 	if err := cfg.Validate(); err != nil {
@@ -150,13 +480,124 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// Secrets holds the credentials kept out of the main configuration file
+type Secrets struct {
+	Proxmox struct {
+		TokenSecret string `yaml:"token_secret"`
+	} `yaml:"proxmox"`
+	NUT struct {
+		// Password is used by every UPS without one of its own
+		Password string `yaml:"password"`
+		// Passwords maps a UPS name@host label to its password
+		Passwords map[string]string `yaml:"passwords,omitempty"`
+	} `yaml:"nut"`
+	// Control holds the token of the control API over TCP
+	Control struct {
+		Token string `yaml:"token,omitempty"`
+	} `yaml:"control"`
+	// SNMP fills the empty fields of every ups.snmp section
+	SNMP struct {
+		Community    string `yaml:"community,omitempty"`
+		AuthPassword string `yaml:"auth_password,omitempty"`
+		PrivPassword string `yaml:"priv_password,omitempty"`
+	} `yaml:"snmp"`
+
+	// LegacyTokenSecret is the former top-level place of Proxmox.TokenSecret
+	LegacyTokenSecret string `yaml:"proxmox_token_secret,omitempty"`
+}
+
+// loadSecrets fills the credentials missing from the configuration with
+// those of the secrets file, which must not be readable by other users.
+// A missing or exposed file is only skipped with a warning when the
+// configuration holds the Proxmox token secret itself.
+func (c *Config) loadSecrets(path string) error {
+	required := c.Proxmox.TokenSecret == ""
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && !required {
+		c.warnings = append(c.warnings, fmt.Sprintf("secrets file %s not found, skipping it", path))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading secrets file: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		if !required {
+			c.warnings = append(c.warnings, fmt.Sprintf(
+				"secrets file %s is accessible by group or others (mode %04o), skipping it; run chmod 600 %s", path, perm, path))
+			return nil
+		}
+		return fmt.Errorf("secrets file %s must not be accessible by group or others (mode %04o), run chmod 600 %s", path, perm, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading secrets file: %w", err)
+	}
+	// A misspelled key would silently leave a credential empty
+	var secrets Secrets
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&secrets); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing secrets file: %w", err)
+	}
+
+	if secrets.LegacyTokenSecret != "" {
+		c.warnings = append(c.warnings, fmt.Sprintf(
+			"%s: proxmox_token_secret is deprecated, move it to proxmox.token_secret", path))
+		if secrets.Proxmox.TokenSecret == "" {
+			secrets.Proxmox.TokenSecret = secrets.LegacyTokenSecret
+		}
+	}
+
+	if c.Proxmox.TokenSecret == "" {
+		c.Proxmox.TokenSecret = secrets.Proxmox.TokenSecret
+	}
+	if c.Options.ControlToken == "" {
+		c.Options.ControlToken = secrets.Control.Token
+	}
+	if c.UPS.Password == "" {
+		c.UPS.Password = secrets.NUT.Password
+		if len(c.UPS.Sources) == 0 {
+			label := UPSSource{Host: c.UPS.Host, Name: c.UPS.Name}.Label()
+			if p, ok := secrets.NUT.Passwords[label]; ok {
+				c.UPS.Password = p
+			}
+		}
+	}
+	for i := range c.UPS.Sources {
+		src := &c.UPS.Sources[i]
+		if p, ok := secrets.NUT.Passwords[src.Label()]; ok && src.Password == "" {
+			src.Password = p
+		}
+	}
+
+	snmpConfigs := []*SNMPConfig{c.UPS.SNMP}
+	for _, src := range c.UPS.Sources {
+		snmpConfigs = append(snmpConfigs, src.SNMP)
+	}
+	for _, sc := range snmpConfigs {
+		if sc == nil {
+			continue
+		}
+		if sc.Community == "" {
+			sc.Community = secrets.SNMP.Community
+		}
+		if sc.AuthPassword == "" {
+			sc.AuthPassword = secrets.SNMP.AuthPassword
+		}
+		if sc.PrivPassword == "" {
+			sc.PrivPassword = secrets.SNMP.PrivPassword
+		}
+	}
+
+	return nil
+}
+
 // Validate checks the configuration for errors
 func (c *Config) Validate() error {
-	if c.UPS.Host == "" {
-		return fmt.Errorf("ups.host is required")
-	}
-	if c.UPS.Name == "" {
-		return fmt.Errorf("ups.name is required")
+	if err := c.UPS.validate(); err != nil {
+		return err
 	}
 	if c.Proxmox.APIURL == "" {
 		return fmt.Errorf("proxmox.api_url is required")
@@ -164,21 +605,326 @@ func (c *Config) Validate() error {
 	if c.Proxmox.TokenID == "" {
 		return fmt.Errorf("proxmox.token_id is required")
 	}
+	if c.Options.ControlListen != "" && c.Options.ControlToken == "" {
+		return fmt.Errorf("options.control_token is required with options.control_listen")
+	}
 	if len(c.Phases) == 0 {
 		return fmt.Errorf("at least one phase is required")
 	}
+	for _, src := range c.UPSSources() {
+		switch src.Driver {
+		case "", ups.DriverNUT:
+		case ups.DriverApcupsd:
+			// A password from the secrets file is harmlessly ignored
+			if src.Username != "" || src.Role != "" || src.TLS != nil {
+				return fmt.Errorf("ups %s: username, role and tls are not supported by apcupsd", src.Label())
+			}
+		case ups.DriverSNMP:
+			if src.Username != "" || src.Role != "" || src.TLS != nil {
+				return fmt.Errorf("ups %s: username, role and tls are not supported by snmp, use ups.snmp", src.Label())
+			}
+			if _, err := snmp.NewClient(snmpClientConfig(src)); err != nil {
+				return fmt.Errorf("ups %s: %w", src.Label(), err)
+			}
+		case ups.DriverSimulate:
+			if src.Username != "" || src.Role != "" || src.TLS != nil {
+				return fmt.Errorf("ups %s: username, role and tls are not supported by simulate", src.Label())
+			}
+			if src.Simulate == nil || src.Simulate.Scenario == "" {
+				return fmt.Errorf("ups %s: simulate.scenario is required", src.Label())
+			}
+			if src.Simulate.Speed < 0 {
+				return fmt.Errorf("ups %s: simulate.speed must not be negative", src.Label())
+			}
+		default:
+			return fmt.Errorf("ups %s: invalid driver %s (nut, apcupsd, snmp or simulate)", src.Label(), src.Driver)
+		}
+		if src.Role != "" && src.Username == "" {
+			return fmt.Errorf("ups %s: role %s requires a NUT username", src.Label(), src.Role)
+		}
+		if err := src.Thresholds.validate(); err != nil {
+			return fmt.Errorf("ups %s: %w", src.Label(), err)
+		}
+		if _, err := c.minRuntime(*src.Thresholds); err != nil {
+			return fmt.Errorf("ups %s: %w", src.Label(), err)
+		}
+	}
+	for _, mark := range c.UPS.Thresholds.OnBatteryWarnings {
+		if mark <= 0 {
+			return fmt.Errorf("ups.thresholds.on_battery_warnings must be positive durations")
+		}
+		if c.UPS.Thresholds.OnBatteryMax > 0 && mark >= c.UPS.Thresholds.OnBatteryMax {
+			return fmt.Errorf("ups.thresholds.on_battery_warnings mark %s must be below on_battery_max", mark)
+		}
+	}
+
+	if err := c.FinalAction.validate(); err != nil {
+		return err
+	}
+
+	if err := validatePhases("phase", c.Phases); err != nil {
+		return err
+	}
+	if err := validatePhases("emergency phase", c.EmergencyPhases); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u UPSConfig) validate() error {
+	if len(u.Sources) == 0 {
+		// A simulated UPS has no host
+		if u.Host == "" && u.Driver != ups.DriverSimulate {
+			return fmt.Errorf("ups.host is required")
+		}
+		if u.Name == "" {
+			return fmt.Errorf("ups.name is required")
+		}
+	}
+
+	if err := u.NUTAuth.validate("ups"); err != nil {
+		return err
+	}
+	for i, src := range u.Sources {
+		driver := src.Driver
+		if driver == "" {
+			driver = u.Driver
+		}
+		if src.Host == "" && driver != ups.DriverSimulate {
+			return fmt.Errorf("ups.sources[%d]: host is required", i)
+		}
+		if src.Name == "" {
+			return fmt.Errorf("ups.sources[%d]: name is required", i)
+		}
+		if err := src.NUTAuth.validate(fmt.Sprintf("ups.sources[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	switch u.CommLoss.Action {
+	case "", CommLossShutdown, CommLossWait:
+	default:
+		return fmt.Errorf("invalid ups.comm_loss.action: %s", u.CommLoss.Action)
+	}
+	if u.CommLoss.Timeout < 0 {
+		return fmt.Errorf("ups.comm_loss.timeout must not be negative")
+	}
+
+	switch u.Policy {
+	case "", "any", "all":
+	case "quorum":
+		sources := len(u.Sources)
+		if sources == 0 {
+			sources = 1
+		}
+		if u.Quorum < 1 || u.Quorum > sources {
+			return fmt.Errorf("ups.quorum must be between 1 and %d", sources)
+		}
+	default:
+		return fmt.Errorf("invalid ups.policy: %s", u.Policy)
+	}
+
+	return nil
+}
+
+func (a NUTAuth) validate(prefix string) error {
+	switch a.Role {
+	case "", "primary", "secondary":
+	default:
+		return fmt.Errorf("%s.role must be primary or secondary", prefix)
+	}
+	return nil
+}
+
+// UPSSources returns every configured UPS with thresholds resolved against
+// the global ups.thresholds
+func (c *Config) UPSSources() []UPSSource {
+	if len(c.UPS.Sources) == 0 {
+		thresholds := c.UPS.Thresholds
+		return []UPSSource{{
+			Driver:     c.UPS.Driver,
+			Host:       simulatedHost(c.UPS.Driver, c.UPS.Host),
+			Name:       c.UPS.Name,
+			Thresholds: &thresholds,
+			NUTAuth:    c.UPS.NUTAuth,
+			SNMP:       c.UPS.SNMP,
+			Simulate:   c.UPS.Simulate,
+		}}
+	}
+
+	sources := make([]UPSSource, len(c.UPS.Sources))
+	for i, src := range c.UPS.Sources {
+		thresholds := c.UPS.Thresholds
+		if o := src.Thresholds; o != nil {
+			if o.Warning != 0 {
+				thresholds.Warning = o.Warning
+			}
+			if o.Critical != 0 {
+				thresholds.Critical = o.Critical
+			}
+			if o.Emergency != 0 {
+				thresholds.Emergency = o.Emergency
+			}
+			if o.MinRuntime != "" {
+				thresholds.MinRuntime = o.MinRuntime
+			}
+			if o.RuntimeMargin != 0 {
+				thresholds.RuntimeMargin = o.RuntimeMargin
+			}
+		}
+		if src.Driver == "" {
+			src.Driver = c.UPS.Driver
+		}
+		src.Thresholds = &thresholds
+		if src.SNMP == nil {
+			src.SNMP = c.UPS.SNMP
+		}
+		if src.Simulate == nil {
+			src.Simulate = c.UPS.Simulate
+		}
+		src.Host = simulatedHost(src.Driver, src.Host)
+		if src.Driver != "" && src.Driver != ups.DriverNUT {
+			// The NUT credentials of the other sources do not apply
+			sources[i] = src
+			continue
+		}
+		if src.Username == "" {
+			src.Username = c.UPS.Username
+		}
+		if src.Password == "" {
+			src.Password = c.UPS.Password
+		}
+		if src.Role == "" {
+			src.Role = c.UPS.Role
+		}
+		if src.TLS == nil {
+			src.TLS = c.UPS.TLS
+		}
+		sources[i] = src
+	}
+	return sources
+}
+
+// simulatedHost names the host of a simulated UPS, which has none
+func simulatedHost(driver, host string) string {
+	if host == "" && driver == ups.DriverSimulate {
+		return "simulate"
+	}
+	return host
+}
+
+// Simulated reports whether a UPS is simulated, in which case the final
+// action is replaced by a no-op so that outages can be rehearsed safely
+func (c *Config) Simulated() bool {
+	for _, src := range c.UPSSources() {
+		if src.Driver == ups.DriverSimulate {
+			return true
+		}
+	}
+	return false
+}
+
+// FindUPSSource returns the source matching a NUT UPS identifier, either
+// its full name@host label or just its name. With a single UPS configured
+// it is returned whatever the identifier.
+func (c *Config) FindUPSSource(upsname string) (UPSSource, bool) {
+	sources := c.UPSSources()
+	if len(sources) == 1 {
+		return sources[0], true
+	}
+
+	name, _, _ := strings.Cut(upsname, "@")
+	var match *UPSSource
+	for i, src := range sources {
+		if src.Label() == upsname {
+			return src, true
+		}
+		if src.Name == name {
+			if match != nil {
+				return UPSSource{}, false // Ambiguous
+			}
+			match = &sources[i]
+		}
+	}
+	if match == nil {
+		return UPSSource{}, false
+	}
+	return *match, true
+}
 
-	for i, phase := range c.Phases {
+func validatePhases(kind string, phases []Phase) error {
+	for i, phase := range phases {
 		if phase.Name == "" {
-			return fmt.Errorf("phase %d: name is required", i+1)
+			return fmt.Errorf("%s %d: name is required", kind, i+1)
 		}
 		if len(phase.Actions) == 0 {
-			return fmt.Errorf("phase %s: at least one action is required", phase.Name)
+			return fmt.Errorf("%s %s: at least one action is required", kind, phase.Name)
+		}
+		if phase.Condition != "" {
+			earlier := make([]string, 0, i)
+			for _, p := range phases[:i] {
+				earlier = append(earlier, p.Name)
+			}
+			if _, err := condition.Parse(phase.Condition, earlier); err != nil {
+				return fmt.Errorf("%s %s: invalid condition: %w", kind, phase.Name, err)
+			}
 		}
 
 		for j, action := range phase.Actions {
 			if err := validateAction(action); err != nil {
-				return fmt.Errorf("phase %s, action %d: %w", phase.Name, j+1, err)
+				return fmt.Errorf("%s %s, action %d: %w", kind, phase.Name, j+1, err)
+			}
+		}
+	}
+
+	if _, err := orchestrator.NewGraph(graphPhases(phases)); err != nil {
+		return fmt.Errorf("%s dependencies: %w", kind, err)
+	}
+
+	return nil
+}
+
+// graphPhases returns the phases with only what the dependency graph needs
+func graphPhases(phases []Phase) []orchestrator.Phase {
+	out := make([]orchestrator.Phase, len(phases))
+	for i, phase := range phases {
+		out[i] = orchestrator.Phase{Name: phase.Name, Parallel: phase.Parallel}
+		for _, action := range phase.Actions {
+			out[i].Actions = append(out[i].Actions, orchestrator.Action{
+				Type:      action.Type,
+				ID:        action.ID,
+				DependsOn: action.DependsOn,
+			})
+		}
+	}
+	return out
+}
+
+// validate checks that battery thresholds are percentages and ordered
+// emergency <= critical <= warning (unset thresholds are skipped)
+func (t UPSThresholds) validate() error {
+	levels := []struct {
+		name  string
+		value int
+	}{
+		{"emergency", t.Emergency},
+		{"critical", t.Critical},
+		{"warning", t.Warning},
+	}
+
+	for _, l := range levels {
+		if l.value < 0 || l.value > 100 {
+			return fmt.Errorf("ups.thresholds.%s must be between 0 and 100", l.name)
+		}
+	}
+
+	for i := 0; i < len(levels)-1; i++ {
+		for j := i + 1; j < len(levels); j++ {
+			lower, upper := levels[i], levels[j]
+			if lower.value > 0 && upper.value > 0 && lower.value > upper.value {
+				return fmt.Errorf("ups.thresholds.%s (%d) must not exceed %s (%d)",
+					lower.name, lower.value, upper.name, upper.value)
 			}
 		}
 	}
@@ -220,6 +966,12 @@ func validateAction(a Action) error {
 		if a.Action == "" {
 			return fmt.Errorf("proxmox-guest action requires action (shutdown/stop)")
 		}
+		if a.Action != "shutdown" && a.Action != "stop" {
+			return fmt.Errorf("invalid proxmox-guest action: %s (must be shutdown or stop)", a.Action)
+		}
+		if a.Recovery != "" && a.Recovery != "start" && a.Recovery != "none" {
+			return fmt.Errorf("proxmox-guest recovery must be start or none")
+		}
 	case "local":
 		if a.Command == "" {
 			return fmt.Errorf("local action requires command")
@@ -247,3 +999,116 @@ func validateAction(a Action) error {
 
 	return nil
 }
+
+// defaultActionTimeout applies to actions without an explicit timeout
+const defaultActionTimeout = 60 * time.Second
+
+// MinRuntime returns the battery runtime below which shutdown is triggered,
+// or zero when the runtime trigger is disabled
+func (c *Config) MinRuntime() (time.Duration, error) {
+	return c.minRuntime(c.UPS.Thresholds)
+}
+
+func (c *Config) minRuntime(t UPSThresholds) (time.Duration, error) {
+	switch t.MinRuntime {
+	case "":
+		return 0, nil
+	case "auto":
+		return c.EstimateShutdownDuration() + t.RuntimeMargin, nil
+	}
+
+	d, err := time.ParseDuration(t.MinRuntime)
+	if err != nil {
+		return 0, fmt.Errorf("ups.thresholds.min_runtime must be a duration or 'auto': %w", err)
+	}
+	return d, nil
+}
+
+// EstimateShutdownDuration returns the worst-case time the configured
+// phases need, including the delay before the final action
+func (c *Config) EstimateShutdownDuration() time.Duration {
+	total := c.FinalAction.delay()
+	if graph, err := orchestrator.NewGraph(graphPhases(c.Phases)); err == nil && graph.Explicit() {
+		return total + estimateGraphDuration(graph, c.Phases)
+	}
+	for _, phase := range c.Phases {
+		total += estimatePhaseDuration(phase)
+	}
+	return total
+}
+
+// estimateGraphDuration returns the longest chain of dependent actions.
+// Phase timeouts are left out, which can only overestimate.
+func estimateGraphDuration(graph *orchestrator.Graph, phases []Phase) time.Duration {
+	levels, _ := graph.Levels()
+	end := make(map[orchestrator.ActionRef]time.Duration)
+	var longest time.Duration
+	for _, level := range levels {
+		for _, ref := range level {
+			var start time.Duration
+			for _, dep := range graph.Deps(ref) {
+				if end[dep] > start {
+					start = end[dep]
+				}
+			}
+			end[ref] = start + estimateActionDuration(phases[ref.Phase].Actions[ref.Action])
+			if end[ref] > longest {
+				longest = end[ref]
+			}
+		}
+	}
+	return longest
+}
+
+// recovery returns what reverses the action: its recovery command or, for
+// proxmox-guest, "start" unless recovery is "none"
+func (a Action) recovery() string {
+	if a.Type != "proxmox-guest" {
+		return a.Recovery
+	}
+	if a.Recovery == "none" {
+		return ""
+	}
+	return "start"
+}
+
+func estimatePhaseDuration(phase Phase) time.Duration {
+	var total time.Duration
+	for _, action := range phase.Actions {
+		d := estimateActionDuration(action)
+		if phase.Parallel {
+			if d > total {
+				total = d
+			}
+		} else {
+			total += d
+		}
+	}
+
+	// The phase timeout caps everything running inside it
+	if phase.Timeout > 0 && phase.Timeout < total {
+		return phase.Timeout
+	}
+	return total
+}
+
+func estimateActionDuration(action Action) time.Duration {
+	timeout := action.Timeout
+	if timeout == 0 {
+		timeout = defaultActionTimeout
+	}
+
+	if action.Retry == nil || action.Retry.Attempts <= 1 {
+		return timeout
+	}
+
+	total := timeout * time.Duration(action.Retry.Attempts)
+	delay := action.Retry.Delay
+	for i := 1; i < action.Retry.Attempts; i++ {
+		total += delay
+		if action.Retry.Backoff == "exponential" {
+			delay *= 2
+		}
+	}
+	return total
+}
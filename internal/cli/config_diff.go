@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// configDiff describes what changed between two configurations, one entry
+// per changed setting. Secrets are never included.
+func configDiff(old, cfg *Config) []string {
+	var changes []string
+	add := func(name string, from, to interface{}) {
+		if !reflect.DeepEqual(from, to) {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, from, to))
+		}
+	}
+
+	add("ups.policy", old.UPS.Policy, cfg.UPS.Policy)
+	add("ups.quorum", old.UPS.Quorum, cfg.UPS.Quorum)
+	add("ups.comm_loss", old.UPS.CommLoss.shutdownAfter(), cfg.UPS.CommLoss.shutdownAfter())
+
+	oldSources := make(map[string]UPSSource)
+	for _, src := range old.UPSSources() {
+		oldSources[src.Label()] = src
+	}
+	seen := make(map[string]bool)
+	for _, src := range cfg.UPSSources() {
+		label := src.Label()
+		seen[label] = true
+		prev, ok := oldSources[label]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("ups %s added", label))
+			continue
+		}
+		o, n := prev.Thresholds, src.Thresholds
+		add("ups "+label+" warning", o.Warning, n.Warning)
+		add("ups "+label+" critical", o.Critical, n.Critical)
+		add("ups "+label+" emergency", o.Emergency, n.Emergency)
+		add("ups "+label+" min_runtime", o.MinRuntime, n.MinRuntime)
+		add("ups "+label+" runtime_margin", o.RuntimeMargin, n.RuntimeMargin)
+		add("ups "+label+" username", prev.Username, src.Username)
+		add("ups "+label+" role", prev.Role, src.Role)
+		if prev.Password != src.Password {
+			changes = append(changes, fmt.Sprintf("ups %s password changed", label))
+		}
+		if !reflect.DeepEqual(prev.TLS, src.TLS) {
+			changes = append(changes, fmt.Sprintf("ups %s tls changed", label))
+		}
+		add("ups "+label+" driver", driverName(prev), driverName(src))
+		if !reflect.DeepEqual(prev.SNMP, src.SNMP) {
+			changes = append(changes, fmt.Sprintf("ups %s snmp changed", label))
+		}
+		if !reflect.DeepEqual(prev.Simulate, src.Simulate) {
+			changes = append(changes, fmt.Sprintf("ups %s simulate changed", label))
+		}
+	}
+	for _, src := range old.UPSSources() {
+		if !seen[src.Label()] {
+			changes = append(changes, fmt.Sprintf("ups %s removed", src.Label()))
+		}
+	}
+	add("ups.thresholds.on_battery_max", old.UPS.Thresholds.OnBatteryMax, cfg.UPS.Thresholds.OnBatteryMax)
+	add("ups.thresholds.on_battery_warnings", old.UPS.Thresholds.OnBatteryWarnings, cfg.UPS.Thresholds.OnBatteryWarnings)
+
+	add("proxmox.api_url", old.Proxmox.APIURL, cfg.Proxmox.APIURL)
+	add("proxmox.token_id", old.Proxmox.TokenID, cfg.Proxmox.TokenID)
+	if old.Proxmox.TokenSecret != cfg.Proxmox.TokenSecret || old.Proxmox.SecretsFile != cfg.Proxmox.SecretsFile {
+		changes = append(changes, "proxmox token secret changed")
+	}
+	add("proxmox.insecure_tls", old.Proxmox.InsecureTLS, cfg.Proxmox.InsecureTLS)
+
+	changes = append(changes, phasesDiff("phases", old.Phases, cfg.Phases)...)
+	changes = append(changes, phasesDiff("emergency_phases", old.EmergencyPhases, cfg.EmergencyPhases)...)
+
+	add("recovery.enabled", old.Recovery.Enabled, cfg.Recovery.Enabled)
+	add("recovery.power_stable_delay", old.Recovery.PowerStableDelay, cfg.Recovery.PowerStableDelay)
+	add("recovery.on_error", old.Recovery.OnError, cfg.Recovery.OnError)
+
+	if !reflect.DeepEqual(old.FinalAction, cfg.FinalAction) {
+		changes = append(changes, fmt.Sprintf("final_action changed (%s after %s -> %s after %s)",
+			old.FinalAction.Type, old.FinalAction.delay(), cfg.FinalAction.Type, cfg.FinalAction.delay()))
+	}
+
+	if !reflect.DeepEqual(old.Notifications, cfg.Notifications) {
+		changes = append(changes, fmt.Sprintf("notifications changed (%d -> %d)", len(old.Notifications), len(cfg.Notifications)))
+	}
+
+	add("options.dry_run", old.Options.DryRun, cfg.Options.DryRun)
+	add("options.log_level", old.Options.LogLevel, cfg.Options.LogLevel)
+	add("options.log_format", old.Options.LogFormat, cfg.Options.LogFormat)
+	add("options.log_file", old.Options.LogFile, cfg.Options.LogFile)
+	add("options.state_file", old.Options.StateFile, cfg.Options.StateFile)
+	add("options.lock_file", old.Options.LockFile, cfg.Options.LockFile)
+	add("options.journal_file", old.Options.JournalFile, cfg.Options.JournalFile)
+	add("options.control_socket", old.Options.ControlSocket, cfg.Options.ControlSocket)
+	add("options.control_listen", old.Options.ControlListen, cfg.Options.ControlListen)
+	if old.Options.ControlToken != cfg.Options.ControlToken {
+		changes = append(changes, "control token changed")
+	}
+	add("options.metrics_listen", old.Options.MetricsListen, cfg.Options.MetricsListen)
+
+	return changes
+}
+
+// phasesDiff reports added, removed, modified and reordered phases by name
+func phasesDiff(kind string, old, phases []Phase) []string {
+	var changes []string
+
+	oldByName := make(map[string]Phase, len(old))
+	for _, p := range old {
+		oldByName[p.Name] = p
+	}
+	newByName := make(map[string]bool, len(phases))
+	for _, p := range phases {
+		newByName[p.Name] = true
+		prev, ok := oldByName[p.Name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s: %s added", kind, p.Name))
+		case !reflect.DeepEqual(prev, p):
+			changes = append(changes, fmt.Sprintf("%s: %s changed", kind, p.Name))
+		}
+	}
+	for _, p := range old {
+		if !newByName[p.Name] {
+			changes = append(changes, fmt.Sprintf("%s: %s removed", kind, p.Name))
+		}
+	}
+
+	if len(changes) == 0 && !reflect.DeepEqual(old, phases) {
+		changes = append(changes, fmt.Sprintf("%s: reordered", kind))
+	}
+
+	return changes
+}
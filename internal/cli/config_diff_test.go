@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigDiff(t *testing.T) {
+	old := &Config{
+		UPS: UPSConfig{
+			Host: "localhost:3493",
+			Name: "eaton",
+			Thresholds: UPSThresholds{
+				Warning:  30,
+				Critical: 20,
+			},
+		},
+		Proxmox: ProxmoxConfig{TokenSecret: "old"},
+		Phases: []Phase{
+			{Name: "stop-vms", Actions: []Action{{Type: "local", Command: "true"}}},
+			{Name: "stop-nas"},
+		},
+	}
+
+	cfg := *old
+	cfg.UPS.Thresholds.Critical = 25
+	cfg.UPS.Thresholds.OnBatteryMax = 10 * time.Minute
+	cfg.Proxmox.TokenSecret = "new"
+	cfg.Phases = []Phase{
+		{Name: "stop-vms", Actions: []Action{{Type: "local", Command: "false"}}},
+		{Name: "stop-docker"},
+	}
+
+	expected := []string{
+		"ups eaton@localhost:3493 critical: 20 -> 25",
+		"ups.thresholds.on_battery_max: 0s -> 10m0s",
+		"proxmox token secret changed",
+		"phases: stop-vms changed",
+		"phases: stop-docker added",
+		"phases: stop-nas removed",
+	}
+	if got := configDiff(old, &cfg); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected changes %q, got %q", expected, got)
+	}
+
+	if got := configDiff(old, old); len(got) != 0 {
+		t.Errorf("Expected no changes, got %q", got)
+	}
+
+	reordered := *old
+	reordered.Phases = []Phase{old.Phases[1], old.Phases[0]}
+	if got := configDiff(old, &reordered); !reflect.DeepEqual(got, []string{"phases: reordered"}) {
+		t.Errorf("Expected reordered phases, got %q", got)
+	}
+}
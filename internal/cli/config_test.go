@@ -2,9 +2,15 @@ package cli
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -248,3 +254,777 @@ func TestValidateAction(t *testing.T) {
 		})
 	}
 }
+
+func TestEstimateShutdownDuration(t *testing.T) {
+	cfg := Config{
+		Phases: []Phase{
+			{
+				Name:     "parallel",
+				Parallel: true,
+				Actions: []Action{
+					{Type: "local", Command: "a", Timeout: 30 * time.Second},
+					{Type: "local", Command: "b", Timeout: 90 * time.Second},
+				},
+			},
+			{
+				Name: "sequential",
+				Actions: []Action{
+					{Type: "local", Command: "a", Timeout: 20 * time.Second},
+					{Type: "local", Command: "b"}, // default timeout
+				},
+			},
+			{
+				Name: "retry",
+				Actions: []Action{
+					{
+						Type:    "local",
+						Command: "a",
+						Timeout: 10 * time.Second,
+						Retry:   &RetryConfig{Attempts: 3, Delay: 5 * time.Second, Backoff: "exponential"},
+					},
+				},
+			},
+			{
+				Name:    "capped",
+				Timeout: 15 * time.Second,
+				Actions: []Action{
+					{Type: "local", Command: "a", Timeout: time.Minute},
+				},
+			},
+		},
+	}
+
+	// 90s + (20s + 60s) + (3*10s + 5s + 10s) + 15s + host delay
+	expected := 90*time.Second + 80*time.Second + 45*time.Second + 15*time.Second + hostShutdownDelay
+	if got := cfg.EstimateShutdownDuration(); got != expected {
+		t.Errorf("Expected estimate %v, got %v", expected, got)
+	}
+}
+
+func TestMinRuntime(t *testing.T) {
+	base := Config{
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo", Timeout: 50 * time.Second}}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		value     string
+		margin    time.Duration
+		expected  time.Duration
+		expectErr bool
+	}{
+		{name: "disabled", value: "", expected: 0},
+		{name: "explicit duration", value: "5m", expected: 5 * time.Minute},
+		{name: "auto", value: "auto", margin: 30 * time.Second, expected: 80*time.Second + hostShutdownDelay},
+		{name: "invalid", value: "soon", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.UPS.Thresholds.MinRuntime = tt.value
+			cfg.UPS.Thresholds.RuntimeMargin = tt.margin
+
+			got, err := cfg.MinRuntime()
+			if tt.expectErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOnBatteryWarningsValidation(t *testing.T) {
+	base := Config{
+		UPS: UPSConfig{
+			Host: "localhost:3493",
+			Name: "test-ups",
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		max       time.Duration
+		warnings  []time.Duration
+		expectErr bool
+	}{
+		{name: "warnings below max", max: 5 * time.Minute, warnings: []time.Duration{time.Minute, 3 * time.Minute}},
+		{name: "warnings without max", warnings: []time.Duration{time.Minute}},
+		{name: "warning at max", max: 5 * time.Minute, warnings: []time.Duration{5 * time.Minute}, expectErr: true},
+		{name: "non-positive warning", warnings: []time.Duration{0}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.UPS.Thresholds.OnBatteryMax = tt.max
+			cfg.UPS.Thresholds.OnBatteryWarnings = tt.warnings
+
+			err := cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestThresholdsValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds UPSThresholds
+		expectErr  bool
+	}{
+		{name: "ordered", thresholds: UPSThresholds{Warning: 30, Critical: 20, Emergency: 10}},
+		{name: "unset", thresholds: UPSThresholds{}},
+		{name: "only critical", thresholds: UPSThresholds{Critical: 20}},
+		{name: "emergency above critical", thresholds: UPSThresholds{Critical: 20, Emergency: 25}, expectErr: true},
+		{name: "critical above warning", thresholds: UPSThresholds{Warning: 20, Critical: 30}, expectErr: true},
+		{name: "emergency above warning", thresholds: UPSThresholds{Warning: 20, Emergency: 30}, expectErr: true},
+		{name: "out of range", thresholds: UPSThresholds{Warning: 120}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.thresholds.validate()
+			if tt.expectErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestEmergencyPhasesValidation(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			Host: "localhost:3493",
+			Name: "test-ups",
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+		EmergencyPhases: []Phase{
+			{Name: "emergency", Actions: []Action{{Type: "local"}}},
+		},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for invalid emergency action, got nil")
+	}
+
+	cfg.EmergencyPhases[0].Actions[0].Command = "sync"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
+
+func TestPhaseConditionValidation(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			Host: "localhost:3493",
+			Name: "test-ups",
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "stop-apps", Actions: []Action{{Type: "local", Command: "echo"}}},
+			{Name: "stop-host", Condition: `battery.charge < 15 || trigger == "manual"`,
+				Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	for _, cond := range []string{
+		`battery.charge < "15"`,
+		`phase("stop-host") == "failed"`,
+		`battery.charge <`,
+	} {
+		cfg.Phases[1].Condition = cond
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), "phase stop-host: invalid condition") {
+			t.Errorf("Expected invalid condition error for %q, got: %v", cond, err)
+		}
+	}
+
+	cfg.Phases[1].Condition = `phase("stop-apps") == "failed"`
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected reference to an earlier phase to be valid, got: %v", err)
+	}
+}
+
+func TestEstimateShutdownDurationWithDependencies(t *testing.T) {
+	cfg := Config{
+		Phases: []Phase{
+			{Name: "one", Actions: []Action{
+				{Type: "local", Command: "a", ID: "a", Timeout: 10 * time.Second},
+				{Type: "local", Command: "b", Timeout: 2 * time.Minute},
+			}},
+			{Name: "two", Actions: []Action{
+				{Type: "local", Command: "c", DependsOn: []string{"a"}, Timeout: 30 * time.Second},
+			}},
+		},
+	}
+
+	// c runs alongside b, so the longest chain is a then b
+	expected := 10*time.Second + 2*time.Minute + hostShutdownDelay
+	if got := cfg.EstimateShutdownDuration(); got != expected {
+		t.Errorf("Expected estimate %v, got %v", expected, got)
+	}
+}
+
+func TestActionDependencyValidation(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			Host: "localhost:3493",
+			Name: "test-ups",
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "stop-apps", Actions: []Action{{Type: "local", Command: "echo", ID: "apps"}}},
+			{Name: "stop-db", Actions: []Action{{Type: "local", Command: "echo", ID: "db", DependsOn: []string{"apps"}}}},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	cfg.Phases[1].Actions[0].DependsOn = []string{"web"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `unknown action "web"`) {
+		t.Errorf("Expected unknown action error, got: %v", err)
+	}
+
+	cfg.Phases[1].Actions[0].DependsOn = []string{"apps"}
+	cfg.Phases[0].Actions[0].DependsOn = []string{"db"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "dependency cycle: apps -> db -> apps") {
+		t.Errorf("Expected dependency cycle error, got: %v", err)
+	}
+}
+
+func TestUPSSources(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			Thresholds: UPSThresholds{Warning: 30, Critical: 20, Emergency: 10},
+			Policy:     "quorum",
+			Quorum:     2,
+			Sources: []UPSSource{
+				{Host: "10.0.0.5", Name: "ups-a"},
+				{Host: "10.0.0.6", Name: "ups-b", Thresholds: &UPSThresholds{Critical: 25}},
+			},
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	sources := cfg.UPSSources()
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(sources))
+	}
+	if sources[0].Thresholds.Critical != 20 {
+		t.Errorf("Expected inherited critical threshold 20, got %d", sources[0].Thresholds.Critical)
+	}
+	if sources[1].Thresholds.Critical != 25 || sources[1].Thresholds.Warning != 30 {
+		t.Errorf("Expected overridden critical 25 and inherited warning 30, got %+v", *sources[1].Thresholds)
+	}
+
+	// Per-UPS thresholds are validated after merging
+	cfg.UPS.Sources[1].Thresholds.Warning = 22
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for critical above warning, got nil")
+	}
+	cfg.UPS.Sources[1].Thresholds.Warning = 0
+
+	cfg.UPS.Quorum = 3
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for quorum above source count, got nil")
+	}
+
+	cfg.UPS.Policy = "majority"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for invalid policy, got nil")
+	}
+}
+
+func TestUPSDrivers(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			NUTAuth: NUTAuth{Username: "monuser", Password: "secret"},
+			Sources: []UPSSource{
+				{Host: "10.0.0.5", Name: "ups-a"},
+				{Driver: "apcupsd", Host: "10.0.0.6", Name: "ups-b"},
+			},
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	sources := cfg.UPSSources()
+	if sources[0].Username != "monuser" {
+		t.Errorf("Expected NUT source to inherit the username, got %q", sources[0].Username)
+	}
+	if sources[1].NUTAuth != (NUTAuth{}) {
+		t.Errorf("Expected apcupsd source without NUT credentials, got %+v", sources[1].NUTAuth)
+	}
+
+	cfg.UPS.Sources[1].Role = "secondary"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a role with apcupsd, got nil")
+	}
+	cfg.UPS.Sources[1].Role = ""
+
+	cfg.UPS.Sources[1].Driver = "snmp-ups"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for an unknown driver, got nil")
+	}
+}
+
+func TestSNMPDriver(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			NUTAuth: NUTAuth{Username: "monuser", Password: "secret"},
+			SNMP:    &SNMPConfig{Community: "guardian"},
+			Sources: []UPSSource{
+				{Driver: "snmp", Host: "10.0.0.7", Name: "ups-a"},
+				{Driver: "snmp", Host: "10.0.0.8", Name: "ups-b", SNMP: &SNMPConfig{
+					Version: "3", User: "monitor", AuthProtocol: "sha", AuthPassword: "authpass1",
+					PrivProtocol: "aes", PrivPassword: "privpass1",
+				}},
+			},
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	sources := cfg.UPSSources()
+	if sources[0].NUTAuth != (NUTAuth{}) {
+		t.Errorf("Expected SNMP source without NUT credentials, got %+v", sources[0].NUTAuth)
+	}
+	if c := snmpClientConfig(sources[0]); c.Address != "10.0.0.7:161" || c.Community != "guardian" {
+		t.Errorf("Expected the global community on port 161, got %+v", c)
+	}
+	if c := snmpClientConfig(sources[1]); c.Version != "3" || c.AuthProtocol != "SHA" || c.PrivProtocol != "AES" {
+		t.Errorf("Expected the source SNMPv3 settings, got %+v", c)
+	}
+
+	cfg.UPS.Sources[1].SNMP.AuthPassword = "short"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a short SNMPv3 password, got nil")
+	}
+	cfg.UPS.Sources[1].SNMP.AuthPassword = "authpass1"
+
+	cfg.UPS.Sources[0].Role = "primary"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for a role with snmp, got nil")
+	}
+}
+
+func TestSimulateDriver(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			Driver:   "simulate",
+			Name:     "rehearsal",
+			Simulate: &SimulateConfig{Scenario: "../../configs/scenarios/outage.yaml", Speed: 60},
+		},
+		Proxmox: ProxmoxConfig{
+			APIURL:  "https://127.0.0.1:8006/api2/json",
+			TokenID: "test@pve!test",
+		},
+		Phases: []Phase{
+			{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !cfg.Simulated() {
+		t.Error("Expected the configuration to be simulated")
+	}
+	src := cfg.UPSSources()[0]
+	if src.Label() != "rehearsal@simulate" {
+		t.Errorf("Unexpected simulated label %q", src.Label())
+	}
+	driver, err := newUPSDriver(src)
+	if err != nil {
+		t.Fatalf("Expected the example scenario to load, got: %v", err)
+	}
+	if _, ok := driver.(*ups.SimulatedClient); !ok {
+		t.Errorf("Expected a simulated driver, got %T", driver)
+	}
+
+	cfg.UPS.Simulate = nil
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error without a scenario, got nil")
+	}
+
+	cfg.UPS.Driver = ""
+	cfg.UPS.Host = "localhost"
+	if cfg.Simulated() {
+		t.Error("Expected a NUT configuration not to be simulated")
+	}
+}
+
+func TestUPSAddress(t *testing.T) {
+	tests := map[string]string{
+		"localhost":       "localhost:3493",
+		"localhost:3493":  "localhost:3493",
+		"10.0.0.5:13493":  "10.0.0.5:13493",
+		"fe80::1":         "[fe80::1]:3493",
+		"[fe80::1]:13493": "[fe80::1]:13493",
+	}
+
+	for host, expected := range tests {
+		if got := upsAddress(UPSSource{Host: host}); got != expected {
+			t.Errorf("upsAddress(%q): expected %q, got %q", host, expected, got)
+		}
+	}
+
+	if got := upsAddress(UPSSource{Driver: "apcupsd", Host: "nas"}); got != "nas:3551" {
+		t.Errorf("Expected the apcupsd NIS port, got %q", got)
+	}
+}
+
+func TestFindUPSSource(t *testing.T) {
+	cfg := Config{
+		UPS: UPSConfig{
+			Sources: []UPSSource{
+				{Host: "10.0.0.5", Name: "ups-a"},
+				{Host: "10.0.0.6", Name: "ups-b"},
+				{Host: "10.0.0.7", Name: "ups-b"},
+			},
+		},
+	}
+
+	tests := []struct {
+		upsname  string
+		expected string
+		ok       bool
+	}{
+		{"ups-a@10.0.0.5", "ups-a@10.0.0.5", true},
+		{"ups-a@localhost", "ups-a@10.0.0.5", true},
+		{"ups-b@10.0.0.7", "ups-b@10.0.0.7", true},
+		{"ups-b@localhost", "", false}, // Ambiguous
+		{"ups-c@localhost", "", false},
+	}
+
+	for _, tt := range tests {
+		src, ok := cfg.FindUPSSource(tt.upsname)
+		if ok != tt.ok || (ok && src.Label() != tt.expected) {
+			t.Errorf("FindUPSSource(%q): expected %q/%v, got %q/%v", tt.upsname, tt.expected, tt.ok, src.Label(), ok)
+		}
+	}
+
+	// A single UPS matches whatever upsmon calls it
+	single := Config{UPS: UPSConfig{Host: "localhost", Name: "eaton"}}
+	if src, ok := single.FindUPSSource(""); !ok || src.Name != "eaton" {
+		t.Errorf("Expected single UPS to match, got %q/%v", src.Label(), ok)
+	}
+}
+
+func TestFinalActionConfig(t *testing.T) {
+	var final FinalActionConfig
+	data := `
+type: reboot-on-return
+delay: 0s
+nut:
+  set_vars:
+    ups.delay.shutdown: "120"
+`
+	if err := yaml.Unmarshal([]byte(data), &final); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := final.validate(); err != nil {
+		t.Fatalf("Expected valid final action, got: %v", err)
+	}
+	if final.delay() != 0 {
+		t.Errorf("Expected explicit zero delay, got %s", final.delay())
+	}
+	if final.instCmd() != "shutdown.return" {
+		t.Errorf("Expected shutdown.return by default, got %q", final.instCmd())
+	}
+	if (FinalActionConfig{}).delay() != hostShutdownDelay {
+		t.Errorf("Expected default delay %s", hostShutdownDelay)
+	}
+
+	invalid := []FinalActionConfig{
+		{Type: "sleep"},
+		{Type: FinalCommand},
+		{Type: FinalPoweroff, Command: "true"},
+	}
+	for _, f := range invalid {
+		if err := f.validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", f)
+		}
+	}
+}
+
+func TestFinalActionPowerOff(t *testing.T) {
+	hostTime := 2 * time.Minute
+	tests := []struct {
+		name  string
+		final FinalActionConfig
+		want  string // Expected error, "" when valid
+	}{
+		{"no delay", FinalActionConfig{Type: FinalRebootOnReturn}, "must set ups.delay.shutdown"},
+		{"short delay", FinalActionConfig{Type: FinalRebootOnReturn, NUT: &FinalNUTConfig{
+			SetVars: map[string]string{"ups.delay.shutdown": "20"},
+		}}, "leaves the host no time"},
+		{"delay above host time", FinalActionConfig{Type: FinalPoweroff, NUT: &FinalNUTConfig{
+			InstCmd: "shutdown.stayoff",
+			SetVars: map[string]string{"ups.delay.shutdown": "180"}, HostShutdownTime: &hostTime,
+		}}, ""},
+		{"delay below host time", FinalActionConfig{Type: FinalPoweroff, NUT: &FinalNUTConfig{
+			InstCmd: "shutdown.stayoff",
+			SetVars: map[string]string{"ups.delay.shutdown": "120"}, HostShutdownTime: &hostTime,
+		}}, "use at least 2m30s"},
+		{"immediate", FinalActionConfig{Type: FinalPoweroff, NUT: &FinalNUTConfig{
+			InstCmd: "load.off",
+			SetVars: map[string]string{"ups.delay.shutdown": "600"},
+		}}, "cuts power before the host is down"},
+		{"powerdown flag", FinalActionConfig{Type: FinalRebootOnReturn, NUT: &FinalNUTConfig{
+			PowerdownFlag: "/etc/killpower",
+		}}, ""},
+		{"flag and instcmd", FinalActionConfig{Type: FinalPoweroff, NUT: &FinalNUTConfig{
+			PowerdownFlag: "/etc/killpower", InstCmd: "shutdown.return",
+			SetVars: map[string]string{"ups.delay.shutdown": "120"},
+		}}, "either instcmd or powerdown_flag"},
+		{"other command", FinalActionConfig{Type: FinalPoweroff, NUT: &FinalNUTConfig{
+			InstCmd: "beeper.disable",
+		}}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.final.validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: expected valid, got %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: expected error mentioning %q, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	flag := filepath.Join(t.TempDir(), "killpower")
+	if err := writePowerdownFlag(flag); err != nil {
+		t.Fatalf("writePowerdownFlag failed: %v", err)
+	}
+	if data, _ := os.ReadFile(flag); string(data) != "upsmon-shutdown-file\n" {
+		t.Errorf("Expected the upsmon magic in the flag, got %q", data)
+	}
+}
+
+func TestFinalCommandMessage(t *testing.T) {
+	msg := shutdownMessage(daemon.TriggerManual, "it's\nmaintenance")
+	if msg != "Shutdown requested - it's maintenance" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+	if msg := shutdownMessage(daemon.TriggerOnBatteryTimer, ""); msg != "UPS on battery for too long" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+
+	command := finalCommand(FinalActionConfig{Type: FinalPoweroff}, "it's '; reboot; '")
+	if !strings.HasPrefix(command, "shutdown -h now ") {
+		t.Fatalf("Unexpected command %q", command)
+	}
+
+	// The quoted message reaches shutdown(8) as a single argument
+	arg := strings.TrimPrefix(command, "shutdown -h now ")
+	out, err := exec.Command("sh", "-c", "printf '%s|' "+arg).Output()
+	if err != nil {
+		t.Fatalf("sh failed: %v", err)
+	}
+	if string(out) != "it's '; reboot; '|" {
+		t.Errorf("Expected the message as one argument, got %q", out)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	secretsPath := filepath.Join(tmpDir, "secrets.yaml")
+	secrets := `
+proxmox:
+  token_secret: px-secret
+nut:
+  password: default-pass
+  passwords:
+    apc@nas: apc-pass
+`
+	if err := os.WriteFile(secretsPath, []byte(secrets), 0600); err != nil {
+		t.Fatalf("Failed to write secrets: %v", err)
+	}
+
+	cfg := &Config{
+		UPS: UPSConfig{
+			NUTAuth: NUTAuth{Username: "monuser", Role: "secondary"},
+			Sources: []UPSSource{
+				{Host: "localhost", Name: "eaton"},
+				{Host: "nas", Name: "apc"},
+				{Host: "backup", Name: "cyber", NUTAuth: NUTAuth{Password: "inline"}},
+			},
+		},
+	}
+	if err := cfg.loadSecrets(secretsPath); err != nil {
+		t.Fatalf("loadSecrets failed: %v", err)
+	}
+
+	if cfg.Proxmox.TokenSecret != "px-secret" {
+		t.Errorf("Expected token secret from secrets file, got %q", cfg.Proxmox.TokenSecret)
+	}
+	expected := []string{"default-pass", "apc-pass", "inline"}
+	for i, src := range cfg.UPSSources() {
+		if src.Password != expected[i] {
+			t.Errorf("Expected %s password %q, got %q", src.Label(), expected[i], src.Password)
+		}
+		if src.Username != "monuser" || src.Role != "secondary" {
+			t.Errorf("Expected %s to inherit username and role, got %q %q", src.Label(), src.Username, src.Role)
+		}
+	}
+
+	if err := os.Chmod(secretsPath, 0644); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := (&Config{}).loadSecrets(secretsPath); err == nil {
+		t.Error("Expected error for a world-readable secrets file")
+	}
+
+	// With the token secret in the configuration, the file is not required
+	cfg = &Config{Proxmox: ProxmoxConfig{TokenSecret: "inline"}}
+	if err := cfg.loadSecrets(secretsPath); err != nil {
+		t.Errorf("Expected an exposed secrets file to be skipped, got %v", err)
+	}
+	if cfg.UPS.Password != "" || len(cfg.Warnings()) != 1 {
+		t.Errorf("Expected the file to be skipped with a warning, got %q %v", cfg.UPS.Password, cfg.Warnings())
+	}
+
+	cfg = &Config{Proxmox: ProxmoxConfig{TokenSecret: "inline"}}
+	if err := cfg.loadSecrets(filepath.Join(tmpDir, "missing.yaml")); err != nil || len(cfg.Warnings()) != 1 {
+		t.Errorf("Expected a missing secrets file to be skipped with a warning, got %v %v", err, cfg.Warnings())
+	}
+	if err := (&Config{}).loadSecrets(filepath.Join(tmpDir, "missing.yaml")); err == nil {
+		t.Error("Expected error for a missing secrets file holding the token secret")
+	}
+}
+
+func TestLoadSecretsSchema(t *testing.T) {
+	secretsPath := filepath.Join(t.TempDir(), "secrets.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(secretsPath, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write secrets: %v", err)
+		}
+	}
+
+	// The former top-level key still works, with a warning
+	write("proxmox_token_secret: legacy-secret\n")
+	cfg := &Config{}
+	if err := cfg.loadSecrets(secretsPath); err != nil {
+		t.Fatalf("loadSecrets failed: %v", err)
+	}
+	if cfg.Proxmox.TokenSecret != "legacy-secret" {
+		t.Errorf("Expected token secret from the legacy key, got %q", cfg.Proxmox.TokenSecret)
+	}
+	if len(cfg.Warnings()) != 1 || !strings.Contains(cfg.Warnings()[0], "proxmox.token_secret") {
+		t.Errorf("Expected a deprecation warning, got %v", cfg.Warnings())
+	}
+
+	write("proxmox:\n  token-secret: typo\n")
+	if err := (&Config{}).loadSecrets(secretsPath); err == nil || !strings.Contains(err.Error(), "token-secret") {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+
+	// The shipped example follows the schema
+	data, err := os.ReadFile("../../configs/secrets.yaml.example")
+	if err != nil {
+		t.Fatalf("Failed to read example: %v", err)
+	}
+	write(string(data))
+	cfg = &Config{}
+	if err := cfg.loadSecrets(secretsPath); err != nil {
+		t.Fatalf("loadSecrets failed on the example: %v", err)
+	}
+	if cfg.Proxmox.TokenSecret == "" || len(cfg.Warnings()) != 0 {
+		t.Errorf("Expected the example token secret without warning, got %q %v", cfg.Proxmox.TokenSecret, cfg.Warnings())
+	}
+}
+
+func TestControlToken(t *testing.T) {
+	cfg := Config{
+		UPS:     UPSConfig{Host: "localhost:3493", Name: "test-ups"},
+		Proxmox: ProxmoxConfig{APIURL: "https://127.0.0.1:8006/api2/json", TokenID: "test@pve!test"},
+		Phases:  []Phase{{Name: "test", Actions: []Action{{Type: "local", Command: "echo"}}}},
+		Options: OptionsConfig{ControlListen: "127.0.0.1:9120"},
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "control_token") {
+		t.Errorf("Expected control_token error, got %v", err)
+	}
+
+	secretsPath := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(secretsPath, []byte("control:\n  token: from-secrets\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secrets: %v", err)
+	}
+	if err := cfg.loadSecrets(secretsPath); err != nil {
+		t.Fatalf("loadSecrets failed: %v", err)
+	}
+	if cfg.Options.ControlToken != "from-secrets" {
+		t.Errorf("Expected token from the secrets file, got %q", cfg.Options.ControlToken)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+}
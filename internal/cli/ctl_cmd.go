@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/control"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctlEmergency bool
+	ctlReason    string
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Query and control the running daemon",
+	Long: `Talks to the running daemon over its control socket
+(options.control_socket).`,
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show UPS status, power state and last shutdown session",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, ctx, err := ctlClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		status, err := client.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("⚡ Power state: %s\n", status.State)
+		if status.OnBatterySince != nil {
+			fmt.Printf("   On battery for %s\n", time.Since(*status.OnBatterySince).Round(time.Second))
+		}
+		if status.Plan != "" {
+			fmt.Printf("   Running plan: %s\n", status.Plan)
+		}
+
+		fmt.Println("\n🔋 UPS:")
+		for _, u := range status.UPS {
+			if u.Comm != "" {
+				fmt.Printf("   ⚠️  %s: %s, not answering\n", u.Name, u.Comm)
+			}
+			if u.Updated.IsZero() {
+				fmt.Printf("   %s: no status received\n", u.Name)
+				continue
+			}
+			fmt.Printf("   %s: Battery %d%% | Runtime %ds | Load %d%% | Status: %s\n",
+				u.Name, u.Battery, u.Runtime, u.Load, u.Status)
+			if u.Estimate != "" {
+				fmt.Printf("      📉 %s\n", u.Estimate)
+			}
+		}
+
+		fmt.Println("\n📋 Last session:")
+		if status.Session == nil || status.Session.SessionID == "" {
+			fmt.Println("   none")
+			return nil
+		}
+		s := status.Session
+		fmt.Printf("   ID: %s\n", s.SessionID)
+		fmt.Printf("   Started: %s\n", s.StartedAt.Format(time.RFC3339))
+		fmt.Printf("   Trigger: %s\n", s.TriggerEvent)
+		if s.DryRun {
+			fmt.Printf("   Status: %s (dry run)\n", s.Status)
+		} else {
+			fmt.Printf("   Status: %s\n", s.Status)
+		}
+		if s.Reason != "" {
+			fmt.Printf("   Reason: %s\n", s.Reason)
+		}
+		skipped := 0
+		for _, a := range s.CompletedActions {
+			if a.Skipped {
+				skipped++
+			}
+		}
+		if skipped > 0 {
+			fmt.Printf("   Completed actions: %d (%d skipped)\n", len(s.CompletedActions)-skipped, skipped)
+		} else {
+			fmt.Printf("   Completed actions: %d\n", len(s.CompletedActions))
+		}
+
+		return nil
+	},
+}
+
+var ctlShutdownCmd = &cobra.Command{
+	Use:   "shutdown",
+	Short: "Start the shutdown plan now",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, ctx, err := ctlClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		req := control.ShutdownRequest{Plan: "graceful", Reason: ctlReason}
+		if ctlEmergency {
+			req.Plan = "emergency"
+		}
+		if err := client.Shutdown(ctx, req); err != nil {
+			return err
+		}
+
+		fmt.Printf("🚨 %s shutdown started\n", req.Plan)
+		return nil
+	},
+}
+
+var ctlAbortCmd = &cobra.Command{
+	Use:   "abort",
+	Short: "Abort the running shutdown sequence and recover",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, ctx, err := ctlClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := client.Abort(ctx); err != nil {
+			return err
+		}
+
+		fmt.Println("↩️ Abort requested, completed actions will be recovered")
+		return nil
+	},
+}
+
+var ctlRecoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Recover the actions of the last shutdown session",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, ctx, err := ctlClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := client.Recover(ctx); err != nil {
+			return err
+		}
+
+		fmt.Println("🔄 Recovery started, see the daemon logs for progress")
+		return nil
+	},
+}
+
+var ctlReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the daemon configuration",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, ctx, err := ctlClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := client.Reload(ctx); err != nil {
+			return err
+		}
+
+		fmt.Println("✅ Configuration reloaded")
+		return nil
+	},
+}
+
+// ctlClient creates a control client for the configured socket
+func ctlClient(cmd *cobra.Command) (*control.Client, context.Context, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return control.NewClient(cfg.Options.ControlSocket), ctx, nil
+}
+
+func init() {
+	ctlShutdownCmd.Flags().BoolVar(&ctlEmergency, "emergency", false, "Run the emergency plan")
+	ctlShutdownCmd.Flags().StringVar(&ctlReason, "reason", "", "Reason recorded in the session")
+
+	ctlCmd.AddCommand(ctlStatusCmd)
+	ctlCmd.AddCommand(ctlShutdownCmd)
+	ctlCmd.AddCommand(ctlAbortCmd)
+	ctlCmd.AddCommand(ctlRecoverCmd)
+	ctlCmd.AddCommand(ctlReloadCmd)
+
+	rootCmd.AddCommand(ctlCmd)
+}
@@ -0,0 +1,319 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/control"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/journal"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/proxmox"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+// configStore holds the configuration used by the daemon so that it can be
+// swapped on reload
+type configStore struct {
+	mu  sync.RWMutex
+	cfg *Config
+}
+
+// Get returns the current configuration
+func (s *configStore) Get() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Swap replaces the current configuration and returns the one it replaced
+func (s *configStore) Swap(cfg *Config) *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.cfg
+	s.cfg = cfg
+	return old
+}
+
+// Notify sends an event to the notifications currently configured
+func (s *configStore) Notify(event string, data map[string]interface{}) error {
+	return newNotifier(s.Get()).Notify(event, data)
+}
+
+// Record appends an entry to the journal currently configured
+func (s *configStore) Record(e journal.Entry) error {
+	return journal.New(s.Get().Options.JournalFile).Record(e)
+}
+
+// daemonControl executes control requests against the running daemon
+type daemonControl struct {
+	ctx      context.Context
+	config   *configStore
+	daemon   *daemon.Daemon
+	group    *ups.Group
+	pxClient *proxmox.Client
+	logger   daemon.Logger
+	metrics  *guardianMetrics
+	recovery *recoveryGuard
+}
+
+// recoveryGuard keeps a manual recovery and a shutdown from running at the
+// same time: both write the state file and act on the same guests
+type recoveryGuard struct {
+	mu      sync.Mutex
+	held    int
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running bool
+}
+
+// start runs a recovery in the background, unless one is already running
+// or a shutdown holds the guard
+func (g *recoveryGuard) start(ctx context.Context, run func(ctx context.Context)) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.held > 0 {
+		return errors.New("shutdown in progress, abort it instead")
+	}
+	if g.running {
+		return errors.New("recovery already running")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	g.cancel, g.done, g.running = cancel, done, true
+
+	go func() {
+		defer close(done)
+		defer cancel()
+		run(ctx)
+
+		g.mu.Lock()
+		g.running = false
+		g.mu.Unlock()
+	}()
+	return nil
+}
+
+// hold cancels the running recovery, waits for it to stop and refuses new
+// ones until release is called
+func (g *recoveryGuard) hold() (release func()) {
+	g.mu.Lock()
+	g.held++
+	var done chan struct{}
+	if g.running {
+		g.cancel()
+		done = g.done
+	}
+	g.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+	return func() {
+		g.mu.Lock()
+		g.held--
+		g.mu.Unlock()
+	}
+}
+
+// Notify applies a NUT notification forwarded by `notify`. Power events are
+// fed to the UPS group as if its monitor had seen them; the others are
+// only notified.
+func (c *daemonControl) Notify(n control.Notification) error {
+	t, ok := ups.ParseNotifyType(n.Type)
+	if !ok {
+		return fmt.Errorf("unknown NUT event: %s", n.Type)
+	}
+
+	src, ok := c.config.Get().FindUPSSource(n.UPS)
+	if !ok {
+		return fmt.Errorf("unknown UPS: %s", n.UPS)
+	}
+
+	c.logger.Info("NUT notification", "event", t, "ups", src.Label(), "message", n.Message)
+
+	if ev, ok := t.Event(); ok {
+		if n.Message != "" {
+			ev.Message = n.Message
+		}
+		c.group.HandleEvent(src.Label(), ev)
+		return nil
+	}
+
+	data := notifyData(n)
+	data["ups"] = src.Label()
+	return c.config.Notify(notifyEventNames[t], data)
+}
+
+// Status reports the power state, the last status of every UPS and the
+// current or last shutdown session
+func (c *daemonControl) Status() (*control.Status, error) {
+	cfg := c.config.Get()
+
+	status := &control.Status{
+		State: string(c.daemon.State()),
+		Plan:  string(c.daemon.Plan()),
+	}
+	if since := c.daemon.OnBatterySince(); !since.IsZero() {
+		status.OnBatterySince = &since
+	}
+
+	statuses := c.daemon.Statuses()
+	comm := c.group.CommStates()
+	for _, src := range cfg.UPSSources() {
+		u := control.UPSStatus{Name: src.Label()}
+		if state, ok := comm[src.Label()]; ok && state != ups.CommOK {
+			u.Comm = string(state)
+		}
+		if s, ok := statuses[src.Label()]; ok {
+			u.Status = s.Status
+			u.Battery = s.BatteryCharge
+			u.Runtime = s.Runtime
+			u.Load = s.Load
+			u.Updated = s.Timestamp
+			if s.Estimate != nil {
+				u.Estimate = s.Estimate.String()
+			}
+		}
+		status.UPS = append(status.UPS, u)
+	}
+
+	session, err := orchestrator.ReadState(cfg.Options.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	status.Session = session
+
+	return status, nil
+}
+
+// Shutdown starts a shutdown plan on request
+func (c *daemonControl) Shutdown(req control.ShutdownRequest) error {
+	plan := daemon.Plan(req.Plan)
+	if plan == "" {
+		plan = daemon.PlanGraceful
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "manual shutdown"
+	}
+
+	return c.daemon.Shutdown(plan, reason)
+}
+
+// Abort stops the running shutdown sequence
+func (c *daemonControl) Abort() error {
+	return c.daemon.Abort()
+}
+
+// Recover reverses the actions completed by the last shutdown session. It
+// runs in the background; progress is reported by notifications. A
+// shutdown starting meanwhile interrupts it.
+func (c *daemonControl) Recover() error {
+	if c.daemon.State() == daemon.StateShuttingDown {
+		return errors.New("shutdown in progress, abort it instead")
+	}
+
+	cfg := c.config.Get()
+	orch, session, err := recoveryOrchestrator(cfg, c.pxClient, c.logger, c.config)
+	if err != nil {
+		return err
+	}
+	if c.metrics != nil {
+		orch.SetObserver(c.metrics)
+	}
+	orch.SetDryRun(cfg.Options.DryRun)
+
+	err = c.recovery.start(c.ctx, func(ctx context.Context) {
+		if err := orch.Recover(ctx); err != nil {
+			c.logger.Error("Recovery failed", "error", err)
+			return
+		}
+		c.logger.Info("Recovery completed", "session_id", session.SessionID)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Manual recovery requested", "session_id", session.SessionID)
+	return nil
+}
+
+// Reload reads and validates the configuration file again and, only if it
+// is valid, atomically swaps in the new phases, thresholds and
+// notifications. It is refused while a shutdown is in progress. Adding or
+// removing UPS units and changing connection settings need a restart.
+func (c *daemonControl) Reload() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// Catch executor errors now rather than at the next outage
+	if _, err := buildPhases(cfg, cfg.Phases, c.pxClient); err != nil {
+		return fmt.Errorf("building phases: %w", err)
+	}
+	if _, err := buildPhases(cfg, cfg.EmergencyPhases, c.pxClient); err != nil {
+		return fmt.Errorf("building emergency phases: %w", err)
+	}
+
+	// old is read where the configuration is swapped, so that concurrent
+	// reloads each diff against the configuration they replaced
+	var old *Config
+	var thresholdErr error
+	err = c.daemon.Reconfigure(daemonConfig(cfg), func() {
+		for _, src := range cfg.UPSSources() {
+			if !containsString(c.group.Members(), src.Label()) {
+				continue
+			}
+			if err := c.group.SetThresholds(src.Label(), monitorThresholds(cfg, src)); err != nil {
+				thresholdErr = err
+			}
+		}
+		old = c.config.Swap(cfg)
+	})
+	if err != nil {
+		return fmt.Errorf("reload refused: %w", err)
+	}
+	if thresholdErr != nil {
+		c.logger.Error("Failed to update UPS thresholds", "error", thresholdErr)
+	}
+
+	changes := configDiff(old, cfg)
+	for _, change := range changes {
+		c.logger.Info("Configuration changed", "change", change)
+	}
+	if needsRestart(old, cfg) {
+		c.logger.Info("UPS, Proxmox, control or metrics settings changed, restart the daemon to apply them")
+	}
+
+	c.logger.Info("Configuration reloaded", "changes", len(changes),
+		"phases", len(cfg.Phases), "emergency_phases", len(cfg.EmergencyPhases))
+	return nil
+}
+
+// needsRestart reports whether settings only read at startup have changed
+func needsRestart(old, cfg *Config) bool {
+	if len(old.UPSSources()) != len(cfg.UPSSources()) || old.UPS.Policy != cfg.UPS.Policy ||
+		old.UPS.Quorum != cfg.UPS.Quorum {
+		return true
+	}
+	for i, src := range cfg.UPSSources() {
+		prev := old.UPSSources()[i]
+		if src.Label() != prev.Label() || src.Driver != prev.Driver || !reflect.DeepEqual(src.NUTAuth, prev.NUTAuth) ||
+			!reflect.DeepEqual(src.SNMP, prev.SNMP) || !reflect.DeepEqual(src.Simulate, prev.Simulate) {
+			return true
+		}
+	}
+
+	return old.Proxmox != cfg.Proxmox ||
+		old.Options.ControlSocket != cfg.Options.ControlSocket ||
+		old.Options.ControlListen != cfg.Options.ControlListen ||
+		old.Options.ControlToken != cfg.Options.ControlToken ||
+		old.Options.MetricsListen != cfg.Options.MetricsListen
+}
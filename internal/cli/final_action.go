@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+// shutdownMessage returns the message broadcast to logged-in users by
+// shutdown(8) for the session trigger and reason
+func shutdownMessage(trigger daemon.Trigger, reason string) string {
+	var cause string
+	switch trigger {
+	case daemon.TriggerCritical:
+		cause = "UPS battery critical"
+	case daemon.TriggerEmergency:
+		cause = "UPS battery emergency"
+	case daemon.TriggerOnBatteryTimer:
+		cause = "UPS on battery for too long"
+	case daemon.TriggerManual:
+		cause = "Shutdown requested"
+	default:
+		cause = "UPS shutdown"
+	}
+	// The reason may come from ctl or a NUT message and span several lines
+	reason = strings.Join(strings.Fields(reason), " ")
+	if reason == "" {
+		return cause
+	}
+	return cause + " - " + reason
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// executeFinalAction runs the configured final action once the phases are
+// done: it signals NUT, then powers off the host (or runs the configured
+// command) with a message built from the session trigger and reason.
+// Closing abort during the initial delay cancels it with
+// orchestrator.ErrAborted.
+func executeFinalAction(cfg *Config, trigger daemon.Trigger, reason string, delay time.Duration, abort <-chan struct{}) error {
+	final := cfg.FinalAction
+	if delay > 0 {
+		fmt.Printf("⏳ Waiting %s before final action (%s)...\n", delay, final.Type)
+		select {
+		case <-abort:
+			return orchestrator.ErrAborted
+		case <-time.After(delay):
+		}
+	}
+
+	// Past this point the UPS may switch off, so nothing can be aborted
+	signalNUT(cfg)
+
+	command := finalCommand(final, shutdownMessage(trigger, reason))
+	if command == "" {
+		fmt.Println("🟢 Final action: none, host left running")
+		return nil
+	}
+
+	fmt.Printf("🔴 Final action: %s\n", final.Type)
+	exec := executor.NewLocalExecutor(command)
+	exec.Timeout = 30 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := exec.Execute(ctx)
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%s command failed: %s", final.Type, result.Error)
+	}
+
+	return nil
+}
+
+// finalCommand returns the local command of the final action, or "" for
+// none. message is broadcast by shutdown(8).
+func finalCommand(final FinalActionConfig, message string) string {
+	switch final.Type {
+	case FinalNone:
+		return ""
+	case FinalCommand:
+		return final.Command
+	case FinalHalt:
+		return "shutdown -H now " + shellQuote(message)
+	default: // poweroff, reboot-on-return
+		return "shutdown -h now " + shellQuote(message)
+	}
+}
+
+// powerdownFlagMagic is the content upsmon -K expects in POWERDOWNFLAG
+const powerdownFlagMagic = "upsmon-shutdown-file\n"
+
+// writePowerdownFlag creates the POWERDOWNFLAG checked by the NUT shutdown
+// hook, which then runs upsdrvctl shutdown once the host is nearly down
+func writePowerdownFlag(path string) error {
+	if err := os.WriteFile(path, []byte(powerdownFlagMagic), 0644); err != nil {
+		return fmt.Errorf("writing powerdown flag: %w", err)
+	}
+	return nil
+}
+
+// signalNUT creates the powerdown flag and sends the configured NUT commands
+// to every UPS. Failures are reported but never prevent the host action.
+func signalNUT(cfg *Config) {
+	final := cfg.FinalAction
+	instCmd := final.instCmd()
+	var setVars map[string]string
+	var fsd bool
+	if final.NUT != nil {
+		setVars = final.NUT.SetVars
+		fsd = final.NUT.FSD
+		if final.NUT.PowerdownFlag != "" {
+			if err := writePowerdownFlag(final.NUT.PowerdownFlag); err != nil {
+				fmt.Printf("⚠️ NUT: %v\n", err)
+			} else {
+				fmt.Printf("📡 NUT: %s created, the UPS switches off at the end of the host shutdown\n", final.NUT.PowerdownFlag)
+			}
+		}
+	}
+	if len(setVars) == 0 && instCmd == "" && !fsd {
+		return
+	}
+
+	names := make([]string, 0, len(setVars))
+	for name := range setVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, src := range cfg.UPSSources() {
+		if src.Driver == ups.DriverApcupsd || src.Driver == ups.DriverSNMP {
+			fmt.Printf("⚠️ %s: NUT commands are not supported by %s\n", src.Label(), driverName(src))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		client, err := newNUTClient(src)
+		if err == nil {
+			err = client.Connect()
+		}
+		if err != nil {
+			fmt.Printf("⚠️ NUT %s: %v\n", src.Label(), err)
+			cancel()
+			continue
+		}
+
+		for _, name := range names {
+			if err := client.SetVar(ctx, name, setVars[name]); err != nil {
+				fmt.Printf("⚠️ NUT %s: SET VAR %s: %v\n", src.Label(), name, err)
+			}
+		}
+		if instCmd != "" {
+			if err := client.InstCmd(ctx, instCmd); err != nil {
+				fmt.Printf("⚠️ NUT %s: INSTCMD %s: %v\n", src.Label(), instCmd, err)
+			} else {
+				fmt.Printf("📡 NUT %s: %s sent\n", src.Label(), instCmd)
+			}
+		}
+		if fsd {
+			if err := client.FSD(ctx); err != nil {
+				fmt.Printf("⚠️ NUT %s: FSD: %v\n", src.Label(), err)
+			} else {
+				fmt.Printf("📡 NUT %s: forced shutdown raised\n", src.Label())
+			}
+		}
+
+		client.Close()
+		cancel()
+	}
+}
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/journal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historySince string
+	historyUntil string
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List past outages and shutdown sessions",
+	Long: `Lists the outages and shutdown sessions recorded in the journal
+(options.journal_file) with a summary.
+
+--since and --until take a date (2024-03-01), an RFC 3339 time or an age
+such as 12h or 30d.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		since, err := parseHistoryTime(historySince, now, false)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+		until, err := parseHistoryTime(historyUntil, now, true)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+
+		entries, err := journal.Read(cfg.Options.JournalFile)
+		if err != nil {
+			return err
+		}
+		outages, sessions := journal.History(entries)
+
+		inRange := func(t time.Time) bool {
+			return (since.IsZero() || !t.Before(since)) && (until.IsZero() || t.Before(until))
+		}
+		var shown []journal.Outage
+		for _, o := range outages {
+			if inRange(o.Start) {
+				shown = append(shown, o)
+			}
+		}
+		var manual []journal.Entry
+		for _, s := range sessions {
+			if inRange(s.Time) {
+				manual = append(manual, s)
+			}
+		}
+
+		fmt.Printf("📜 Power history (%s)\n", cfg.Options.JournalFile)
+		if len(shown) == 0 && len(manual) == 0 {
+			fmt.Println("   No outage recorded")
+			return nil
+		}
+
+		for _, o := range shown {
+			fmt.Printf("⚡ %s  %s\n", o.Start.Local().Format("2006-01-02 15:04:05"), describeOutage(o))
+			for _, s := range o.Sessions {
+				fmt.Printf("   🛑 %s\n", describeSession(s))
+			}
+		}
+		for _, s := range manual {
+			fmt.Printf("🛑 %s  %s\n", s.Time.Local().Format("2006-01-02 15:04:05"), describeSession(s))
+		}
+
+		sum := journal.Summarize(shown)
+		fmt.Printf("\n📊 %d outage(s), %s on battery", sum.Outages, sum.Total.Round(time.Second))
+		if sum.Outages > 0 {
+			fmt.Printf(" (longest %s)", sum.Longest.Round(time.Second))
+		}
+		if sum.MinCharge != nil {
+			fmt.Printf(", lowest charge %d%%", *sum.MinCharge)
+		}
+		fmt.Printf(", %d with shutdown", sum.Shutdowns)
+		if len(manual) > 0 {
+			fmt.Printf(", %d session(s) on line power", len(manual))
+		}
+		fmt.Println()
+
+		return nil
+	},
+}
+
+// describeOutage summarizes an outage on one line
+func describeOutage(o journal.Outage) string {
+	var parts []string
+	if o.End.IsZero() {
+		parts = append(parts, "on battery, no restore recorded")
+	} else {
+		parts = append(parts, fmt.Sprintf("on battery for %s", o.Duration().Round(time.Second)))
+	}
+	if o.UPS != "" {
+		parts = append(parts, o.UPS)
+	}
+	if o.MinCharge != nil {
+		parts = append(parts, fmt.Sprintf("min %d%%", *o.MinCharge))
+	}
+	switch {
+	case o.Aborted:
+		parts = append(parts, "shutdown aborted")
+	case o.Shutdown:
+		parts = append(parts, "shutdown")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describeSession summarizes a shutdown session on one line
+func describeSession(s journal.Entry) string {
+	line := fmt.Sprintf("session %s: %s %s in %s", s.SessionID, s.Plan, s.Status, s.Duration.Round(time.Second))
+	if s.Reason != "" {
+		line += " (" + s.Reason + ")"
+	}
+	return line
+}
+
+// parseHistoryTime parses a date, an RFC 3339 time or an age such as 12h
+// or 30d. A date used as an upper bound includes the whole day.
+func parseHistoryTime(value string, now time.Time, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid age %q", value)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("expected a date, an RFC 3339 time or an age, got %q", value)
+	}
+	return now.Add(-d), nil
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historySince, "since", "", "Only show outages from this date or age")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "Only show outages before this date or age")
+
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		value    string
+		endOfDay bool
+		want     time.Time
+	}{
+		{value: "", want: time.Time{}},
+		{value: "2024-03-05", want: day},
+		{value: "2024-03-05", endOfDay: true, want: day.AddDate(0, 0, 1)},
+		{value: "2024-03-05T08:00:00Z", want: time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC)},
+		{value: "12h", want: now.Add(-12 * time.Hour)},
+		{value: "7d", want: now.AddDate(0, 0, -7)},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.value, now, tt.endOfDay)
+		if err != nil {
+			t.Errorf("parseHistoryTime(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseHistoryTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"yesterday", "-3d", "-1h", "2024-13-01"} {
+		if _, err := parseHistoryTime(value, now, false); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/metrics"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+)
+
+// upsStatusFlags are the ups.status flags exported as guardian_ups_status
+var upsStatusFlags = []string{"OL", "OB", "LB", "HB", "RB", "CHRG", "DISCHRG", "BYPASS", "CAL", "OFF", "OVER", "TRIM", "BOOST", "FSD"}
+
+// powerStates are the daemon states exported as guardian_power_state
+var powerStates = []daemon.State{
+	daemon.StateOnline,
+	daemon.StateOnBattery,
+	daemon.StateWarning,
+	daemon.StateCritical,
+	daemon.StateEmergency,
+	daemon.StateShuttingDown,
+}
+
+// guardianMetrics holds the Prometheus metrics exported by the daemon. It
+// also observes the orchestrator to record phase, action and session
+// outcomes.
+type guardianMetrics struct {
+	registry *metrics.Registry
+
+	batteryCharge  *metrics.GaugeVec
+	batteryRuntime *metrics.GaugeVec
+	load           *metrics.GaugeVec
+	upsStatus      *metrics.GaugeVec
+	lastUpdate     *metrics.GaugeVec
+	powerState     *metrics.GaugeVec
+	onBattery      *metrics.GaugeVec
+	pollErrors     *metrics.CounterVec
+
+	apiDuration *metrics.SummaryVec
+	apiErrors   *metrics.CounterVec
+
+	phaseDuration  *metrics.SummaryVec
+	phaseRuns      *metrics.CounterVec
+	actionDuration *metrics.SummaryVec
+	actionRuns     *metrics.CounterVec
+	sessions       *metrics.CounterVec
+	sessionTime    *metrics.SummaryVec
+	recovered      *metrics.CounterVec
+}
+
+func newGuardianMetrics() *guardianMetrics {
+	r := metrics.NewRegistry()
+	return &guardianMetrics{
+		registry: r,
+
+		batteryCharge:  r.NewGaugeVec("guardian_ups_battery_charge_percent", "Battery charge reported by the UPS", "ups"),
+		batteryRuntime: r.NewGaugeVec("guardian_ups_battery_runtime_seconds", "Battery runtime remaining reported by the UPS", "ups"),
+		load:           r.NewGaugeVec("guardian_ups_load_percent", "Load reported by the UPS", "ups"),
+		upsStatus:      r.NewGaugeVec("guardian_ups_status", "Whether the flag is set in ups.status", "ups", "flag"),
+		lastUpdate:     r.NewGaugeVec("guardian_ups_last_update_timestamp_seconds", "Time of the last status received from the UPS", "ups"),
+		powerState:     r.NewGaugeVec("guardian_power_state", "Current power state of the daemon", "state"),
+		onBattery:      r.NewGaugeVec("guardian_on_battery_seconds", "Time spent on battery in the current outage, 0 on line power"),
+		pollErrors:     r.NewCounterVec("guardian_nut_poll_errors_total", "Failed NUT status polls", "ups"),
+
+		apiDuration: r.NewSummaryVec("guardian_proxmox_api_request_duration_seconds", "Duration of Proxmox API requests", "method"),
+		apiErrors:   r.NewCounterVec("guardian_proxmox_api_errors_total", "Failed Proxmox API requests", "method"),
+
+		phaseDuration:  r.NewSummaryVec("guardian_phase_duration_seconds", "Duration of shutdown phases", "phase"),
+		phaseRuns:      r.NewCounterVec("guardian_phase_runs_total", "Shutdown phases run by outcome", "phase", "outcome"),
+		actionDuration: r.NewSummaryVec("guardian_action_duration_seconds", "Duration of shutdown actions", "phase", "action"),
+		actionRuns:     r.NewCounterVec("guardian_action_runs_total", "Shutdown actions run by outcome", "phase", "action", "outcome"),
+		sessions:       r.NewCounterVec("guardian_shutdown_sessions_total", "Shutdown sessions by outcome", "outcome"),
+		sessionTime:    r.NewSummaryVec("guardian_shutdown_session_duration_seconds", "Duration of shutdown sessions", "outcome"),
+		recovered:      r.NewCounterVec("guardian_recovery_actions_total", "Actions reversed by recovery by outcome", "outcome"),
+	}
+}
+
+// collectDaemon refreshes the UPS and power state gauges from d before
+// every scrape
+func (m *guardianMetrics) collectDaemon(d *daemon.Daemon) {
+	m.registry.OnCollect(func() {
+		m.batteryCharge.Reset()
+		m.batteryRuntime.Reset()
+		m.load.Reset()
+		m.upsStatus.Reset()
+		m.lastUpdate.Reset()
+
+		for name, s := range d.Statuses() {
+			m.batteryCharge.Set(float64(s.BatteryCharge), name)
+			m.batteryRuntime.Set(float64(s.Runtime), name)
+			m.load.Set(float64(s.Load), name)
+			m.lastUpdate.Set(float64(s.Timestamp.Unix()), name)
+
+			flags := strings.Fields(s.Status)
+			for _, flag := range upsStatusFlags {
+				m.upsStatus.Set(boolValue(containsString(flags, flag)), name, flag)
+			}
+		}
+
+		state := d.State()
+		for _, s := range powerStates {
+			m.powerState.Set(boolValue(s == state), string(s))
+		}
+
+		var onBattery time.Duration
+		if since := d.OnBatterySince(); !since.IsZero() {
+			onBattery = time.Since(since)
+		}
+		m.onBattery.Set(onBattery.Seconds())
+	})
+}
+
+// PollError counts a failed NUT poll
+func (m *guardianMetrics) PollError(ups string) {
+	m.pollErrors.Inc(ups)
+}
+
+// APIRequest records a Proxmox API request
+func (m *guardianMetrics) APIRequest(method string, d time.Duration, err error) {
+	m.apiDuration.Observe(d.Seconds(), method)
+	if err != nil {
+		m.apiErrors.Inc(method)
+	}
+}
+
+// PhaseDone implements orchestrator.Observer
+func (m *guardianMetrics) PhaseDone(phase string, d time.Duration, err error) {
+	outcome := "success"
+	switch {
+	case errors.Is(err, orchestrator.ErrAborted):
+		outcome = "aborted"
+	case err != nil:
+		outcome = "failure"
+	}
+	m.phaseDuration.Observe(d.Seconds(), phase)
+	m.phaseRuns.Inc(phase, outcome)
+}
+
+// ActionDone implements orchestrator.Observer
+func (m *guardianMetrics) ActionDone(phase, action string, d time.Duration, success bool) {
+	outcome := "success"
+	if !success {
+		outcome = "failure"
+	}
+	m.actionDuration.Observe(d.Seconds(), phase, action)
+	m.actionRuns.Inc(phase, action, outcome)
+}
+
+// SessionDone implements orchestrator.Observer
+func (m *guardianMetrics) SessionDone(status string, d time.Duration) {
+	m.sessions.Inc(status)
+	m.sessionTime.Observe(d.Seconds(), status)
+}
+
+// RecoveryDone implements orchestrator.Observer
+func (m *guardianMetrics) RecoveryDone(recovered, failed int) {
+	m.recovered.Add(float64(recovered), "success")
+	m.recovered.Add(float64(failed), "failure")
+}
+
+// serveMetrics exposes the metrics on addr until ctx is done
+func (m *guardianMetrics) serveMetrics(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() { _ = srv.Serve(ln) }()
+
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/control"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/notifier"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/proxmox"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+	"github.com/spf13/cobra"
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify [event]",
+	Short: "Handle NUT notification event",
+	Long: `Called by NUT's NOTIFYCMD when UPS events occur.
+Events: ONLINE, ONBATT, LOWBATT, FSD, COMMOK, COMMBAD, SHUTDOWN, REPLBATT, NOCOMM
+
+The event is forwarded to the running daemon. When no daemon is running,
+the configured notifications are sent directly and LOWBATT/FSD run the
+shutdown plan.
+
+When called by upsmon without an event argument, NOTIFYTYPE and UPSNAME
+are read from the environment and the argument is taken as the message.
+
+  # /etc/nut/upsmon.conf
+  NOTIFYCMD /usr/local/bin/proxmox-guardian notify
+  NOTIFYFLAG ONBATT SYSLOG+EXEC`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		n, err := notificationFromArgs(args)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("📨 Received NUT event: %s\n", n.Type)
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		client := control.NewClient(cfg.Options.ControlSocket)
+		err = client.Notify(ctx, n)
+		if err == nil {
+			fmt.Println("✅ Forwarded to daemon")
+			return nil
+		}
+		if !errors.Is(err, control.ErrNotRunning) {
+			return fmt.Errorf("forwarding event to daemon: %w", err)
+		}
+
+		fmt.Println("⚠️ Daemon not running, handling event directly")
+		return handleNotifyLocally(ctx, cfg, n)
+	},
+}
+
+// notificationFromArgs builds the notification from the command argument
+// and the NOTIFYTYPE/UPSNAME variables set by upsmon
+func notificationFromArgs(args []string) (control.Notification, error) {
+	n := control.Notification{
+		Type: os.Getenv("NOTIFYTYPE"),
+		UPS:  os.Getenv("UPSNAME"),
+	}
+
+	if len(args) > 0 {
+		if _, ok := ups.ParseNotifyType(args[0]); ok {
+			n.Type = args[0]
+		} else {
+			// upsmon passes its message as the only argument
+			n.Message = args[0]
+		}
+	}
+
+	t, ok := ups.ParseNotifyType(n.Type)
+	if !ok {
+		if n.Type == "" {
+			return n, fmt.Errorf("no event given and NOTIFYTYPE is not set")
+		}
+		return n, fmt.Errorf("unknown NUT event: %s", n.Type)
+	}
+	n.Type = string(t)
+
+	return n, nil
+}
+
+// notifyEventNames maps NUT events to notification event names
+var notifyEventNames = map[ups.NotifyType]string{
+	ups.NotifyOnline:   "power_restored",
+	ups.NotifyOnBatt:   "power_lost",
+	ups.NotifyLowBatt:  "battery_critical",
+	ups.NotifyFSD:      "battery_emergency",
+	ups.NotifyCommOK:   "ups_comm_restored",
+	ups.NotifyCommBad:  "ups_comm_lost",
+	ups.NotifyNoComm:   "ups_comm_lost",
+	ups.NotifyReplBatt: "ups_replace_battery",
+	ups.NotifyShutdown: "ups_shutdown",
+}
+
+func notifyData(n control.Notification) map[string]interface{} {
+	data := map[string]interface{}{
+		"nut_event": n.Type,
+	}
+	if n.UPS != "" {
+		data["ups"] = n.UPS
+	}
+	if n.Message != "" {
+		data["message"] = n.Message
+	}
+	return data
+}
+
+// handleNotifyLocally sends the notification and, for LOWBATT and FSD,
+// runs the shutdown plan when no daemon is there to do it
+func handleNotifyLocally(ctx context.Context, cfg *Config, n control.Notification) error {
+	t, _ := ups.ParseNotifyType(n.Type)
+
+	if err := newNotifier(cfg).Notify(notifyEventNames[t], notifyData(n)); err != nil {
+		fmt.Printf("⚠️ Notification failed: %v\n", err)
+	}
+
+	var plan daemon.Plan
+	var trigger daemon.Trigger
+	switch t {
+	case ups.NotifyLowBatt:
+		plan, trigger = daemon.PlanGraceful, daemon.TriggerCritical
+	case ups.NotifyFSD:
+		plan, trigger = daemon.PlanGraceful, daemon.TriggerEmergency
+		if len(cfg.EmergencyPhases) > 0 {
+			plan = daemon.PlanEmergency
+		}
+	default:
+		return nil
+	}
+
+	release, err := acquireLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	pxClient, err := proxmox.NewClient(proxmox.Config{
+		APIURL:      cfg.Proxmox.APIURL,
+		TokenID:     cfg.Proxmox.TokenID,
+		TokenSecret: cfg.Proxmox.TokenSecret,
+		InsecureTLS: cfg.Proxmox.InsecureTLS,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox client: %w", err)
+	}
+
+	ev, _ := t.Event()
+	reason := ev.Message
+	if n.UPS != "" {
+		reason = fmt.Sprintf("%s: %s", n.UPS, reason)
+	}
+
+	// Nothing can abort the plan without a daemon watching the UPS
+	err = runShutdown(ctx, cfg, pxClient, plan, trigger, reason, nil, nil, pollUPSStatus(ctx, cfg))
+	if errors.Is(err, daemon.ErrDryRun) {
+		return nil
+	}
+	return err
+}
+
+// newNotifier creates a notifier for the configured webhooks
+func newNotifier(cfg *Config) *webhookNotifier {
+	var webhooks []notifier.WebhookConfig
+	for _, nc := range cfg.Notifications {
+		if nc.Type != "webhook" {
+			continue
+		}
+		webhooks = append(webhooks, notifier.WebhookConfig{
+			URL:      nc.URL,
+			URLEnv:   nc.URLEnv,
+			Events:   nc.Events,
+			Template: nc.Template,
+		})
+	}
+
+	return &webhookNotifier{webhooks: notifier.NewNotifier(webhooks)}
+}
+
+// webhookNotifier prints events and sends them to the configured webhooks
+type webhookNotifier struct {
+	webhooks *notifier.Notifier
+}
+
+func (n *webhookNotifier) Notify(event string, data map[string]interface{}) error {
+	fmt.Printf("📣 Event: %s\n", event)
+	return n.webhooks.Notify(event, data)
+}
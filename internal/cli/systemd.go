@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/systemd"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+const (
+	// monitorStallTimeout is how long the UPS monitors may go without
+	// polling before the watchdog stops being fed
+	monitorStallTimeout = time.Minute
+
+	// statusInterval is how often STATUS= is refreshed without a watchdog
+	statusInterval = 10 * time.Second
+)
+
+// runSystemdNotifier keeps the systemd status line up to date and feeds the
+// watchdog (WatchdogSec=) while both the daemon loop and the UPS monitors
+// are alive, until ctx is done
+func runSystemdNotifier(ctx context.Context, store *configStore, d *daemon.Daemon, group *ups.Group, logger daemon.Logger) {
+	watchdog := systemd.WatchdogInterval()
+	interval := statusInterval
+	if watchdog > 0 {
+		interval = watchdog / 2
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := systemd.Notify(systemd.Status(statusLine(store.Get(), d))); err != nil {
+			logger.Error("systemd notification failed", "error", err)
+		}
+
+		if watchdog > 0 {
+			if err := checkAlive(d, group, watchdog/4); err != nil {
+				// systemd restarts the service once the watchdog expires
+				logger.Error("Health check failed, not feeding the watchdog", "error", err)
+			} else if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				logger.Error("systemd notification failed", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAlive reports an error if the daemon loop or a UPS monitor hangs
+func checkAlive(d *daemon.Daemon, group *ups.Group, timeout time.Duration) error {
+	if err := d.Ping(timeout); err != nil {
+		return err
+	}
+
+	// A stalled monitor must not get the host killed mid-shutdown
+	if d.State() == daemon.StateShuttingDown {
+		return nil
+	}
+	if last := group.LastPoll(); !last.IsZero() && time.Since(last) > monitorStallTimeout {
+		return fmt.Errorf("UPS monitor has not polled for %s", time.Since(last).Round(time.Second))
+	}
+	return nil
+}
+
+// statusLine summarizes the power state, the battery of every UPS and the
+// running shutdown phase for `systemctl status`
+func statusLine(cfg *Config, d *daemon.Daemon) string {
+	parts := []string{string(d.State())}
+
+	statuses := d.Statuses()
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := statuses[name]
+		part := fmt.Sprintf("%s: %d%% %s [%s]",
+			name, s.BatteryCharge, time.Duration(s.Runtime)*time.Second, s.Status)
+		if s.Estimate != nil {
+			part += " " + s.Estimate.String()
+		}
+		parts = append(parts, part)
+	}
+
+	if plan := d.Plan(); plan != "" {
+		phases := cfg.Phases
+		if plan == daemon.PlanEmergency {
+			phases = cfg.EmergencyPhases
+		}
+		running := fmt.Sprintf("%s shutdown", plan)
+		if session, err := orchestrator.ReadState(cfg.Options.StateFile); err == nil && session != nil &&
+			session.Status == "in_progress" && session.CurrentPhase < len(phases) {
+			running += fmt.Sprintf(", phase %d/%d %s", session.CurrentPhase+1, len(phases), phases[session.CurrentPhase].Name)
+		}
+		parts = append(parts, running)
+	}
+
+	return strings.Join(parts, " | ")
+}
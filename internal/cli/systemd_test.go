@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/daemon"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+func TestStatusLine(t *testing.T) {
+	d := daemon.NewDaemon(daemon.Config{}, ups.NewGroup(ups.PolicyAny, 0, nil), nil, &slogLogger{slog.New(slog.NewTextHandler(io.Discard, nil))}, nil)
+	d.HandleStatus(&ups.Status{Source: "eaton@localhost", Status: "OB DISCHRG", BatteryCharge: 87, Runtime: 1200})
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost})
+
+	expected := "on_battery | eaton@localhost: 87% 20m0s [OB DISCHRG]"
+	if got := statusLine(&Config{}, d); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	d.HandleStatus(&ups.Status{Source: "eaton@localhost", Status: "OB DISCHRG", BatteryCharge: 80, Runtime: 1100,
+		Estimate: &ups.Estimate{Rate: 2, Empty: 40 * time.Minute, Warning: 15 * time.Minute, Critical: 25 * time.Minute,
+			Emergency: -1, MinRuntime: -1}})
+	expected = "on_battery | eaton@localhost: 80% 18m20s [OB DISCHRG] -2.0%/min, warning in 15m0s, empty in 40m0s"
+	if got := statusLine(&Config{}, d); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
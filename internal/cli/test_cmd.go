@@ -1,23 +1,25 @@
 package cli
 
 import (
-"context"
-"fmt"
-"time"
-
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
-"github.com/Guilhem-Bonnet/proxmox-guardian/internal/proxmox"
-"log/slog"
-
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/proxmox"
 	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+	"log/slog"
+
 	"github.com/spf13/cobra"
 )
 
 var (
-dryRun     bool
-testPhase  int
-testAction int
+	dryRun     bool
+	testPhase  int
+	testAction int
 )
 
 var testCmd = &cobra.Command{
@@ -40,31 +42,64 @@ var testConnectionCmd = &cobra.Command{
 		ctx := context.Background()
 		hasError := false
 
-		// Test NUT connection
-		fmt.Println("🔌 Testing NUT connection...")
-		nutClient := ups.NewClient(cfg.UPS.Host+":3493", cfg.UPS.Name)
-		if err := nutClient.Connect(); err != nil {
-			fmt.Printf("   ❌ NUT: Failed - %v\n", err)
-			hasError = true
-		} else {
-			status, err := nutClient.GetStatus(ctx)
+		// Test UPS connections
+		fmt.Println("🔌 Testing UPS connection...")
+		for _, src := range cfg.UPSSources() {
+			kind := driverName(src)
+			driver, err := newUPSDriver(src)
 			if err != nil {
-				fmt.Printf("   ❌ NUT: Connected but status failed - %v\n", err)
+				fmt.Printf("   ❌ %s %s: %v\n", kind, src.Label(), err)
+				hasError = true
+				continue
+			}
+			if err := driver.Connect(); err != nil {
+				fmt.Printf("   ❌ %s %s: Failed - %v\n", kind, src.Label(), err)
+				hasError = true
+				continue
+			}
+			// Listing UPS units and instant commands is specific to NUT
+			nutClient, _ := driver.(*ups.Client)
+
+			status, err := driver.GetStatus(ctx)
+			if err != nil {
+				fmt.Printf("   ❌ %s %s: Connected but status failed - %v\n", kind, src.Label(), err)
+				if nutClient != nil && errors.Is(err, ups.ErrUnknownUPS) {
+					if names, err := nutClient.ListUPS(ctx); err == nil {
+						fmt.Printf("      Available UPS: %s\n", strings.Join(sortedKeys(names), ", "))
+					}
+				}
 				hasError = true
 			} else {
-				fmt.Printf("   ✅ NUT: OK - Battery %d%%, Status: %s\n", status.BatteryCharge, status.Status)
+				fmt.Printf("   ✅ %s %s: OK - Battery %d%%, Runtime %ds, Status: %s\n",
+					kind, src.Label(), status.BatteryCharge, status.Runtime, status.Status)
+				fmt.Printf("      Thresholds: warning %d%%, critical %d%%, emergency %d%%\n",
+					src.Thresholds.Warning, src.Thresholds.Critical, src.Thresholds.Emergency)
+
+				// The final action relies on the UPS supporting its instant command
+				if instCmd := cfg.FinalAction.instCmd(); nutClient != nil && instCmd != "" {
+					cmds, err := nutClient.ListCommands(ctx)
+					switch {
+					case err != nil:
+						fmt.Printf("      ⚠️  Cannot list instant commands: %v\n", err)
+					case !containsString(cmds, instCmd):
+						fmt.Printf("      ⚠️  UPS does not support instant command %s\n", instCmd)
+					}
+				}
 			}
-			nutClient.Close()
+			driver.Close()
+		}
+		if len(cfg.UPS.Sources) > 1 {
+			fmt.Printf("   🔀 Redundancy policy: %s\n", cfg.UPS.Policy)
 		}
 
 		// Test Proxmox connection
 		fmt.Println("\n🖥️  Testing Proxmox API connection...")
 		pxClient, err := proxmox.NewClient(proxmox.Config{
-APIURL:      cfg.Proxmox.APIURL,
-TokenID:     cfg.Proxmox.TokenID,
-TokenSecret: cfg.Proxmox.TokenSecret,
-InsecureTLS: cfg.Proxmox.InsecureTLS,
-})
+			APIURL:      cfg.Proxmox.APIURL,
+			TokenID:     cfg.Proxmox.TokenID,
+			TokenSecret: cfg.Proxmox.TokenSecret,
+			InsecureTLS: cfg.Proxmox.InsecureTLS,
+		})
 		if err != nil {
 			fmt.Printf("   ❌ Proxmox: Failed to create client - %v\n", err)
 			hasError = true
@@ -144,9 +179,16 @@ Use --action=N to test only action N within the phase.`,
 			return err
 		}
 
+		dryRun := dryRun || cfg.Options.DryRun
 		if dryRun {
 			fmt.Println("🧪 DRY-RUN MODE - No actions will be executed")
 		} else {
+			release, err := acquireLock(cfg)
+			if err != nil {
+				return err
+			}
+			defer release()
+
 			fmt.Println("⚠️  LIVE MODE - Actions WILL be executed!")
 			fmt.Println("    Press Ctrl+C within 5 seconds to cancel...")
 			time.Sleep(5 * time.Second)
@@ -156,11 +198,11 @@ Use --action=N to test only action N within the phase.`,
 
 		// Create Proxmox client
 		pxClient, err := proxmox.NewClient(proxmox.Config{
-APIURL:      cfg.Proxmox.APIURL,
-TokenID:     cfg.Proxmox.TokenID,
-TokenSecret: cfg.Proxmox.TokenSecret,
-InsecureTLS: cfg.Proxmox.InsecureTLS,
-})
+			APIURL:      cfg.Proxmox.APIURL,
+			TokenID:     cfg.Proxmox.TokenID,
+			TokenSecret: cfg.Proxmox.TokenSecret,
+			InsecureTLS: cfg.Proxmox.InsecureTLS,
+		})
 		if err != nil {
 			return fmt.Errorf("failed to create Proxmox client: %w", err)
 		}
@@ -201,18 +243,22 @@ InsecureTLS: cfg.Proxmox.InsecureTLS,
 				desc := describeAction(cfgAction)
 				fmt.Printf("  [%d.%d] %s\n", phaseNum, actionNum, desc)
 
-				if dryRun {
-					fmt.Printf("        ⏭️  SKIPPED (dry-run)\n")
-					continue
-				}
-
-				// Execute action
 				exec, err := createExecutor(cfg, cfgAction, pxClient)
 				if err != nil {
 					fmt.Printf("        ❌ FAILED to create executor: %v\n", err)
 					continue
 				}
 
+				if dryRun {
+					result, err := executor.DryRun(ctx, exec)
+					if err != nil {
+						fmt.Printf("        ❌ FAILED to resolve: %v\n", err)
+					} else {
+						fmt.Printf("        ⏭️  SKIPPED (dry-run): %s\n", truncate(result.Output, 100))
+					}
+					continue
+				}
+
 				start := time.Now()
 				result, err := exec.Execute(ctx)
 				duration := time.Since(start)
@@ -256,9 +302,16 @@ Use --dry-run to simulate without executing.`,
 			return err
 		}
 
+		dryRun := dryRun || cfg.Options.DryRun
 		if dryRun {
 			fmt.Println("🧪 DRY-RUN MODE - No actions will be executed")
 		} else {
+			release, err := acquireLock(cfg)
+			if err != nil {
+				return err
+			}
+			defer release()
+
 			fmt.Println("⚠️  LIVE MODE - Recovery actions WILL be executed!")
 			fmt.Println("    Press Ctrl+C within 5 seconds to cancel...")
 			time.Sleep(5 * time.Second)
@@ -268,31 +321,28 @@ Use --dry-run to simulate without executing.`,
 
 		// Create Proxmox client
 		pxClient, err := proxmox.NewClient(proxmox.Config{
-APIURL:      cfg.Proxmox.APIURL,
-TokenID:     cfg.Proxmox.TokenID,
-TokenSecret: cfg.Proxmox.TokenSecret,
-InsecureTLS: cfg.Proxmox.InsecureTLS,
-})
+			APIURL:      cfg.Proxmox.APIURL,
+			TokenID:     cfg.Proxmox.TokenID,
+			TokenSecret: cfg.Proxmox.TokenSecret,
+			InsecureTLS: cfg.Proxmox.InsecureTLS,
+		})
 		if err != nil {
 			return fmt.Errorf("failed to create Proxmox client: %w", err)
 		}
 
-		// Build phases and execute recovery
-		phases, err := buildPhasesFromConfig(cfg, pxClient)
+		// Rebuild the phases the last session ran and recover it
+		logger := &slogLogger{slog.Default()}
+		orch, session, err := recoveryOrchestrator(cfg, pxClient, logger, &noopNotifier{})
 		if err != nil {
-			return fmt.Errorf("failed to build phases: %w", err)
+			return err
 		}
 
-		logger := &slogLogger{slog.Default()}
-		orch := orchestrator.NewOrchestrator(phases, cfg.Options.StateFile, logger, &noopNotifier{})
-
 		if dryRun {
-			fmt.Println("\n📋 Recovery commands that would be executed:")
-			for _, phase := range cfg.Phases {
-				for _, action := range phase.Actions {
-					if action.Recovery != "" {
-						fmt.Printf("  - [%s] %s\n", action.Type, action.Recovery)
-					}
+			fmt.Printf("\n📋 Recovery commands that would be executed for session %s:\n", session.SessionID)
+			for i := len(session.CompletedActions) - 1; i >= 0; i-- {
+				action := session.CompletedActions[i]
+				if action.Success && action.RecoveryCmd != "" {
+					fmt.Printf("  - [%s] %s\n", action.ActionType, action.RecoveryCmd)
 				}
 			}
 			fmt.Println("\n✅ Dry-run completed")
@@ -333,6 +383,7 @@ func describeAction(action Action) string {
 
 func init() {
 	testCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Simulate without executing actions")
+	testCmd.PersistentFlags().BoolVar(&forceLock, "force", false, "Run even if another instance holds the lock file")
 	testShutdownCmd.Flags().IntVar(&testPhase, "phase", 0, "Test only this phase (1-based)")
 	testShutdownCmd.Flags().IntVar(&testAction, "action", 0, "Test only this action within the phase (1-based)")
 
@@ -342,3 +393,12 @@ func init() {
 
 	rootCmd.AddCommand(testCmd)
 }
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
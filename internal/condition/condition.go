@@ -0,0 +1,580 @@
+// Package condition evaluates the expressions that decide whether a
+// shutdown phase runs, e.g. `battery.charge < 15 || trigger == "manual"`.
+//
+// The language has numbers, strings and booleans, the comparison operators
+// == != < <= > >=, the logical operators && || ! and parentheses. Numbers
+// may carry a duration unit (90s, 5m, 1h) and are then counted in seconds.
+// `"OB" in ups.status` tests for a status flag, and `phase("name")` returns
+// the result of an earlier phase. Expressions are type checked when parsed.
+package condition
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+// Phase results seen by phase() and previous
+const (
+	PhaseCompleted = "completed"
+	PhaseFailed    = "failed"
+	PhaseSkipped   = "skipped"
+)
+
+// Env holds the values an expression is evaluated against
+type Env struct {
+	// Status is the UPS status, nil when unknown
+	Status *ups.Status
+	// Trigger is the kind of trigger: critical, emergency,
+	// on_battery_timer or manual
+	Trigger string
+	Reason  string
+	// Elapsed is the time since the session started
+	Elapsed time.Duration
+	// Phases maps the names of the phases already run to their result
+	Phases map[string]string
+	// Previous is the result of the phase just before, empty for the first
+	Previous string
+}
+
+// kind is the static type of an expression
+type kind int
+
+const (
+	kindBool kind = iota
+	kindNumber
+	kindString
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindBool:
+		return "boolean"
+	case kindNumber:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// variable is a name an expression can read
+type variable struct {
+	kind kind
+	get  func(env *Env) (interface{}, error)
+}
+
+// upsValue reads a field of the UPS status, failing when it is unknown
+func upsValue(get func(s *ups.Status) interface{}) func(env *Env) (interface{}, error) {
+	return func(env *Env) (interface{}, error) {
+		if env.Status == nil {
+			return nil, fmt.Errorf("UPS status unknown")
+		}
+		return get(env.Status), nil
+	}
+}
+
+func batteryCharge(s *ups.Status) interface{} { return float64(s.BatteryCharge) }
+
+var variables = map[string]variable{
+	"battery.charge":  {kindNumber, upsValue(batteryCharge)},
+	"battery":         {kindNumber, upsValue(batteryCharge)}, // Short for battery.charge
+	"battery.runtime": {kindNumber, upsValue(func(s *ups.Status) interface{} { return float64(s.Runtime) })},
+	"ups.load":        {kindNumber, upsValue(func(s *ups.Status) interface{} { return float64(s.Load) })},
+	"ups.status":      {kindString, upsValue(func(s *ups.Status) interface{} { return s.Status })},
+	"trigger": {kindString, func(env *Env) (interface{}, error) {
+		return env.Trigger, nil
+	}},
+	"reason": {kindString, func(env *Env) (interface{}, error) {
+		return env.Reason, nil
+	}},
+	"session.elapsed": {kindNumber, func(env *Env) (interface{}, error) {
+		return env.Elapsed.Seconds(), nil
+	}},
+	"previous": {kindString, func(env *Env) (interface{}, error) {
+		return env.Previous, nil
+	}},
+}
+
+// Expr is a parsed condition
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse parses and type checks a condition. phases are the names of the
+// phases that run before it, the only ones phase() may refer to.
+func Parse(src string, phases []string) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, phases: phases}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("column %d: unexpected %s", t.pos, t)
+	}
+	if root.kind() != kindBool {
+		return nil, fmt.Errorf("condition is a %s, not a boolean", root.kind())
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression. An operand that cannot be evaluated,
+// e.g. without UPS status, is an error unless the other side of && or ||
+// decides the result on its own.
+func (e *Expr) Eval(env Env) (bool, error) {
+	v, err := e.root.eval(&env)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int // 1-based column
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of condition"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+var units = map[string]float64{"s": 1, "m": 60, "h": 3600}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid number %q", start+1, src[start:i])
+			}
+			unitStart := i
+			for i < len(src) && isIdentChar(src[i]) {
+				i++
+			}
+			if unit := src[unitStart:i]; unit != "" {
+				factor, ok := units[unit]
+				if !ok {
+					return nil, fmt.Errorf("column %d: unknown unit %q (use s, m or h)", unitStart+1, unit)
+				}
+				n *= factor
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], num: n, pos: start + 1})
+
+		case c == '"':
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("column %d: unterminated string", start+1)
+			}
+			i++
+			text, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid string %s", start+1, src[start:i])
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: start + 1})
+
+		case isIdentChar(c):
+			start := i
+			for i < len(src) && (isIdentChar(src[i]) || src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start + 1})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i + 1})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("column %d: unexpected character %q", i+1, c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src) + 1}), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	phases []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("column %d: expected %q, got %s", t.pos, text, t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept("||") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool(pos, "||", left, right); err != nil {
+			return nil, err
+		}
+		left = &logical{or: true, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept("&&") {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool(pos, "&&", left, right); err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	pos := p.peek().pos
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool(pos, "!", operand); err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op := t.text
+	switch {
+	case t.kind == tokOp && (op == "==" || op == "!=" || op == "<" || op == "<=" || op == ">" || op == ">="),
+		t.kind == tokIdent && op == "in":
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "==", "!=":
+		if left.kind() != right.kind() {
+			return nil, fmt.Errorf("column %d: cannot compare %s with %s", t.pos, left.kind(), right.kind())
+		}
+	case "in":
+		if left.kind() != kindString || right.kind() != kindString {
+			return nil, fmt.Errorf("column %d: in needs a string on both sides", t.pos)
+		}
+	default:
+		if left.kind() != kindNumber || right.kind() != kindNumber {
+			return nil, fmt.Errorf("column %d: %s needs numbers on both sides", t.pos, op)
+		}
+	}
+	return &comparison{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &literal{value: t.num, k: kindNumber}, nil
+	case tokString:
+		return &literal{value: t.text, k: kindString}, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &literal{value: t.text == "true", k: kindBool}, nil
+		case "phase":
+			return p.parsePhaseCall(t)
+		}
+		v, ok := variables[t.text]
+		if !ok {
+			return nil, fmt.Errorf("column %d: unknown variable %q", t.pos, t.text)
+		}
+		return &lookup{name: t.text, v: v}, nil
+	case tokOp:
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("column %d: unexpected %s", t.pos, t)
+}
+
+// parsePhaseCall parses phase("name") after its identifier
+func (p *parser) parsePhaseCall(t token) (node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arg := p.next()
+	if arg.kind != tokString {
+		return nil, fmt.Errorf("column %d: phase() takes a phase name in quotes", arg.pos)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	known := false
+	for _, name := range p.phases {
+		if name == arg.text {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("column %d: phase %q does not run before this one", t.pos, arg.text)
+	}
+	return &phaseResult{name: arg.text}, nil
+}
+
+func checkBool(pos int, op string, operands ...node) error {
+	for _, n := range operands {
+		if n.kind() != kindBool {
+			return fmt.Errorf("column %d: %s needs booleans, got a %s", pos, op, n.kind())
+		}
+	}
+	return nil
+}
+
+// Evaluation
+
+type node interface {
+	kind() kind
+	eval(env *Env) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+	k     kind
+}
+
+func (n *literal) kind() kind                         { return n.k }
+func (n *literal) eval(env *Env) (interface{}, error) { return n.value, nil }
+
+type lookup struct {
+	name string
+	v    variable
+}
+
+func (n *lookup) kind() kind { return n.v.kind }
+
+func (n *lookup) eval(env *Env) (interface{}, error) {
+	value, err := n.v.get(env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return value, nil
+}
+
+type phaseResult struct {
+	name string
+}
+
+func (n *phaseResult) kind() kind { return kindString }
+
+func (n *phaseResult) eval(env *Env) (interface{}, error) {
+	return env.Phases[n.name], nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) kind() kind { return kindBool }
+
+func (n *not) eval(env *Env) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+type logical struct {
+	or          bool
+	left, right node
+}
+
+func (n *logical) kind() kind { return kindBool }
+
+func (n *logical) eval(env *Env) (interface{}, error) {
+	// The result is decided by either side alone when it equals n.or
+	left, leftErr := n.left.eval(env)
+	if leftErr == nil && left.(bool) == n.or {
+		return n.or, nil
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if right.(bool) == n.or {
+		return n.or, nil
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	return !n.or, nil
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n *comparison) kind() kind { return kindBool }
+
+func (n *comparison) eval(env *Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "in":
+		for _, flag := range strings.Fields(right.(string)) {
+			if flag == left.(string) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	a, b := left.(float64), right.(float64)
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	default:
+		return a >= b, nil
+	}
+}
@@ -0,0 +1,104 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Status:   &ups.Status{Status: "OB DISCHRG LB", BatteryCharge: 12, Runtime: 240, Load: 35},
+		Trigger:  "critical",
+		Reason:   "battery critical",
+		Elapsed:  90 * time.Second,
+		Phases:   map[string]string{"stop-apps": PhaseFailed, "stop-db": PhaseCompleted},
+		Previous: PhaseCompleted,
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`battery.charge < 15 || trigger == "manual"`, true},
+		{`battery <= 10`, false},
+		{`battery.runtime < 5m && ups.load >= 35`, true},
+		{`"LB" in ups.status`, true},
+		{`"OL" in ups.status`, false},
+		{`!("OB" in ups.status)`, false},
+		{`session.elapsed > 1m && session.elapsed < 1.5m`, false},
+		{`session.elapsed >= 90s`, true},
+		{`phase("stop-apps") == "failed" && previous == "completed"`, true},
+		{`trigger != "manual" && reason == "battery critical"`, true},
+		{`true && (false || battery.charge == 12)`, true},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.expr, []string{"stop-apps", "stop-db"})
+		if err != nil {
+			t.Errorf("Parse(%s) failed: %v", tt.expr, err)
+			continue
+		}
+		got, err := expr.Eval(env)
+		if err != nil {
+			t.Errorf("Eval(%s) failed: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%s) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvalWithoutStatus(t *testing.T) {
+	env := Env{Trigger: "manual"}
+
+	expr, _ := Parse(`battery.charge < 15 || trigger == "manual"`, nil)
+	if got, err := expr.Eval(env); err != nil || !got {
+		t.Errorf("Expected the trigger to decide, got %v (%v)", got, err)
+	}
+
+	expr, _ = Parse(`trigger == "manual" && battery.charge < 15`, nil)
+	if _, err := expr.Eval(env); err == nil || !strings.Contains(err.Error(), "battery.charge") {
+		t.Errorf("Expected unknown status error, got %v", err)
+	}
+
+	expr, _ = Parse(`battery.charge < 15 && trigger == "critical"`, nil)
+	if got, err := expr.Eval(env); err != nil || got {
+		t.Errorf("Expected the trigger to decide, got %v (%v)", got, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "unexpected end"},
+		{`battery.charge`, "not a boolean"},
+		{`battery.level < 10`, "unknown variable"},
+		{`battery.charge < "10"`, "needs numbers"},
+		{`trigger == 1`, "cannot compare"},
+		{`battery.charge < 10 &&`, "unexpected end"},
+		{`(battery.charge < 10`, `expected ")"`},
+		{`battery.charge < 10 || trigger`, "needs booleans"},
+		{`session.elapsed > 5d`, "unknown unit"},
+		{`trigger == "manual`, "unterminated string"},
+		{`battery.charge < 10 ; true`, "unexpected character"},
+		{`phase("shutdown-vms") == "failed"`, "does not run before"},
+		{`phase(stop) == "failed"`, "phase name in quotes"},
+		{`1 in ups.status`, "string on both sides"},
+		{`battery.charge < 10 true`, "unexpected"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr, []string{"stop-apps"})
+		if err == nil {
+			t.Errorf("Expected error for %q", tt.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error %q does not mention %q", tt.expr, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Package control implements the local API used to query and drive a
+// running daemon from other proxmox-guardian processes
+package control
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/orchestrator"
+)
+
+// ErrNotRunning is returned by the client when no daemon listens on the
+// control socket
+var ErrNotRunning = errors.New("daemon not running")
+
+// Notification is a NUT NOTIFYCMD event forwarded to the daemon
+type Notification struct {
+	Type    string `json:"type"`          // NOTIFYTYPE: ONLINE, ONBATT, LOWBATT...
+	UPS     string `json:"ups,omitempty"` // UPSNAME, e.g. ups@localhost
+	Message string `json:"message,omitempty"`
+}
+
+// Status is a snapshot of what the daemon is doing
+type Status struct {
+	State          string      `json:"state"`
+	Plan           string      `json:"plan,omitempty"`
+	OnBatterySince *time.Time  `json:"on_battery_since,omitempty"`
+	UPS            []UPSStatus `json:"ups"`
+	// Session is the current or last shutdown session, if any
+	Session *orchestrator.State `json:"session,omitempty"`
+}
+
+// UPSStatus is the last status received from one UPS
+type UPSStatus struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Battery int       `json:"battery"`
+	Runtime int       `json:"runtime"`
+	Load    int       `json:"load"`
+	Updated time.Time `json:"updated"`
+	// Comm is COMMBAD or NOCOMM while the UPS does not answer
+	Comm string `json:"comm,omitempty"`
+	// Estimate summarizes the discharge trend while on battery
+	Estimate string `json:"estimate,omitempty"`
+}
+
+// ShutdownRequest asks the daemon to run a shutdown plan now
+type ShutdownRequest struct {
+	Plan   string `json:"plan"` // "graceful" or "emergency"
+	Reason string `json:"reason,omitempty"`
+}
+
+// Handler executes control requests within the daemon
+type Handler interface {
+	Notify(n Notification) error
+	Status() (*Status, error)
+	Shutdown(req ShutdownRequest) error
+	Abort() error
+	Recover() error
+	Reload() error
+}
+
+// errorResponse is the body returned when a request fails
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the control API on a Unix domain socket and optionally on
+// a localhost TCP address
+type Server struct {
+	path      string
+	handler   Handler
+	mux       http.Handler
+	servers   []*http.Server
+	listeners []net.Listener
+}
+
+// NewServer creates a new control server listening on the socket path
+func NewServer(path string, handler Handler) *Server {
+	s := &Server{
+		path:    path,
+		handler: handler,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", s.handleStatus)
+	mux.HandleFunc("/v1/notify", s.handleNotify)
+	mux.HandleFunc("/v1/shutdown", s.handleShutdown)
+	mux.HandleFunc("/v1/abort", s.handleCommand(handler.Abort))
+	mux.HandleFunc("/v1/recover", s.handleCommand(handler.Recover))
+	mux.HandleFunc("/v1/reload", s.handleCommand(handler.Reload))
+	s.mux = rejectCrossSite(mux)
+
+	return s
+}
+
+// Start listens on the socket and serves requests in the background. A
+// stale socket left by a crashed daemon is replaced.
+func (s *Server) Start() error {
+	if _, err := os.Stat(s.path); err == nil {
+		if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket %s already in use", s.path)
+		}
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("removing stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("listening on control socket: %w", err)
+	}
+	// Only root may drive the daemon
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("securing control socket: %w", err)
+	}
+
+	s.serve(listener, s.mux)
+	return nil
+}
+
+// StartTCP additionally serves the API on a loopback TCP address. Any local
+// user can reach it, so every request must carry token as a bearer token.
+func (s *Server) StartTCP(addr, token string) error {
+	if token == "" {
+		return fmt.Errorf("control address %s requires a token", addr)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid control address %s: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("control address %s must be a loopback address", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+
+	s.serve(listener, requireToken(token, s.mux))
+	return nil
+}
+
+func (s *Server) serve(listener net.Listener, handler http.Handler) {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.servers = append(s.servers, server)
+	s.listeners = append(s.listeners, listener)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+}
+
+// rejectCrossSite refuses requests a web page could forge: browsers send
+// an Origin header with them and cannot post JSON across sites without it
+func rejectCrossSite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+			return
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireToken refuses requests without the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Close stops the server and removes the socket
+func (s *Server) Close() error {
+	if len(s.listeners) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	for _, server := range s.servers {
+		if e := server.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	status, err := s.handler.Status()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) handleNotify(w http.ResponseWriter, r *http.Request) {
+	var n Notification
+	if !decodePost(w, r, &n) {
+		return
+	}
+	writeResult(w, s.handler.Notify(n))
+}
+
+func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	var req ShutdownRequest
+	if !decodePost(w, r, &req) {
+		return
+	}
+	writeResult(w, s.handler.Shutdown(req))
+}
+
+// handleCommand serves a command that takes no arguments
+func (s *Server) handleCommand(fn func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writeResult(w, fn())
+	}
+}
+
+// decodePost decodes the JSON body of a POST request, writing an error
+// response and returning false on failure
+func decodePost(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+// Client talks to a running daemon over its control socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a new control client for the socket path
+func NewClient(path string) *Client {
+	return &Client{
+		http: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Status returns what the daemon is currently doing
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/v1/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Notify forwards a NUT notification to the daemon
+func (c *Client) Notify(ctx context.Context, n Notification) error {
+	return c.do(ctx, http.MethodPost, "/v1/notify", n, nil)
+}
+
+// Shutdown asks the daemon to run a shutdown plan now
+func (c *Client) Shutdown(ctx context.Context, req ShutdownRequest) error {
+	return c.do(ctx, http.MethodPost, "/v1/shutdown", req, nil)
+}
+
+// Abort asks the daemon to stop the running shutdown sequence
+func (c *Client) Abort(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/abort", nil, nil)
+}
+
+// Recover asks the daemon to recover the actions of the last session
+func (c *Client) Recover(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/recover", nil, nil)
+}
+
+// Reload asks the daemon to reload its configuration file
+func (c *Client) Reload(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/reload", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshaling request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// The host is ignored: every request goes to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://guardian"+path, reader)
+	if err != nil {
+		return err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return fmt.Errorf("%w: %v", ErrNotRunning, err)
+		}
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+		}
+		return errors.New(e.Error)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+type mockHandler struct {
+	received []Notification
+	commands []string
+	err      error
+}
+
+func (h *mockHandler) Notify(n Notification) error {
+	h.received = append(h.received, n)
+	return h.err
+}
+
+func (h *mockHandler) Status() (*Status, error) {
+	return &Status{
+		State: "on_battery",
+		UPS:   []UPSStatus{{Name: "eaton@localhost", Status: "OB", Battery: 80}},
+	}, nil
+}
+
+func (h *mockHandler) Shutdown(req ShutdownRequest) error {
+	h.commands = append(h.commands, "shutdown:"+req.Plan)
+	return h.err
+}
+
+func (h *mockHandler) Abort() error {
+	h.commands = append(h.commands, "abort")
+	return h.err
+}
+
+func (h *mockHandler) Recover() error {
+	h.commands = append(h.commands, "recover")
+	return h.err
+}
+
+func (h *mockHandler) Reload() error {
+	h.commands = append(h.commands, "reload")
+	return h.err
+}
+
+func TestNotifyRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	handler := &mockHandler{}
+
+	server := NewServer(path, handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Close()
+
+	client := NewClient(path)
+	n := Notification{Type: "ONBATT", UPS: "eaton@localhost", Message: "UPS on battery"}
+	if err := client.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(handler.received) != 1 || handler.received[0] != n {
+		t.Errorf("Expected handler to receive %+v, got %+v", n, handler.received)
+	}
+
+	handler.err = errors.New("unknown UPS")
+	err := client.Notify(context.Background(), n)
+	if err == nil || err.Error() != "unknown UPS" {
+		t.Errorf("Expected handler error to be returned, got: %v", err)
+	}
+}
+
+func TestStatusAndCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	handler := &mockHandler{}
+
+	server := NewServer(path, handler)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Close()
+
+	ctx := context.Background()
+	client := NewClient(path)
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if status.State != "on_battery" || len(status.UPS) != 1 || status.UPS[0].Battery != 80 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	if err := client.Shutdown(ctx, ShutdownRequest{Plan: "graceful"}); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := client.Abort(ctx); err != nil {
+		t.Errorf("Abort failed: %v", err)
+	}
+	if err := client.Recover(ctx); err != nil {
+		t.Errorf("Recover failed: %v", err)
+	}
+	if err := client.Reload(ctx); err != nil {
+		t.Errorf("Reload failed: %v", err)
+	}
+
+	expected := []string{"shutdown:graceful", "abort", "recover", "reload"}
+	if len(handler.commands) != len(expected) {
+		t.Fatalf("Expected commands %v, got %v", expected, handler.commands)
+	}
+	for i := range expected {
+		if handler.commands[i] != expected[i] {
+			t.Errorf("Expected command %d to be %s, got %s", i, expected[i], handler.commands[i])
+		}
+	}
+}
+
+func TestStartTCPRequiresLoopback(t *testing.T) {
+	server := NewServer(filepath.Join(t.TempDir(), "control.sock"), &mockHandler{})
+	defer server.Close()
+
+	if err := server.StartTCP("0.0.0.0:0", "secret"); err == nil {
+		t.Error("Expected error for non-loopback address, got nil")
+	}
+	if err := server.StartTCP("127.0.0.1:0", ""); err == nil {
+		t.Error("Expected error without a token, got nil")
+	}
+	if err := server.StartTCP("127.0.0.1:0", "secret"); err != nil {
+		t.Errorf("Expected loopback address to be accepted, got: %v", err)
+	}
+}
+
+func TestTCPRequiresTokenAndJSON(t *testing.T) {
+	handler := &mockHandler{}
+	server := NewServer(filepath.Join(t.TempDir(), "control.sock"), handler)
+	defer server.Close()
+
+	if err := server.StartTCP("127.0.0.1:0", "secret"); err != nil {
+		t.Fatalf("Failed to start TCP listener: %v", err)
+	}
+	url := "http://" + server.listeners[len(server.listeners)-1].Addr().String() + "/v1/abort"
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"no token", map[string]string{"Content-Type": "application/json"}, http.StatusUnauthorized},
+		{"wrong token", map[string]string{"Authorization": "Bearer wrong", "Content-Type": "application/json"}, http.StatusUnauthorized},
+		{"no content type", map[string]string{"Authorization": "Bearer secret"}, http.StatusUnsupportedMediaType},
+		{"form", map[string]string{"Authorization": "Bearer secret", "Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"cross site", map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/json", "Origin": "http://evil.example"}, http.StatusForbidden},
+		{"valid", map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/json"}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, url, nil)
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, resp.StatusCode)
+		}
+	}
+
+	if len(handler.commands) != 1 || handler.commands[0] != "abort" {
+		t.Errorf("Expected one abort, got %v", handler.commands)
+	}
+}
+
+func TestClientNotRunning(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+
+	err := client.Notify(context.Background(), Notification{Type: "ONBATT"})
+	if !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got: %v", err)
+	}
+}
+
+func TestServerRefusesSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	first := NewServer(path, &mockHandler{})
+	if err := first.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer first.Close()
+
+	second := NewServer(path, &mockHandler{})
+	if err := second.Start(); err == nil {
+		second.Close()
+		t.Error("Expected error for socket already in use, got nil")
+	}
+}
@@ -0,0 +1,783 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/journal"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+// State represents the daemon power state
+type State string
+
+const (
+	StateOnline       State = "online"
+	StateOnBattery    State = "on_battery"
+	StateWarning      State = "warning"
+	StateCritical     State = "critical"
+	StateEmergency    State = "emergency"
+	StateShuttingDown State = "shutting_down"
+)
+
+// severity orders states so that battery events only ever escalate
+var severity = map[State]int{
+	StateOnline:       0,
+	StateOnBattery:    1,
+	StateWarning:      2,
+	StateCritical:     3,
+	StateEmergency:    4,
+	StateShuttingDown: 5,
+}
+
+// Monitor is the subset of ups.Monitor consumed by the daemon
+type Monitor interface {
+	Events() <-chan ups.Event
+	Status() <-chan *ups.Status
+}
+
+// Plan selects which configured shutdown plan to run
+type Plan string
+
+const (
+	PlanGraceful  Plan = "graceful"
+	PlanEmergency Plan = "emergency"
+)
+
+// Trigger is what caused a shutdown plan to run
+type Trigger string
+
+const (
+	TriggerCritical       Trigger = "critical"         // Critical battery, low runtime or LB
+	TriggerEmergency      Trigger = "emergency"        // Emergency battery level or FSD
+	TriggerOnBatteryTimer Trigger = "on_battery_timer" // On battery for too long
+	TriggerManual         Trigger = "manual"           // Shutdown requested through ctl
+)
+
+// ErrShutdownAborted is returned by a ShutdownFunc that stopped early
+// because abort was closed, after recovering what it had already done
+var ErrShutdownAborted = errors.New("shutdown aborted")
+
+// ErrDryRun is returned by a ShutdownFunc that only simulated the plan.
+// The daemon then keeps monitoring instead of exiting.
+var ErrDryRun = errors.New("shutdown simulated")
+
+// ErrStopped is returned by commands sent after Run has returned
+var ErrStopped = errors.New("daemon stopped")
+
+// ShutdownFunc runs the given shutdown plan for the trigger and its reason.
+// When abort is closed it should stop at the next safe point, recover and
+// return ErrShutdownAborted. Cancelling ctx preempts the plan entirely.
+type ShutdownFunc func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error
+
+// Config holds daemon behaviour settings
+type Config struct {
+	// AbortOnPowerReturn aborts an in-flight shutdown once mains power has
+	// been back for PowerStableDelay
+	AbortOnPowerReturn bool
+	PowerStableDelay   time.Duration
+
+	// OnBatteryMax triggers shutdown after this long on battery regardless
+	// of charge (0 = disabled). OnBatteryWarnings are notification marks
+	// within that period.
+	OnBatteryMax      time.Duration
+	OnBatteryWarnings []time.Duration
+
+	// EmergencyPlan runs PlanEmergency on emergency events instead of the
+	// graceful plan, preempting a graceful shutdown already in progress
+	EmergencyPlan bool
+}
+
+// normalize returns a copy of c with the warning marks sorted
+func (c Config) normalize() Config {
+	c.OnBatteryWarnings = append([]time.Duration(nil), c.OnBatteryWarnings...)
+	sort.Slice(c.OnBatteryWarnings, func(i, j int) bool {
+		return c.OnBatteryWarnings[i] < c.OnBatteryWarnings[j]
+	})
+	return c
+}
+
+// Logger interface for logging
+type Logger interface {
+	Info(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+	Debug(msg string, fields ...interface{})
+}
+
+// Notifier interface for sending notifications
+type Notifier interface {
+	Notify(event string, data map[string]interface{}) error
+}
+
+// Journal records outages, such as *journal.Journal
+type Journal interface {
+	Record(e journal.Entry) error
+}
+
+// outage is the power outage in progress, kept for the journal
+type outage struct {
+	ups       string
+	minCharge *int
+	shutdown  bool
+}
+
+// Daemon drives the power state machine from UPS monitor events
+type Daemon struct {
+	config   Config
+	monitor  Monitor
+	shutdown ShutdownFunc
+	logger   Logger
+	notifier Notifier
+	journal  Journal
+
+	mu             sync.RWMutex
+	state          State
+	onBatteryStart time.Time
+	statuses       map[string]*ups.Status
+	outage         *outage
+
+	// warningsSent counts the on-battery warning marks already notified
+	warningsSent int
+
+	// shutdownDone is nil until a shutdown has been triggered
+	shutdownDone   chan error
+	shutdownCancel context.CancelFunc
+	abortCh        chan struct{}
+	stableTimer    *time.Timer
+	plan           Plan
+	// triggeredFrom is the state the running shutdown was triggered from
+	triggeredFrom State
+
+	// preempting is set while a graceful shutdown is being cancelled in
+	// favour of the emergency plan
+	preempting    bool
+	preemptReason string
+
+	// commands are executed by Run so they never race with UPS events
+	commands chan command
+	stopped  chan struct{}
+}
+
+// command is a request executed on the Run goroutine
+type command struct {
+	fn   func() error
+	done chan error
+}
+
+// NewDaemon creates a new daemon
+func NewDaemon(cfg Config, monitor Monitor, shutdown ShutdownFunc, logger Logger, notifier Notifier) *Daemon {
+	return &Daemon{
+		config:   cfg.normalize(),
+		monitor:  monitor,
+		shutdown: shutdown,
+		logger:   logger,
+		notifier: notifier,
+		state:    StateOnline,
+		statuses: make(map[string]*ups.Status),
+		commands: make(chan command),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// SetJournal records outages in j. It must be set before Run.
+func (d *Daemon) SetJournal(j Journal) {
+	d.journal = j
+}
+
+// Run consumes monitor events until the context is cancelled or a
+// triggered shutdown sequence finishes
+func (d *Daemon) Run(ctx context.Context) error {
+	defer close(d.stopped)
+
+	events := d.monitor.Events()
+	statuses := d.monitor.Status()
+
+	// The on-battery grace timer is checked once per second. It always
+	// runs since a reload may enable it.
+	graceTicker := time.NewTicker(time.Second)
+	defer graceTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if d.shutdownDone == nil {
+				return nil
+			}
+			// Never abandon a running shutdown sequence half way
+			d.logger.Info("Stop requested during shutdown sequence, waiting for it to finish")
+			return <-d.shutdownDone
+		case status := <-statuses:
+			d.HandleStatus(status)
+		case event := <-events:
+			d.HandleEvent(event)
+		case cmd := <-d.commands:
+			cmd.done <- cmd.fn()
+		case now := <-graceTicker.C:
+			d.CheckOnBatteryTime(now)
+		case <-d.stableC():
+			d.abortShutdown()
+		case err := <-d.shutdownDone:
+			if d.preempting {
+				d.startShutdown(PlanEmergency, TriggerEmergency, d.preemptReason)
+				continue
+			}
+			if errors.Is(err, ErrDryRun) {
+				d.finishDryRun()
+				continue
+			}
+			if !errors.Is(err, ErrShutdownAborted) {
+				return err
+			}
+			d.resumeMonitoring()
+		}
+	}
+}
+
+// stableC returns the power-stable timer channel, or nil when not waiting
+func (d *Daemon) stableC() <-chan time.Time {
+	if d.stableTimer == nil {
+		return nil
+	}
+	return d.stableTimer.C
+}
+
+// HandleStatus records a status update from the monitor
+func (d *Daemon) HandleStatus(status *ups.Status) {
+	key := status.Source
+	if key == "" {
+		key = status.Name
+	}
+
+	d.mu.Lock()
+	d.statuses[key] = status
+	if o := d.outage; o != nil && status.IsOnBattery() && (o.minCharge == nil || status.BatteryCharge < *o.minCharge) {
+		charge := status.BatteryCharge
+		o.minCharge = &charge
+	}
+	d.mu.Unlock()
+
+	d.logger.Debug("UPS status",
+		"ups", key,
+		"status", status.Status,
+		"battery", status.BatteryCharge,
+		"runtime", status.Runtime,
+		"load", status.Load,
+	)
+}
+
+// HandleEvent applies a UPS event to the state machine
+func (d *Daemon) HandleEvent(event ups.Event) {
+	switch event.Type {
+	case ups.EventCommLost, ups.EventNoComm:
+		d.logger.Error("UPS communication lost", "reason", event.Message)
+		d.notify("ups_comm_lost", eventData(event))
+		return
+	case ups.EventCommRestored:
+		d.logger.Info("UPS communication restored", "reason", event.Message)
+		d.notify("ups_comm_restored", eventData(event))
+		return
+	}
+
+	current := d.State()
+
+	if current == StateShuttingDown {
+		d.handleEventDuringShutdown(event)
+		return
+	}
+
+	switch event.Type {
+	case ups.EventPowerLost:
+		d.escalate(StateOnBattery, event)
+	case ups.EventLowBattery:
+		d.escalate(StateWarning, event)
+	case ups.EventCriticalBattery:
+		if d.escalate(StateCritical, event) {
+			d.triggerShutdown(PlanGraceful, TriggerCritical, event.Message)
+		}
+	case ups.EventEmergency:
+		if d.escalate(StateEmergency, event) {
+			d.triggerShutdown(d.emergencyPlan(), TriggerEmergency, event.Message)
+		}
+	case ups.EventPowerRestored:
+		if current == StateOnline {
+			return
+		}
+		d.mu.Lock()
+		restored := d.endOutage(false)
+		outage := time.Since(d.onBatteryStart)
+		d.onBatteryStart = time.Time{}
+		d.state = StateOnline
+		d.warningsSent = 0
+		d.mu.Unlock()
+		d.record(restored)
+
+		d.logger.Info("Power restored", "outage", outage.Round(time.Second))
+		d.notify("power_restored", map[string]interface{}{
+			"outage": outage.Round(time.Second).String(),
+		})
+	}
+}
+
+// CheckOnBatteryTime sends on-battery warnings and triggers shutdown once
+// the outage has lasted longer than OnBatteryMax
+func (d *Daemon) CheckOnBatteryTime(now time.Time) {
+	d.mu.Lock()
+	if d.state != StateOnBattery && d.state != StateWarning {
+		d.mu.Unlock()
+		return
+	}
+	elapsed := now.Sub(d.onBatteryStart)
+
+	var marks []time.Duration
+	for d.warningsSent < len(d.config.OnBatteryWarnings) && elapsed >= d.config.OnBatteryWarnings[d.warningsSent] {
+		marks = append(marks, d.config.OnBatteryWarnings[d.warningsSent])
+		d.warningsSent++
+	}
+	d.mu.Unlock()
+
+	for _, mark := range marks {
+		d.logger.Info("On battery", "elapsed", mark, "limit", d.config.OnBatteryMax)
+		data := map[string]interface{}{
+			"elapsed": mark.String(),
+		}
+		if d.config.OnBatteryMax > 0 {
+			data["shutdown_in"] = (d.config.OnBatteryMax - mark).String()
+		}
+		d.notify("on_battery_warning", data)
+	}
+
+	if d.config.OnBatteryMax > 0 && elapsed >= d.config.OnBatteryMax {
+		d.triggerShutdown(PlanGraceful, TriggerOnBatteryTimer, fmt.Sprintf("on battery for %s (limit %s)",
+			elapsed.Round(time.Second), d.config.OnBatteryMax))
+	}
+}
+
+// escalate moves the state machine to target if it is more severe than the
+// current state, returning true when a transition happened
+func (d *Daemon) escalate(target State, event ups.Event) bool {
+	d.mu.Lock()
+	previous := d.state
+	if severity[target] <= severity[previous] {
+		d.mu.Unlock()
+		return false
+	}
+	d.state = target
+	var lost *journal.Entry
+	if previous == StateOnline {
+		d.onBatteryStart = event.Timestamp
+		if d.onBatteryStart.IsZero() {
+			d.onBatteryStart = time.Now()
+		}
+		lost = d.startOutage(event.Status)
+	}
+	d.mu.Unlock()
+	d.record(lost)
+
+	d.logger.Info("Power state changed",
+		"from", previous,
+		"to", target,
+		"reason", event.Message,
+	)
+
+	if previous == StateOnline {
+		d.notify("power_lost", eventData(event))
+	}
+	if target != StateOnBattery {
+		d.notify("battery_"+string(target), eventData(event))
+	}
+
+	return true
+}
+
+// emergencyPlan returns the plan to run on emergency events
+func (d *Daemon) emergencyPlan() Plan {
+	if d.config.EmergencyPlan {
+		return PlanEmergency
+	}
+	return PlanGraceful
+}
+
+func (d *Daemon) triggerShutdown(plan Plan, trigger Trigger, reason string) {
+	d.mu.Lock()
+	d.triggeredFrom = d.state
+	d.state = StateShuttingDown
+	if d.outage != nil {
+		d.outage.shutdown = true
+	}
+	d.mu.Unlock()
+
+	d.logger.Info("Shutdown triggered", "plan", plan, "trigger", trigger, "reason", reason)
+	d.startShutdown(plan, trigger, reason)
+}
+
+func (d *Daemon) startShutdown(plan Plan, trigger Trigger, reason string) {
+	done := make(chan error, 1)
+	abort := make(chan struct{})
+	// The sequence deliberately outlives the daemon context so a stop
+	// signal cannot interrupt it mid-way
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.shutdownDone = done
+	d.shutdownCancel = cancel
+	d.abortCh = abort
+	d.preempting = false
+
+	d.mu.Lock()
+	d.plan = plan
+	d.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		done <- d.shutdown(ctx, plan, trigger, reason, abort)
+	}()
+}
+
+// preemptShutdown cancels a graceful shutdown so the emergency plan can run
+// as soon as it has returned
+func (d *Daemon) preemptShutdown(reason string) {
+	if d.plan != PlanGraceful || !d.config.EmergencyPlan || d.preempting {
+		return
+	}
+
+	d.logger.Info("Emergency during graceful shutdown, switching to emergency plan", "reason", reason)
+	if d.stableTimer != nil {
+		d.stableTimer.Stop()
+		d.stableTimer = nil
+	}
+
+	d.preempting = true
+	d.preemptReason = reason
+	d.shutdownCancel()
+}
+
+// handleEventDuringShutdown watches for mains power returning while the
+// shutdown sequence runs
+func (d *Daemon) handleEventDuringShutdown(event ups.Event) {
+	switch event.Type {
+	case ups.EventEmergency:
+		d.preemptShutdown(event.Message)
+	case ups.EventPowerRestored:
+		// The emergency plan is never aborted: the battery is nearly gone
+		if !d.config.AbortOnPowerReturn || d.abortCh == nil || d.stableTimer != nil ||
+			d.plan != PlanGraceful || d.preempting {
+			d.logger.Debug("Ignoring event during shutdown", "event", event.Type)
+			return
+		}
+		d.logger.Info("Power restored during shutdown, waiting for it to stabilize",
+			"delay", d.config.PowerStableDelay,
+		)
+		d.stableTimer = time.NewTimer(d.config.PowerStableDelay)
+	case ups.EventPowerLost:
+		if d.stableTimer != nil {
+			d.logger.Info("Power lost again, continuing shutdown")
+			d.stableTimer.Stop()
+			d.stableTimer = nil
+		}
+	default:
+		d.logger.Debug("Ignoring event during shutdown", "event", event.Type)
+	}
+}
+
+// abortShutdown signals the running sequence to stop
+func (d *Daemon) abortShutdown() {
+	d.stableTimer = nil
+	if d.abortCh == nil {
+		return
+	}
+
+	d.logger.Info("Power stable, aborting shutdown sequence")
+	close(d.abortCh)
+	d.abortCh = nil
+}
+
+// resumeMonitoring returns to normal monitoring after an aborted shutdown
+func (d *Daemon) resumeMonitoring() {
+	if d.stableTimer != nil {
+		d.stableTimer.Stop()
+		d.stableTimer = nil
+	}
+	d.shutdownDone = nil
+	d.shutdownCancel = nil
+	d.abortCh = nil
+
+	d.mu.Lock()
+	d.plan = ""
+	entries := []*journal.Entry{d.endOutage(true)}
+	outage := time.Since(d.onBatteryStart)
+	d.state = StateOnline
+	d.onBatteryStart = time.Time{}
+	d.warningsSent = 0
+	// Power may have dropped again while recovery was running
+	for _, status := range d.statuses {
+		if status.IsOnBattery() {
+			d.state = StateOnBattery
+			d.onBatteryStart = time.Now()
+			entries = append(entries, d.startOutage(status))
+			break
+		}
+	}
+	state := d.state
+	d.mu.Unlock()
+
+	for _, e := range entries {
+		d.record(e)
+	}
+	d.logger.Info("Shutdown aborted, resuming monitoring", "state", state)
+	d.notify("power_restored", map[string]interface{}{
+		"outage":  outage.Round(time.Second).String(),
+		"aborted": true,
+	})
+}
+
+// finishDryRun returns to monitoring after a simulated shutdown. While the
+// host stays on battery it is still considered at risk, so the same trigger
+// does not fire again until power is restored.
+func (d *Daemon) finishDryRun() {
+	if d.stableTimer != nil {
+		d.stableTimer.Stop()
+		d.stableTimer = nil
+	}
+	d.shutdownDone = nil
+	d.shutdownCancel = nil
+	d.abortCh = nil
+
+	d.mu.Lock()
+	d.plan = ""
+	onBattery := false
+	for _, status := range d.statuses {
+		if status.IsOnBattery() {
+			onBattery = true
+			break
+		}
+	}
+	var restored *journal.Entry
+	if onBattery {
+		d.state = d.triggeredFrom
+		if severity[d.state] < severity[StateCritical] {
+			d.state = StateCritical
+		}
+	} else {
+		restored = d.endOutage(false)
+		d.state = StateOnline
+		d.onBatteryStart = time.Time{}
+		d.warningsSent = 0
+	}
+	state := d.state
+	d.mu.Unlock()
+	d.record(restored)
+
+	d.logger.Info("Dry run: shutdown simulated, resuming monitoring", "state", state)
+}
+
+// startOutage opens an outage and returns its journal entry. Caller must
+// hold d.mu.
+func (d *Daemon) startOutage(status *ups.Status) *journal.Entry {
+	o := &outage{}
+	entry := &journal.Entry{Type: journal.EntryPowerLost, Time: d.onBatteryStart}
+	if status != nil {
+		o.ups = status.Source
+		if o.ups == "" {
+			o.ups = status.Name
+		}
+		charge := status.BatteryCharge
+		o.minCharge = &charge
+		entry.UPS = o.ups
+		entry.Charge = &charge
+	}
+	d.outage = o
+	return entry
+}
+
+// endOutage closes the outage in progress and returns its journal entry,
+// or nil without one. aborted is set when power returned during a shutdown
+// that was then aborted. Caller must hold d.mu.
+func (d *Daemon) endOutage(aborted bool) *journal.Entry {
+	o := d.outage
+	if o == nil {
+		return nil
+	}
+	d.outage = nil
+	return &journal.Entry{
+		Type:      journal.EntryPowerRestored,
+		UPS:       o.ups,
+		Duration:  time.Since(d.onBatteryStart),
+		MinCharge: o.minCharge,
+		Shutdown:  o.shutdown,
+		Aborted:   aborted,
+	}
+}
+
+// record appends e to the journal, if any
+func (d *Daemon) record(e *journal.Entry) {
+	if d.journal == nil || e == nil {
+		return
+	}
+	if err := d.journal.Record(*e); err != nil {
+		d.logger.Error("Journal write failed", "type", e.Type, "error", err)
+	}
+}
+
+// do runs fn on the Run goroutine and returns its result
+func (d *Daemon) do(fn func() error) error {
+	cmd := command{fn: fn, done: make(chan error, 1)}
+	select {
+	case d.commands <- cmd:
+		return <-cmd.done
+	case <-d.stopped:
+		return ErrStopped
+	}
+}
+
+// Ping checks that the Run loop is still processing commands within
+// timeout, e.g. for a watchdog
+func (d *Daemon) Ping(timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- d.do(func() error { return nil }) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("daemon loop not responding")
+	}
+}
+
+// Shutdown manually triggers the given plan, as if a threshold was reached
+func (d *Daemon) Shutdown(plan Plan, reason string) error {
+	return d.do(func() error {
+		if d.State() == StateShuttingDown {
+			return errors.New("shutdown already in progress")
+		}
+		if plan == PlanEmergency && !d.config.EmergencyPlan {
+			return errors.New("no emergency plan configured")
+		}
+		if plan != PlanGraceful && plan != PlanEmergency {
+			return fmt.Errorf("unknown plan: %s", plan)
+		}
+
+		d.logger.Info("Manual shutdown requested", "plan", plan, "reason", reason)
+		d.triggerShutdown(plan, TriggerManual, reason)
+		return nil
+	})
+}
+
+// Abort stops the running shutdown sequence at the next safe point and
+// recovers the actions already completed
+func (d *Daemon) Abort() error {
+	return d.do(func() error {
+		if d.shutdownDone == nil {
+			return errors.New("no shutdown in progress")
+		}
+		if d.abortCh == nil || d.preempting {
+			return errors.New("shutdown can no longer be aborted")
+		}
+		if d.stableTimer != nil {
+			d.stableTimer.Stop()
+			d.stableTimer = nil
+		}
+
+		d.logger.Info("Manual abort requested, stopping shutdown sequence")
+		close(d.abortCh)
+		d.abortCh = nil
+		return nil
+	})
+}
+
+// Reconfigure replaces the daemon settings and then calls apply, if not
+// nil, without any event being handled in between. It is refused while a
+// shutdown is in progress. The current outage keeps its on-battery timer;
+// warning marks it has already passed are not sent again.
+func (d *Daemon) Reconfigure(cfg Config, apply func()) error {
+	return d.do(func() error {
+		if d.State() == StateShuttingDown {
+			return errors.New("shutdown in progress")
+		}
+
+		cfg = cfg.normalize()
+
+		d.mu.Lock()
+		d.config = cfg
+		d.warningsSent = 0
+		if !d.onBatteryStart.IsZero() {
+			elapsed := time.Since(d.onBatteryStart)
+			for d.warningsSent < len(cfg.OnBatteryWarnings) && cfg.OnBatteryWarnings[d.warningsSent] <= elapsed {
+				d.warningsSent++
+			}
+		}
+		d.mu.Unlock()
+
+		if apply != nil {
+			apply()
+		}
+		return nil
+	})
+}
+
+// State returns the current power state
+func (d *Daemon) State() State {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.state
+}
+
+// Plan returns the shutdown plan currently running, or "" when idle
+func (d *Daemon) Plan() Plan {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.plan
+}
+
+// OnBatterySince returns when the current outage started, or the zero time
+func (d *Daemon) OnBatterySince() time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.onBatteryStart
+}
+
+// Statuses returns the most recent status of each monitored UPS
+func (d *Daemon) Statuses() map[string]*ups.Status {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	statuses := make(map[string]*ups.Status, len(d.statuses))
+	for k, v := range d.statuses {
+		statuses[k] = v
+	}
+	return statuses
+}
+
+func (d *Daemon) notify(event string, data map[string]interface{}) {
+	if d.notifier == nil {
+		return
+	}
+
+	if err := d.notifier.Notify(event, data); err != nil {
+		d.logger.Error("Notification failed", "event", event, "error", err)
+	}
+}
+
+func eventData(event ups.Event) map[string]interface{} {
+	data := map[string]interface{}{
+		"message": event.Message,
+	}
+	if event.Status != nil {
+		data["battery"] = fmt.Sprintf("%d%%", event.Status.BatteryCharge)
+		data["runtime"] = fmt.Sprintf("%ds", event.Status.Runtime)
+		data["ups"] = event.Status.Name
+		if e := event.Status.Estimate; e != nil {
+			data["discharge_rate"] = fmt.Sprintf("%.1f%%/min", e.Rate)
+			if e.Empty > 0 {
+				data["time_to_empty"] = e.Empty.Round(time.Second).String()
+			}
+			if name, eta, ok := e.Next(); ok && name != "empty" {
+				data["next_threshold"] = name
+				data["time_to_threshold"] = eta.Round(time.Second).String()
+			}
+		}
+	}
+	return data
+}
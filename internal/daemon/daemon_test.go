@@ -0,0 +1,687 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/journal"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+func TestStateTransitions(t *testing.T) {
+	tests := []struct {
+		name          string
+		events        []ups.EventType
+		expectState   State
+		expectTrigger bool
+	}{
+		{
+			name:        "power lost",
+			events:      []ups.EventType{ups.EventPowerLost},
+			expectState: StateOnBattery,
+		},
+		{
+			name:        "warning threshold",
+			events:      []ups.EventType{ups.EventPowerLost, ups.EventLowBattery},
+			expectState: StateWarning,
+		},
+		{
+			name:          "critical threshold triggers shutdown",
+			events:        []ups.EventType{ups.EventPowerLost, ups.EventLowBattery, ups.EventCriticalBattery},
+			expectState:   StateShuttingDown,
+			expectTrigger: true,
+		},
+		{
+			name:          "emergency without prior events triggers shutdown",
+			events:        []ups.EventType{ups.EventEmergency},
+			expectState:   StateShuttingDown,
+			expectTrigger: true,
+		},
+		{
+			name:        "power restored returns online",
+			events:      []ups.EventType{ups.EventPowerLost, ups.EventLowBattery, ups.EventPowerRestored},
+			expectState: StateOnline,
+		},
+		{
+			name:        "repeated warning does not escalate",
+			events:      []ups.EventType{ups.EventLowBattery, ups.EventLowBattery, ups.EventPowerLost},
+			expectState: StateWarning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := &recordingShutdown{}
+			d := NewDaemon(Config{}, newFakeMonitor(), sd.run, &testLogger{}, nil)
+
+			for _, ev := range tt.events {
+				d.HandleEvent(ups.Event{Type: ev, Timestamp: time.Now()})
+			}
+
+			if d.State() != tt.expectState {
+				t.Errorf("Expected state %s, got %s", tt.expectState, d.State())
+			}
+
+			triggered := d.shutdownDone != nil
+			if triggered != tt.expectTrigger {
+				t.Errorf("Expected shutdown triggered=%v, got %v", tt.expectTrigger, triggered)
+			}
+		})
+	}
+}
+
+func TestOnBatterySince(t *testing.T) {
+	d := NewDaemon(Config{}, newFakeMonitor(), (&recordingShutdown{}).run, &testLogger{}, nil)
+
+	if !d.OnBatterySince().IsZero() {
+		t.Error("Expected zero on-battery time while online")
+	}
+
+	start := time.Now().Add(-time.Minute)
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost, Timestamp: start})
+	d.HandleEvent(ups.Event{Type: ups.EventLowBattery, Timestamp: time.Now()})
+
+	if !d.OnBatterySince().Equal(start) {
+		t.Errorf("Expected on-battery since %v, got %v", start, d.OnBatterySince())
+	}
+
+	d.HandleEvent(ups.Event{Type: ups.EventPowerRestored, Timestamp: time.Now()})
+
+	if !d.OnBatterySince().IsZero() {
+		t.Error("Expected on-battery time to reset after power restored")
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	n := &recordingNotifier{}
+	d := NewDaemon(Config{}, newFakeMonitor(), (&recordingShutdown{}).run, &testLogger{}, n)
+
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost})
+	d.HandleEvent(ups.Event{Type: ups.EventLowBattery})
+	d.HandleEvent(ups.Event{Type: ups.EventLowBattery})
+	d.HandleEvent(ups.Event{Type: ups.EventPowerRestored})
+
+	expected := []string{"power_lost", "battery_warning", "power_restored"}
+	got := n.get()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected event %d to be %s, got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestEventDataEstimate(t *testing.T) {
+	data := eventData(ups.Event{Message: "Low battery: 45%", Status: &ups.Status{
+		Name: "eaton", BatteryCharge: 45, Runtime: 600,
+		Estimate: &ups.Estimate{Rate: 3, Empty: 15 * time.Minute, Warning: 0, Critical: 5 * time.Minute,
+			Emergency: -1, MinRuntime: -1},
+	}})
+
+	expected := map[string]string{
+		"discharge_rate":    "3.0%/min",
+		"time_to_empty":     "15m0s",
+		"next_threshold":    "critical",
+		"time_to_threshold": "5m0s",
+	}
+	for key, value := range expected {
+		if data[key] != value {
+			t.Errorf("Expected %s %q, got %v", key, value, data[key])
+		}
+	}
+}
+
+func TestRunExecutesShutdown(t *testing.T) {
+	mon := newFakeMonitor()
+	sd := &recordingShutdown{}
+	d := NewDaemon(Config{}, mon, sd.run, &testLogger{}, nil)
+
+	mon.events <- ups.Event{Type: ups.EventPowerLost}
+	mon.events <- ups.Event{Type: ups.EventCriticalBattery, Message: "Critical battery: 20%"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := d.Run(ctx); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	reasons := sd.get()
+	if len(reasons) != 1 {
+		t.Fatalf("Expected 1 shutdown, got %d", len(reasons))
+	}
+	if reasons[0] != "Critical battery: 20%" {
+		t.Errorf("Unexpected shutdown reason: %s", reasons[0])
+	}
+	if triggers := sd.getTriggers(); triggers[0] != TriggerCritical {
+		t.Errorf("Expected critical trigger, got %s", triggers[0])
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	d := NewDaemon(Config{}, newFakeMonitor(), (&recordingShutdown{}).run, &testLogger{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.Run(ctx); err != nil {
+		t.Errorf("Expected clean stop, got: %v", err)
+	}
+}
+
+func TestAbortOnPowerReturn(t *testing.T) {
+	mon := newFakeMonitor()
+	started := make(chan struct{})
+	shutdown := func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+		close(started)
+		<-abort
+		return ErrShutdownAborted
+	}
+
+	cfg := Config{AbortOnPowerReturn: true, PowerStableDelay: 10 * time.Millisecond}
+	d := NewDaemon(cfg, mon, shutdown, &testLogger{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Run(ctx) }()
+
+	mon.events <- ups.Event{Type: ups.EventPowerLost}
+	mon.events <- ups.Event{Type: ups.EventCriticalBattery}
+	<-started
+	mon.events <- ups.Event{Type: ups.EventPowerRestored}
+
+	deadline := time.After(5 * time.Second)
+	for d.State() != StateOnline {
+		select {
+		case err := <-errCh:
+			t.Fatalf("Run returned early: %v", err)
+		case <-deadline:
+			t.Fatalf("Expected state online after abort, got %s", d.State())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("Expected clean stop, got: %v", err)
+	}
+}
+
+func TestJournalRecordsOutage(t *testing.T) {
+	mon := newFakeMonitor()
+	runs := make(chan Plan, 1)
+	shutdown := func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+		runs <- plan
+		return ErrDryRun
+	}
+	j := &recordingJournal{}
+	d := NewDaemon(Config{}, mon, shutdown, &testLogger{}, nil)
+	d.SetJournal(j)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	onBattery := func(charge int) *ups.Status {
+		return &ups.Status{Name: "ups", Status: "OB", BatteryCharge: charge}
+	}
+	mon.events <- ups.Event{Type: ups.EventPowerLost, Status: onBattery(95)}
+	waitForState(t, d, StateOnBattery)
+	for _, charge := range []int{60, 70} {
+		mon.statuses <- onBattery(charge)
+		for d.Statuses()["ups"] == nil || d.Statuses()["ups"].BatteryCharge != charge {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	mon.events <- ups.Event{Type: ups.EventCriticalBattery, Status: onBattery(70)}
+	<-runs
+	waitForState(t, d, StateCritical)
+	mon.events <- ups.Event{Type: ups.EventPowerRestored}
+
+	deadline := time.After(5 * time.Second)
+	for len(j.get()) < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("Expected 2 journal entries, got %+v", j.get())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	entries := j.get()
+	lost, restored := entries[0], entries[1]
+	if lost.Type != journal.EntryPowerLost || lost.UPS != "ups" || lost.Charge == nil || *lost.Charge != 95 {
+		t.Errorf("Unexpected power lost entry %+v", lost)
+	}
+	if restored.Type != journal.EntryPowerRestored || restored.MinCharge == nil || *restored.MinCharge != 60 {
+		t.Errorf("Unexpected power restored entry %+v", restored)
+	}
+	if !restored.Shutdown || restored.Aborted {
+		t.Errorf("Expected completed shutdown in entry %+v", restored)
+	}
+}
+
+func TestManualShutdownAndAbort(t *testing.T) {
+	started := make(chan Plan, 1)
+	shutdown := func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+		started <- plan
+		<-abort
+		return ErrShutdownAborted
+	}
+
+	d := NewDaemon(Config{}, newFakeMonitor(), shutdown, &testLogger{}, nil)
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	_ = d.Run(stopped)
+	if err := d.Abort(); err != ErrStopped {
+		t.Errorf("Expected ErrStopped after Run returned, got: %v", err)
+	}
+
+	d = NewDaemon(Config{}, newFakeMonitor(), shutdown, &testLogger{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Run(ctx) }()
+
+	if err := d.Abort(); err == nil {
+		t.Error("Expected error aborting without a shutdown in progress")
+	}
+	if err := d.Shutdown(PlanEmergency, "test"); err == nil {
+		t.Error("Expected error for emergency plan without one configured")
+	}
+
+	if err := d.Shutdown(PlanGraceful, "maintenance"); err != nil {
+		t.Fatalf("Expected shutdown to start, got: %v", err)
+	}
+	if plan := <-started; plan != PlanGraceful {
+		t.Errorf("Expected plan %s, got %s", PlanGraceful, plan)
+	}
+	if err := d.Shutdown(PlanGraceful, "again"); err == nil {
+		t.Error("Expected error for shutdown already in progress")
+	}
+
+	if err := d.Abort(); err != nil {
+		t.Fatalf("Expected abort to succeed, got: %v", err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for d.State() != StateOnline {
+		select {
+		case err := <-errCh:
+			t.Fatalf("Run returned early: %v", err)
+		case <-deadline:
+			t.Fatalf("Expected state online after abort, got %s", d.State())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("Expected clean stop, got: %v", err)
+	}
+}
+
+func TestPowerFlapDuringShutdownDoesNotAbort(t *testing.T) {
+	cfg := Config{AbortOnPowerReturn: true, PowerStableDelay: time.Hour}
+	d := NewDaemon(cfg, newFakeMonitor(), (&recordingShutdown{}).run, &testLogger{}, nil)
+
+	d.HandleEvent(ups.Event{Type: ups.EventCriticalBattery})
+	d.HandleEvent(ups.Event{Type: ups.EventPowerRestored})
+	if d.stableTimer == nil {
+		t.Fatal("Expected power-stable timer to be running")
+	}
+
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost})
+	if d.stableTimer != nil {
+		t.Error("Expected power-stable timer to be cancelled when power is lost again")
+	}
+	if d.State() != StateShuttingDown {
+		t.Errorf("Expected state %s, got %s", StateShuttingDown, d.State())
+	}
+}
+
+func TestOnBatteryGraceTimer(t *testing.T) {
+	n := &recordingNotifier{}
+	sd := &recordingShutdown{}
+	cfg := Config{
+		OnBatteryMax:      5 * time.Minute,
+		OnBatteryWarnings: []time.Duration{3 * time.Minute, time.Minute},
+	}
+	d := NewDaemon(cfg, newFakeMonitor(), sd.run, &testLogger{}, n)
+
+	start := time.Now()
+	d.CheckOnBatteryTime(start.Add(time.Hour))
+	if d.State() != StateOnline {
+		t.Fatalf("Expected grace timer to be idle while online, got %s", d.State())
+	}
+
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost, Timestamp: start})
+
+	d.CheckOnBatteryTime(start.Add(30 * time.Second))
+	d.CheckOnBatteryTime(start.Add(90 * time.Second))
+	d.CheckOnBatteryTime(start.Add(100 * time.Second))
+	d.CheckOnBatteryTime(start.Add(4 * time.Minute))
+	if d.State() != StateOnBattery {
+		t.Fatalf("Expected state %s before limit, got %s", StateOnBattery, d.State())
+	}
+
+	d.CheckOnBatteryTime(start.Add(5 * time.Minute))
+	if d.State() != StateShuttingDown {
+		t.Errorf("Expected state %s after limit, got %s", StateShuttingDown, d.State())
+	}
+
+	expected := []string{"power_lost", "on_battery_warning", "on_battery_warning"}
+	got := n.get()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, got)
+	}
+}
+
+func TestOnBatteryGraceTimerResets(t *testing.T) {
+	n := &recordingNotifier{}
+	cfg := Config{
+		OnBatteryMax:      5 * time.Minute,
+		OnBatteryWarnings: []time.Duration{time.Minute},
+	}
+	d := NewDaemon(cfg, newFakeMonitor(), (&recordingShutdown{}).run, &testLogger{}, n)
+
+	start := time.Now()
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost, Timestamp: start})
+	d.CheckOnBatteryTime(start.Add(2 * time.Minute))
+	d.HandleEvent(ups.Event{Type: ups.EventPowerRestored})
+
+	// A new outage starts its own timer and warnings
+	restart := start.Add(3 * time.Minute)
+	d.HandleEvent(ups.Event{Type: ups.EventPowerLost, Timestamp: restart})
+	d.CheckOnBatteryTime(restart.Add(4 * time.Minute))
+	if d.State() != StateOnBattery {
+		t.Errorf("Expected state %s, got %s", StateOnBattery, d.State())
+	}
+
+	warnings := 0
+	for _, ev := range n.get() {
+		if ev == "on_battery_warning" {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("Expected 2 on-battery warnings, got %d", warnings)
+	}
+}
+
+func TestReconfigureKeepsOnBatteryTimer(t *testing.T) {
+	mon := newFakeMonitor()
+	n := &recordingNotifier{}
+	started := make(chan Plan, 1)
+	shutdown := func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+		started <- plan
+		<-abort
+		return ErrShutdownAborted
+	}
+	d := NewDaemon(Config{}, mon, shutdown, &testLogger{}, n)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Run(ctx) }()
+
+	start := time.Now().Add(-2 * time.Minute)
+	mon.events <- ups.Event{Type: ups.EventPowerLost, Timestamp: start}
+	for d.State() != StateOnBattery {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	applied := false
+	cfg := Config{
+		OnBatteryMax:      10 * time.Minute,
+		OnBatteryWarnings: []time.Duration{5 * time.Minute, time.Minute},
+	}
+	if err := d.Reconfigure(cfg, func() { applied = true }); err != nil {
+		t.Fatalf("Expected reconfigure to succeed, got: %v", err)
+	}
+	if !applied {
+		t.Error("Expected apply to be called")
+	}
+	if !d.OnBatterySince().Equal(start) {
+		t.Errorf("Expected on-battery timer to be kept, got %v", d.OnBatterySince())
+	}
+
+	// The 1m mark has already passed, only the 5m mark remains
+	d.CheckOnBatteryTime(start.Add(6 * time.Minute))
+	if got := n.get(); len(got) != 2 || got[1] != "on_battery_warning" {
+		t.Errorf("Expected a single on-battery warning after reconfigure, got %v", got)
+	}
+
+	if err := d.Shutdown(PlanGraceful, "test"); err != nil {
+		t.Fatalf("Expected shutdown to start, got: %v", err)
+	}
+	<-started
+	if err := d.Reconfigure(Config{}, func() { t.Error("Expected apply not to be called during shutdown") }); err == nil {
+		t.Error("Expected reconfigure to be refused during shutdown")
+	}
+
+	_ = d.Abort()
+	cancel()
+	<-errCh
+}
+
+func TestDryRunKeepsMonitoring(t *testing.T) {
+	mon := newFakeMonitor()
+	runs := make(chan Plan, 2)
+	shutdown := func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+		runs <- plan
+		return ErrDryRun
+	}
+	d := NewDaemon(Config{}, mon, shutdown, &testLogger{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Run(ctx) }()
+
+	onBattery := &ups.Status{Name: "ups", Status: "OB DISCHRG", BatteryCharge: 20}
+	mon.statuses <- onBattery
+	mon.events <- ups.Event{Type: ups.EventPowerLost, Status: onBattery}
+	mon.events <- ups.Event{Type: ups.EventCriticalBattery, Status: onBattery}
+	<-runs
+
+	waitForState(t, d, StateCritical)
+
+	// Repeated critical events do not simulate the shutdown again
+	mon.events <- ups.Event{Type: ups.EventCriticalBattery, Status: onBattery}
+	mon.events <- ups.Event{Type: ups.EventPowerRestored}
+	waitForState(t, d, StateOnline)
+
+	select {
+	case plan := <-runs:
+		t.Errorf("Expected a single simulated shutdown, got another %s", plan)
+	case err := <-errCh:
+		t.Fatalf("Expected daemon to keep running, got: %v", err)
+	default:
+	}
+}
+
+func waitForState(t *testing.T, d *Daemon, state State) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for d.State() != state {
+		select {
+		case <-deadline:
+			t.Fatalf("Expected state %s, got %s", state, d.State())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestEmergencyPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		expectPlan Plan
+	}{
+		{name: "emergency plan configured", config: Config{EmergencyPlan: true}, expectPlan: PlanEmergency},
+		{name: "no emergency plan", config: Config{}, expectPlan: PlanGraceful},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mon := newFakeMonitor()
+			sd := &recordingShutdown{}
+			d := NewDaemon(tt.config, mon, sd.run, &testLogger{}, nil)
+
+			mon.events <- ups.Event{Type: ups.EventEmergency, Message: "UPS forced shutdown (FSD)"}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := d.Run(ctx); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			plans := sd.getPlans()
+			if len(plans) != 1 || plans[0] != tt.expectPlan {
+				t.Errorf("Expected plans [%s], got %v", tt.expectPlan, plans)
+			}
+		})
+	}
+}
+
+func TestEmergencyPreemptsGracefulShutdown(t *testing.T) {
+	mon := newFakeMonitor()
+	started := make(chan struct{})
+
+	var mu sync.Mutex
+	var plans []Plan
+	shutdown := func(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+		mu.Lock()
+		plans = append(plans, plan)
+		mu.Unlock()
+
+		if plan == PlanGraceful {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	d := NewDaemon(Config{EmergencyPlan: true}, mon, shutdown, &testLogger{}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Run(ctx) }()
+
+	mon.events <- ups.Event{Type: ups.EventCriticalBattery}
+	<-started
+	mon.events <- ups.Event{Type: ups.EventEmergency}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(plans) != 2 || plans[0] != PlanGraceful || plans[1] != PlanEmergency {
+		t.Errorf("Expected [graceful emergency], got %v", plans)
+	}
+}
+
+// fakeMonitor feeds events to the daemon without a NUT server
+type fakeMonitor struct {
+	events   chan ups.Event
+	statuses chan *ups.Status
+}
+
+func newFakeMonitor() *fakeMonitor {
+	return &fakeMonitor{
+		events:   make(chan ups.Event, 10),
+		statuses: make(chan *ups.Status, 10),
+	}
+}
+
+func (m *fakeMonitor) Events() <-chan ups.Event   { return m.events }
+func (m *fakeMonitor) Status() <-chan *ups.Status { return m.statuses }
+
+type recordingShutdown struct {
+	mu       sync.Mutex
+	reasons  []string
+	plans    []Plan
+	triggers []Trigger
+}
+
+func (r *recordingShutdown) run(ctx context.Context, plan Plan, trigger Trigger, reason string, abort <-chan struct{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reasons = append(r.reasons, reason)
+	r.plans = append(r.plans, plan)
+	r.triggers = append(r.triggers, trigger)
+	return nil
+}
+
+func (r *recordingShutdown) getTriggers() []Trigger {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Trigger(nil), r.triggers...)
+}
+
+func (r *recordingShutdown) getPlans() []Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Plan(nil), r.plans...)
+}
+
+func (r *recordingShutdown) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.reasons...)
+}
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (n *recordingNotifier) Notify(event string, data map[string]interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+	return nil
+}
+
+func (n *recordingNotifier) get() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.events...)
+}
+
+type recordingJournal struct {
+	mu      sync.Mutex
+	entries []journal.Entry
+}
+
+func (j *recordingJournal) Record(e journal.Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, e)
+	return nil
+}
+
+func (j *recordingJournal) get() []journal.Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]journal.Entry(nil), j.entries...)
+}
+
+type testLogger struct{}
+
+func (l *testLogger) Info(msg string, fields ...interface{})  {}
+func (l *testLogger) Error(msg string, fields ...interface{}) {}
+func (l *testLogger) Debug(msg string, fields ...interface{}) {}
@@ -29,6 +29,34 @@ type Executor interface {
 	String() string
 }
 
+// DryRunner is implemented by executors that can resolve what they would
+// do, e.g. against the live API, without changing anything
+type DryRunner interface {
+	DryRun(ctx context.Context) (*ActionResult, error)
+}
+
+// DryRun describes what exec would do without executing it
+func DryRun(ctx context.Context, exec Executor) (*ActionResult, error) {
+	if d, ok := exec.(DryRunner); ok {
+		return d.DryRun(ctx)
+	}
+	return &ActionResult{
+		Success: true,
+		Output:  "would run " + exec.String(),
+	}, nil
+}
+
+// TargetRecorder is implemented by executors whose recovery reverses what
+// Execute acted on, such as the guests it shut down, rather than running a
+// command. The orchestrator keeps the targets in its state file so that a
+// session can be recovered after a restart.
+type TargetRecorder interface {
+	// Targets returns what the last Execute acted on
+	Targets() []string
+	// SetTargets restores the targets of an earlier Execute
+	SetTargets(targets []string)
+}
+
 // BaseAction contains common fields for all actions
 type BaseAction struct {
 	Type        string
@@ -2,6 +2,9 @@ package executor
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -145,6 +148,107 @@ func TestExecuteWithRetry(t *testing.T) {
 	}
 }
 
+func TestProxmoxGuestRecovery(t *testing.T) {
+	api := &fakeProxmoxAPI{guests: []Guest{
+		{Type: "vm", VMID: 100, Name: "web", Status: "running"},
+		{Type: "lxc", VMID: 200, Name: "dns", Status: "running"},
+		{Type: "vm", VMID: 300, Name: "spare", Status: "stopped"},
+	}}
+	exec := NewProxmoxGuestExecutor(GuestSelector{}, "shutdown", api)
+
+	if _, err := exec.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	targets := exec.Targets()
+	if len(targets) != 2 || targets[0] != "vm:100" || targets[1] != "lxc:200" {
+		t.Fatalf("Expected the running guests as targets, got %v", targets)
+	}
+
+	// Recovery may run in another process, from the recorded targets
+	recovery := NewProxmoxGuestExecutor(GuestSelector{}, "shutdown", api)
+	recovery.SetTargets(targets)
+	result, err := recovery.Recover(context.Background())
+	if err != nil || !result.Success {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if len(api.started) != 2 || api.started[0] != "lxc:200" || api.started[1] != "vm:100" {
+		t.Errorf("Expected guests started in reverse order, got %v", api.started)
+	}
+
+	api.startErr = errors.New("locked")
+	result, err = recovery.Recover(context.Background())
+	if err == nil || result.Success || !strings.Contains(result.Error, "locked") {
+		t.Errorf("Expected a failed recovery, got %+v (%v)", result, err)
+	}
+}
+
+func TestProxmoxGuestStop(t *testing.T) {
+	api := &fakeProxmoxAPI{guests: []Guest{
+		{Type: "vm", VMID: 100, Name: "web", Status: "running"},
+		{Type: "lxc", VMID: 200, Name: "dns", Status: "running"},
+	}}
+	exec := NewProxmoxGuestExecutor(GuestSelector{}, "stop", api)
+	exec.Parallel = true
+
+	result, err := exec.Execute(context.Background())
+	if err != nil || !result.Success {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(api.stopped) != 2 {
+		t.Errorf("Expected both guests stopped, got %v", api.stopped)
+	}
+	if len(api.shutdown) != 0 {
+		t.Errorf("Expected no graceful shutdown, got %v", api.shutdown)
+	}
+	if targets := exec.Targets(); len(targets) != 2 || targets[0] != "vm:100" {
+		t.Errorf("Expected targets in guest order, got %v", targets)
+	}
+
+	exec = NewProxmoxGuestExecutor(GuestSelector{}, "reboot", api)
+	if result, err := exec.Execute(context.Background()); err == nil || result.Success {
+		t.Error("Expected an unknown action to fail")
+	}
+}
+
+type fakeProxmoxAPI struct {
+	mu       sync.Mutex
+	guests   []Guest
+	shutdown []string
+	stopped  []string
+	started  []string
+	startErr error
+}
+
+func (f *fakeProxmoxAPI) ExecInGuest(ctx context.Context, guestType, guestID, command string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeProxmoxAPI) ShutdownGuest(ctx context.Context, guestType, guestID string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shutdown = append(f.shutdown, guestType+":"+guestID)
+	return nil
+}
+
+func (f *fakeProxmoxAPI) StopGuest(ctx context.Context, guestType, guestID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = append(f.stopped, guestType+":"+guestID)
+	return nil
+}
+
+func (f *fakeProxmoxAPI) StartGuest(ctx context.Context, guestType, guestID string) error {
+	if f.startErr != nil {
+		return f.startErr
+	}
+	f.started = append(f.started, guestType+":"+guestID)
+	return nil
+}
+
+func (f *fakeProxmoxAPI) GetGuestsBySelector(ctx context.Context, selector GuestSelector) ([]Guest, error) {
+	return f.guests, nil
+}
+
 // mockExecutor for testing
 type mockExecutor struct {
 	executeFunc func(ctx context.Context) (*ActionResult, error)
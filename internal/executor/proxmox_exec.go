@@ -18,6 +18,8 @@ type ProxmoxExecExecutor struct {
 type ProxmoxAPI interface {
 	ExecInGuest(ctx context.Context, guestType, guestID, command string) (string, error)
 	ShutdownGuest(ctx context.Context, guestType, guestID string, timeout time.Duration) error
+	StopGuest(ctx context.Context, guestType, guestID string) error
+	StartGuest(ctx context.Context, guestType, guestID string) error
 	GetGuestsBySelector(ctx context.Context, selector GuestSelector) ([]Guest, error)
 }
 
@@ -3,6 +3,8 @@ package executor
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,6 +14,13 @@ type ProxmoxGuestExecutor struct {
 	Selector   GuestSelector
 	Action     string // "shutdown" or "stop"
 	ProxmoxAPI ProxmoxAPI
+	// Parallel handles the matching guests concurrently, as in a
+	// parallel phase
+	Parallel bool
+
+	// stopped lists the guests that were running when Execute shut them
+	// down, as "type:vmid"
+	stopped []string
 }
 
 // NewProxmoxGuestExecutor creates a new Proxmox guest executor
@@ -27,7 +36,7 @@ func NewProxmoxGuestExecutor(selector GuestSelector, action string, api ProxmoxA
 	}
 }
 
-// Execute shuts down matching guests
+// Execute shuts down or, with the stop action, hard stops matching guests
 func (p *ProxmoxGuestExecutor) Execute(ctx context.Context) (*ActionResult, error) {
 	start := time.Now()
 
@@ -48,27 +57,46 @@ func (p *ProxmoxGuestExecutor) Execute(ctx context.Context) (*ActionResult, erro
 		}, nil
 	}
 
+	// Results are kept by index so the output follows the guest order
+	errs := make([]error, len(guests))
+	if p.Parallel {
+		var wg sync.WaitGroup
+		for i, guest := range guests {
+			wg.Add(1)
+			go func(i int, guest Guest) {
+				defer wg.Done()
+				errs[i] = p.actOn(ctx, guest)
+			}(i, guest)
+		}
+		wg.Wait()
+	} else {
+		for i, guest := range guests {
+			errs[i] = p.actOn(ctx, guest)
+		}
+	}
+
 	var shutdownErrors []string
 	var shutdownSuccess []string
 
-	for _, guest := range guests {
-		guestID := fmt.Sprintf("%d", guest.VMID)
-
-		err := p.ProxmoxAPI.ShutdownGuest(ctx, guest.Type, guestID, p.Timeout)
-		if err != nil {
-			shutdownErrors = append(shutdownErrors, fmt.Sprintf("%s:%s (%v)", guest.Type, guest.Name, err))
-		} else {
-			shutdownSuccess = append(shutdownSuccess, fmt.Sprintf("%s:%s", guest.Type, guest.Name))
+	p.stopped = nil
+	for i, guest := range guests {
+		if errs[i] != nil {
+			shutdownErrors = append(shutdownErrors, fmt.Sprintf("%s:%s (%v)", guest.Type, guest.Name, errs[i]))
+			continue
+		}
+		shutdownSuccess = append(shutdownSuccess, fmt.Sprintf("%s:%s", guest.Type, guest.Name))
+		if guest.Status == "running" {
+			p.stopped = append(p.stopped, fmt.Sprintf("%s:%d", guest.Type, guest.VMID))
 		}
 	}
 
-	output := fmt.Sprintf("shutdown %d guests: %v", len(shutdownSuccess), shutdownSuccess)
+	output := fmt.Sprintf("%s %d guests: %v", p.Action, len(shutdownSuccess), shutdownSuccess)
 
 	if len(shutdownErrors) > 0 {
 		return &ActionResult{
 			Success:  false,
 			Output:   output,
-			Error:    fmt.Sprintf("failed to shutdown: %v", shutdownErrors),
+			Error:    fmt.Sprintf("failed to %s: %v", p.Action, shutdownErrors),
 			Duration: time.Since(start),
 		}, fmt.Errorf("partial failure")
 	}
@@ -80,15 +108,96 @@ func (p *ProxmoxGuestExecutor) Execute(ctx context.Context) (*ActionResult, erro
 	}, nil
 }
 
-// Recover starts the guests that were stopped (for recovery mode)
+// actOn shuts down or stops a guest
+func (p *ProxmoxGuestExecutor) actOn(ctx context.Context, guest Guest) error {
+	guestID := fmt.Sprintf("%d", guest.VMID)
+	switch p.Action {
+	case "stop":
+		return p.ProxmoxAPI.StopGuest(ctx, guest.Type, guestID)
+	case "shutdown":
+		return p.ProxmoxAPI.ShutdownGuest(ctx, guest.Type, guestID, p.Timeout)
+	default:
+		return fmt.Errorf("unknown guest action %q", p.Action)
+	}
+}
+
+// DryRun resolves the selector and lists the guests that would be shut down
+func (p *ProxmoxGuestExecutor) DryRun(ctx context.Context) (*ActionResult, error) {
+	start := time.Now()
+
+	guests, err := p.ProxmoxAPI.GetGuestsBySelector(ctx, p.Selector)
+	if err != nil {
+		return &ActionResult{
+			Success:  false,
+			Error:    fmt.Sprintf("failed to get guests: %v", err),
+			Duration: time.Since(start),
+		}, err
+	}
+
+	var targets []string
+	for _, guest := range guests {
+		if guest.Status != "running" {
+			continue
+		}
+		targets = append(targets, fmt.Sprintf("%s:%d (%s)", guest.Type, guest.VMID, guest.Name))
+	}
+
+	return &ActionResult{
+		Success:  true,
+		Output:   fmt.Sprintf("would %s %d running guests: %v", p.Action, len(targets), targets),
+		Duration: time.Since(start),
+	}, nil
+}
+
+// Recover starts the guests that were running when Execute shut them
+// down, in the reverse order
 func (p *ProxmoxGuestExecutor) Recover(ctx context.Context) (*ActionResult, error) {
-	// TODO: Implement guest restart for recovery
+	start := time.Now()
+
+	var startErrors []string
+	var started []string
+	for i := len(p.stopped) - 1; i >= 0; i-- {
+		target := p.stopped[i]
+		guestType, guestID, ok := strings.Cut(target, ":")
+		if !ok {
+			startErrors = append(startErrors, fmt.Sprintf("%s (invalid guest)", target))
+			continue
+		}
+		if err := p.ProxmoxAPI.StartGuest(ctx, guestType, guestID); err != nil {
+			startErrors = append(startErrors, fmt.Sprintf("%s (%v)", target, err))
+		} else {
+			started = append(started, target)
+		}
+	}
+
+	output := fmt.Sprintf("started %d guests: %v", len(started), started)
+
+	if len(startErrors) > 0 {
+		return &ActionResult{
+			Success:  false,
+			Output:   output,
+			Error:    fmt.Sprintf("failed to start: %v", startErrors),
+			Duration: time.Since(start),
+		}, fmt.Errorf("partial failure")
+	}
+
 	return &ActionResult{
-		Success: true,
-		Output:  "guest recovery not yet implemented",
+		Success:  true,
+		Output:   output,
+		Duration: time.Since(start),
 	}, nil
 }
 
+// Targets returns the guests shut down by the last Execute
+func (p *ProxmoxGuestExecutor) Targets() []string {
+	return p.stopped
+}
+
+// SetTargets restores the guests shut down by an earlier Execute
+func (p *ProxmoxGuestExecutor) SetTargets(targets []string) {
+	p.stopped = targets
+}
+
 // Healthcheck verifies guests are stopped
 func (p *ProxmoxGuestExecutor) Healthcheck(ctx context.Context) (bool, error) {
 	guests, err := p.ProxmoxAPI.GetGuestsBySelector(ctx, p.Selector)
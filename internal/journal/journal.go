@@ -0,0 +1,207 @@
+// Package journal keeps an append-only record of power events and shutdown
+// sessions, one JSON object per line
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Entry types
+const (
+	EntryPowerLost     = "power_lost"
+	EntryPowerRestored = "power_restored"
+	EntrySession       = "session"
+)
+
+// Entry is a line of the journal
+type Entry struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	UPS  string    `json:"ups,omitempty"`
+
+	// Charge is the battery charge when power was lost
+	Charge *int `json:"charge,omitempty"`
+
+	// Outage summary, recorded when power is restored
+	Duration  time.Duration `json:"duration,omitempty"` // nanoseconds
+	MinCharge *int          `json:"min_charge,omitempty"`
+	Shutdown  bool          `json:"shutdown,omitempty"` // a shutdown was triggered
+	Aborted   bool          `json:"aborted,omitempty"`  // and then aborted
+
+	// Shutdown session, recorded when its phases are done
+	SessionID string `json:"session_id,omitempty"`
+	Plan      string `json:"plan,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Status    string `json:"status,omitempty"` // completed, failed, aborted or dry_run
+}
+
+// Journal appends entries to a file
+type Journal struct {
+	path string
+}
+
+// New returns a journal writing to path
+func New(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// Record appends an entry. Each entry is a single write to a file opened
+// in append mode, so concurrent writers do not interleave.
+func (j *Journal) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0750); err != nil {
+		return fmt.Errorf("creating journal directory: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+	return f.Close()
+}
+
+// Read returns the entries of the journal at path in time order. A missing
+// journal is empty; lines that cannot be parsed, such as one torn by a
+// power loss, are skipped.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Type == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, k int) bool {
+		return entries[i].Time.Before(entries[k].Time)
+	})
+	return entries, nil
+}
+
+// Outage is a period on battery rebuilt from the journal, with the
+// shutdown sessions run during it
+type Outage struct {
+	Start     time.Time
+	End       time.Time // Zero when no restore was recorded
+	UPS       string
+	MinCharge *int
+	Shutdown  bool
+	Aborted   bool
+	Sessions  []Entry
+}
+
+// Duration returns the length of the outage, or 0 when its end is unknown
+func (o Outage) Duration() time.Duration {
+	if o.End.IsZero() {
+		return 0
+	}
+	return o.End.Sub(o.Start)
+}
+
+// History groups journal entries into outages. Sessions run while on line
+// power, e.g. manual shutdowns, are returned separately.
+func History(entries []Entry) (outages []Outage, sessions []Entry) {
+	var current *Outage
+	closeCurrent := func() {
+		if current != nil {
+			outages = append(outages, *current)
+			current = nil
+		}
+	}
+
+	for _, e := range entries {
+		switch e.Type {
+		case EntryPowerLost:
+			// A previous outage without restore ended with the host
+			closeCurrent()
+			current = &Outage{Start: e.Time, UPS: e.UPS, MinCharge: e.Charge}
+
+		case EntryPowerRestored:
+			if current == nil {
+				// The loss predates the journal
+				current = &Outage{Start: e.Time.Add(-e.Duration), UPS: e.UPS}
+			}
+			current.End = e.Time
+			if e.MinCharge != nil {
+				current.MinCharge = e.MinCharge
+			}
+			current.Shutdown = current.Shutdown || e.Shutdown
+			current.Aborted = e.Aborted
+			closeCurrent()
+
+		case EntrySession:
+			if current == nil {
+				sessions = append(sessions, e)
+				continue
+			}
+			current.Shutdown = true
+			current.Sessions = append(current.Sessions, e)
+		}
+	}
+	closeCurrent()
+
+	return outages, sessions
+}
+
+// Summary aggregates a list of outages
+type Summary struct {
+	Outages   int
+	Total     time.Duration // On battery, over the outages with a known end
+	Longest   time.Duration
+	MinCharge *int
+	Shutdowns int
+}
+
+// Summarize aggregates outages
+func Summarize(outages []Outage) Summary {
+	var s Summary
+	for _, o := range outages {
+		s.Outages++
+		d := o.Duration()
+		s.Total += d
+		if d > s.Longest {
+			s.Longest = d
+		}
+		if o.MinCharge != nil && (s.MinCharge == nil || *o.MinCharge < *s.MinCharge) {
+			s.MinCharge = o.MinCharge
+		}
+		if o.Shutdown {
+			s.Shutdowns++
+		}
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestRecordAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "journal.jsonl")
+	j := New(path)
+
+	start := time.Date(2024, 3, 2, 14, 5, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: start, Type: EntryPowerLost, UPS: "ups@nas", Charge: intPtr(100)},
+		{Time: start.Add(12 * time.Minute), Type: EntryPowerRestored, UPS: "ups@nas",
+			Duration: 12 * time.Minute, MinCharge: intPtr(64)},
+	}
+	// Written out of order, read back in time order
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := j.Record(entries[i]); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	// A line torn by a power loss is skipped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.WriteString(`{"time":"2024-03-02T15:00:00Z","type":"pow`)
+	f.Close()
+
+	got, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(got))
+	}
+	if got[0].Type != EntryPowerLost || !got[0].Time.Equal(start) {
+		t.Errorf("Unexpected first entry %+v", got[0])
+	}
+	if got[1].Duration != 12*time.Minute || got[1].MinCharge == nil || *got[1].MinCharge != 64 {
+		t.Errorf("Unexpected restore entry %+v", got[1])
+	}
+
+	missing, err := Read(filepath.Join(t.TempDir(), "none.jsonl"))
+	if err != nil || missing != nil {
+		t.Errorf("Expected empty journal, got %v (%v)", missing, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t0 := time.Date(2024, 3, 2, 14, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return t0.Add(time.Duration(m) * time.Minute) }
+
+	entries := []Entry{
+		// Manual shutdown on line power
+		{Time: at(0), Type: EntrySession, SessionID: "s1", Plan: "graceful", Status: "completed"},
+		// Short outage
+		{Time: at(10), Type: EntryPowerLost, UPS: "ups", Charge: intPtr(100)},
+		{Time: at(15), Type: EntryPowerRestored, UPS: "ups", Duration: 5 * time.Minute, MinCharge: intPtr(90)},
+		// Outage with a shutdown, the host went down before power returned
+		{Time: at(20), Type: EntryPowerLost, UPS: "ups", Charge: intPtr(100)},
+		{Time: at(30), Type: EntrySession, SessionID: "s2", Plan: "graceful", Status: "completed"},
+		// Next boot: power lost again, then restored with an aborted shutdown
+		{Time: at(60), Type: EntryPowerLost, UPS: "ups", Charge: intPtr(80)},
+		{Time: at(70), Type: EntryPowerRestored, UPS: "ups", Duration: 10 * time.Minute,
+			MinCharge: intPtr(20), Shutdown: true, Aborted: true},
+	}
+
+	outages, sessions := History(entries)
+	if len(sessions) != 1 || sessions[0].SessionID != "s1" {
+		t.Errorf("Expected manual session s1, got %+v", sessions)
+	}
+	if len(outages) != 3 {
+		t.Fatalf("Expected 3 outages, got %d", len(outages))
+	}
+
+	if d := outages[0].Duration(); d != 5*time.Minute || *outages[0].MinCharge != 90 || outages[0].Shutdown {
+		t.Errorf("Unexpected first outage %+v", outages[0])
+	}
+	if !outages[1].End.IsZero() || !outages[1].Shutdown || len(outages[1].Sessions) != 1 {
+		t.Errorf("Expected open outage with session s2, got %+v", outages[1])
+	}
+	if !outages[2].Shutdown || !outages[2].Aborted || *outages[2].MinCharge != 20 {
+		t.Errorf("Unexpected aborted outage %+v", outages[2])
+	}
+
+	sum := Summarize(outages)
+	if sum.Outages != 3 || sum.Total != 15*time.Minute || sum.Longest != 10*time.Minute ||
+		*sum.MinCharge != 20 || sum.Shutdowns != 2 {
+		t.Errorf("Unexpected summary %+v", sum)
+	}
+}
@@ -0,0 +1,36 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "guardian.lock")
+
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	_, err = Acquire(path)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected LockedError, got: %v", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Errorf("Expected owner PID %d, got %d", os.Getpid(), locked.PID)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	l, err = Acquire(path)
+	if err != nil {
+		t.Fatalf("Expected lock to be free after release, got: %v", err)
+	}
+	_ = l.Release()
+}
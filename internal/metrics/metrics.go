@@ -0,0 +1,247 @@
+// Package metrics implements a minimal Prometheus registry exposed in the
+// text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metric families and renders them for Prometheus
+type Registry struct {
+	mu         sync.Mutex
+	families   []*family
+	collectors []func()
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// family is a named metric with one sample per label combination
+type family struct {
+	name    string
+	help    string
+	kind    string // "counter", "gauge" or "summary"
+	labels  []string
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+	count       uint64 // Summaries only
+}
+
+func (r *Registry) register(name, help, kind string, labels []string) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+
+	return f
+}
+
+// OnCollect registers fn to run before every scrape, typically to refresh
+// gauges from the current state
+func (r *Registry) OnCollect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+// get returns the sample for the label values, creating it if needed.
+// Caller must hold f.mu.
+func (f *family) get(labelValues []string) *sample {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		f.samples[key] = s
+	}
+	return s
+}
+
+// reset removes every sample
+func (f *family) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.samples = make(map[string]*sample)
+}
+
+// CounterVec is a monotonically increasing value per label combination
+type CounterVec struct{ f *family }
+
+// NewCounterVec registers a new counter
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labels)}
+}
+
+// Inc increments the counter by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter by v, which must not be negative
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labelValues).value += v
+}
+
+// GaugeVec is a value that can go up and down per label combination
+type GaugeVec struct{ f *family }
+
+// NewGaugeVec registers a new gauge
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge value
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(labelValues).value = v
+}
+
+// Reset removes all label combinations, e.g. before refreshing them
+func (g *GaugeVec) Reset() {
+	g.f.reset()
+}
+
+// SummaryVec tracks the count and sum of observations, such as durations
+type SummaryVec struct{ f *family }
+
+// NewSummaryVec registers a new summary without quantiles
+func (r *Registry) NewSummaryVec(name, help string, labels ...string) *SummaryVec {
+	return &SummaryVec{r.register(name, help, "summary", labels)}
+}
+
+// Observe records one observation
+func (s *SummaryVec) Observe(v float64, labelValues ...string) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	smp := s.f.get(labelValues)
+	smp.value += v
+	smp.count++
+}
+
+// WriteTo renders every metric in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.samples) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.samples))
+	for k := range f.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.samples[k]
+		labels := f.formatLabels(s.labelValues)
+		if f.kind == "summary" {
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatValue(s.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, s.count)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatValue(s.value))
+	}
+}
+
+func (f *family) formatLabels(values []string) string {
+	if len(f.labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(f.labels))
+	for i, name := range f.labels {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Handler serves the registry over HTTP
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
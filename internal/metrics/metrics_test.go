@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	battery := r.NewGaugeVec("guardian_ups_battery_charge_percent", "Battery charge", "ups")
+	errors := r.NewCounterVec("guardian_nut_poll_errors_total", "NUT poll errors", "ups")
+	duration := r.NewSummaryVec("guardian_action_duration_seconds", "Action duration", "phase", "action")
+	unused := r.NewGaugeVec("guardian_unused", "Never set")
+	_ = unused
+
+	r.OnCollect(func() {
+		battery.Reset()
+		battery.Set(87, `eaton@"local"`)
+	})
+
+	errors.Inc("eaton@localhost")
+	errors.Add(2, "eaton@localhost")
+	errors.Add(-1, "eaton@localhost") // Ignored
+	duration.Observe(1.5, "stop-vms", "shutdown vm")
+	duration.Observe(0.5, "stop-vms", "shutdown vm")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE guardian_ups_battery_charge_percent gauge\n",
+		`guardian_ups_battery_charge_percent{ups="eaton@\"local\""} 87` + "\n",
+		"# TYPE guardian_nut_poll_errors_total counter\n",
+		`guardian_nut_poll_errors_total{ups="eaton@localhost"} 3` + "\n",
+		"# TYPE guardian_action_duration_seconds summary\n",
+		`guardian_action_duration_seconds_sum{phase="stop-vms",action="shutdown vm"} 2` + "\n",
+		`guardian_action_duration_seconds_count{phase="stop-vms",action="shutdown vm"} 2` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected output to contain %q, got:\n%s", e, out)
+		}
+	}
+
+	if strings.Contains(out, "guardian_unused") {
+		t.Errorf("Expected metrics without samples to be omitted, got:\n%s", out)
+	}
+}
@@ -144,15 +144,26 @@ func (n *Notifier) buildDiscordPayload(event string, data map[string]interface{}
 		color int
 		title string
 	}{
-		"power_lost":        {"⚡", 0xFF0000, "Power Lost"},
-		"power_restored":    {"✅", 0x00FF00, "Power Restored"},
-		"shutdown_start":    {"🚀", 0xFFA500, "Shutdown Starting"},
-		"shutdown_complete": {"🛑", 0x00FF00, "Shutdown Complete"},
-		"phase_start":       {"📋", 0x3498DB, "Phase Started"},
-		"phase_complete":    {"✓", 0x2ECC71, "Phase Completed"},
-		"recovery_start":    {"🔄", 0x9B59B6, "Recovery Starting"},
-		"recovery_complete": {"✅", 0x00FF00, "Recovery Complete"},
-		"error":             {"❌", 0xFF0000, "Error"},
+		"power_lost":          {"⚡", 0xFF0000, "Power Lost"},
+		"power_restored":      {"✅", 0x00FF00, "Power Restored"},
+		"battery_warning":     {"🔋", 0xF1C40F, "Battery Warning"},
+		"on_battery_warning":  {"⏳", 0xF1C40F, "Still On Battery"},
+		"battery_critical":    {"🪫", 0xFF4500, "Battery Critical"},
+		"battery_emergency":   {"🚨", 0xFF0000, "Battery Emergency"},
+		"shutdown_start":      {"🚀", 0xFFA500, "Shutdown Starting"},
+		"shutdown_complete":   {"🛑", 0x00FF00, "Shutdown Complete"},
+		"shutdown_aborted":    {"↩️", 0x3498DB, "Shutdown Aborted"},
+		"shutdown_failed":     {"❌", 0xFF0000, "Shutdown Failed"},
+		"phase_start":         {"📋", 0x3498DB, "Phase Started"},
+		"phase_complete":      {"✓", 0x2ECC71, "Phase Completed"},
+		"phase_skipped":       {"⏭️", 0x95A5A6, "Phase Skipped"},
+		"recovery_start":      {"🔄", 0x9B59B6, "Recovery Starting"},
+		"recovery_complete":   {"✅", 0x00FF00, "Recovery Complete"},
+		"ups_comm_lost":       {"📡", 0xFF4500, "UPS Communication Lost"},
+		"ups_comm_restored":   {"📡", 0x2ECC71, "UPS Communication Restored"},
+		"ups_replace_battery": {"🔧", 0xF1C40F, "UPS Battery Needs Replacement"},
+		"ups_shutdown":        {"🔴", 0xFF0000, "UPS Shutdown"},
+		"error":               {"❌", 0xFF0000, "Error"},
 	}
 
 	config, ok := eventConfig[event]
@@ -0,0 +1,450 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/condition"
+)
+
+// ActionRef identifies an action by the index of its phase and its index
+// within the phase
+type ActionRef struct {
+	Phase  int
+	Action int
+}
+
+// Graph is the dependency graph of the actions of a plan. An action with
+// depends_on waits only for the actions it lists, even in earlier phases,
+// so it can start before its own phase would. Other actions keep the phase
+// order: they wait for every action of the previous phase and, in a
+// sequential phase, for the action before them.
+type Graph struct {
+	phases []Phase
+	deps   map[ActionRef][]ActionRef
+	// explicit is set when an action declares depends_on
+	explicit bool
+}
+
+// NewGraph builds the dependency graph of phases, checking that action ids
+// are unique, that depends_on names existing ids and that there is no cycle
+func NewGraph(phases []Phase) (*Graph, error) {
+	g := &Graph{phases: phases, deps: make(map[ActionRef][]ActionRef)}
+
+	ids := make(map[string]ActionRef)
+	for i, phase := range phases {
+		for j, action := range phase.Actions {
+			if action.ID == "" {
+				continue
+			}
+			if prev, ok := ids[action.ID]; ok {
+				return nil, fmt.Errorf("action id %q is used by %s and %s", action.ID, g.Name(prev), g.Name(ActionRef{i, j}))
+			}
+			ids[action.ID] = ActionRef{i, j}
+		}
+	}
+
+	var previous []ActionRef
+	for i, phase := range phases {
+		var current []ActionRef
+		for j, action := range phase.Actions {
+			ref := ActionRef{i, j}
+			current = append(current, ref)
+
+			if len(action.DependsOn) > 0 {
+				g.explicit = true
+				seen := make(map[string]bool)
+				for _, id := range action.DependsOn {
+					dep, ok := ids[id]
+					if !ok {
+						return nil, fmt.Errorf("%s depends on unknown action %q", g.Name(ref), id)
+					}
+					if dep == ref {
+						return nil, fmt.Errorf("%s depends on itself", g.Name(ref))
+					}
+					if !seen[id] {
+						seen[id] = true
+						g.deps[ref] = append(g.deps[ref], dep)
+					}
+				}
+				continue
+			}
+
+			g.deps[ref] = append(g.deps[ref], previous...)
+			if !phase.Parallel && j > 0 {
+				g.deps[ref] = append(g.deps[ref], ActionRef{i, j - 1})
+			}
+		}
+		if len(current) > 0 {
+			previous = current
+		}
+	}
+
+	if _, err := g.Levels(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Explicit reports whether any action declares depends_on
+func (g *Graph) Explicit() bool {
+	return g.explicit
+}
+
+// Deps returns the actions ref waits for
+func (g *Graph) Deps(ref ActionRef) []ActionRef {
+	return g.deps[ref]
+}
+
+// Name returns the id of an action, or its position when it has none
+func (g *Graph) Name(ref ActionRef) string {
+	if id := g.phases[ref.Phase].Actions[ref.Action].ID; id != "" {
+		return id
+	}
+	return fmt.Sprintf("%s action %d", g.phases[ref.Phase].Name, ref.Action+1)
+}
+
+// refs returns every action in plan order
+func (g *Graph) refs() []ActionRef {
+	var refs []ActionRef
+	for i, phase := range g.phases {
+		for j := range phase.Actions {
+			refs = append(refs, ActionRef{i, j})
+		}
+	}
+	return refs
+}
+
+// Levels returns the actions grouped in the order they can start if every
+// action took the same time: the first level has no dependency, each next
+// one depends only on actions of the levels before it
+func (g *Graph) Levels() ([][]ActionRef, error) {
+	refs := g.refs()
+	level := make(map[ActionRef]int, len(refs))
+	pending := make(map[ActionRef]int, len(refs))
+	dependents := make(map[ActionRef][]ActionRef)
+	for _, ref := range refs {
+		pending[ref] = len(g.deps[ref])
+		for _, dep := range g.deps[ref] {
+			dependents[dep] = append(dependents[dep], ref)
+		}
+	}
+
+	var ready []ActionRef
+	for _, ref := range refs {
+		if pending[ref] == 0 {
+			ready = append(ready, ref)
+		}
+	}
+
+	var levels [][]ActionRef
+	done := 0
+	for len(ready) > 0 {
+		ref := ready[0]
+		ready = ready[1:]
+		done++
+
+		l := level[ref]
+		for len(levels) <= l {
+			levels = append(levels, nil)
+		}
+		levels[l] = append(levels[l], ref)
+
+		for _, next := range dependents[ref] {
+			if level[next] < l+1 {
+				level[next] = l + 1
+			}
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if done < len(refs) {
+		return nil, fmt.Errorf("dependency cycle: %s", g.cycle(refs, pending))
+	}
+
+	for _, l := range levels {
+		sort.Slice(l, func(a, b int) bool {
+			if l[a].Phase != l[b].Phase {
+				return l[a].Phase < l[b].Phase
+			}
+			return l[a].Action < l[b].Action
+		})
+	}
+	return levels, nil
+}
+
+// cycle describes a dependency cycle among the actions left pending by a
+// topological sort. Each of them waits for another one, so following the
+// dependencies from any of them loops.
+func (g *Graph) cycle(refs []ActionRef, pending map[ActionRef]int) string {
+	var start ActionRef
+	for _, ref := range refs {
+		if pending[ref] > 0 {
+			start = ref
+			break
+		}
+	}
+
+	visited := make(map[ActionRef]int)
+	var path []ActionRef
+	for ref := start; ; {
+		if i, ok := visited[ref]; ok {
+			path = append(path[i:], ref)
+			break
+		}
+		visited[ref] = len(path)
+		path = append(path, ref)
+		for _, dep := range g.deps[ref] {
+			if pending[dep] > 0 {
+				ref = dep
+				break
+			}
+		}
+	}
+
+	names := make([]string, len(path))
+	for i, ref := range path {
+		names[len(path)-1-i] = g.Name(ref)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// phaseRun tracks a phase whose actions run as a graph
+type phaseRun struct {
+	started bool
+	run     bool // The condition let the phase run
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	start   time.Time
+	left    int          // Actions not finished yet
+	stop    *ActionError // Set by an abort_phase action
+	failed  bool
+}
+
+// graphResult is the outcome of an action run by executeGraph
+type graphResult struct {
+	ref     ActionRef
+	start   time.Time
+	failure error
+}
+
+// executeGraph starts each action as soon as the actions it depends on are
+// finished, whatever their outcome, and runs the ready actions together. A
+// phase starts with its first action: its condition is evaluated and its
+// timeout begins then. Phase results seen by conditions are those of the
+// phases already finished.
+func (o *Orchestrator) executeGraph(ctx context.Context, reason string, g *Graph) error {
+	ctx, cancelAll := context.WithCancelCause(ctx)
+	defer cancelAll(nil)
+
+	runs := make([]phaseRun, len(o.phases))
+	for i, phase := range o.phases {
+		runs[i].left = len(phase.Actions)
+	}
+	results := make(map[string]string, len(o.phases))
+
+	refs := g.refs()
+	pending := make(map[ActionRef]int, len(refs))
+	dependents := make(map[ActionRef][]ActionRef)
+	var ready []ActionRef
+	for _, ref := range refs {
+		pending[ref] = len(g.deps[ref])
+		for _, dep := range g.deps[ref] {
+			dependents[dep] = append(dependents[dep], ref)
+		}
+		if pending[ref] == 0 {
+			ready = append(ready, ref)
+		}
+	}
+
+	finished := make(map[ActionRef]bool, len(refs))
+	finish := func(ref ActionRef) {
+		finished[ref] = true
+		for _, next := range dependents[ref] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+		p := &runs[ref.Phase]
+		p.left--
+		if p.left == 0 && p.run {
+			o.endGraphPhase(ref.Phase, p, results)
+		}
+	}
+
+	var stop *ActionError // Set by an abort_all action
+	done := make(chan graphResult)
+	running := 0
+
+	for {
+		for len(ready) > 0 && stop == nil && !o.aborted() {
+			ref := ready[0]
+			ready = ready[1:]
+			phase := o.phases[ref.Phase]
+			action := phase.Actions[ref.Action]
+
+			p := &runs[ref.Phase]
+			if !p.started {
+				o.startGraphPhase(ctx, ref.Phase, p, reason, results)
+			}
+			if !p.run {
+				finish(ref)
+				continue
+			}
+			if p.stop != nil {
+				o.recordAction(ref.Phase, phase.Name, ref.Action, action, p.stop.skipReason(), true)
+				p.failed = true
+				finish(ref)
+				continue
+			}
+
+			o.mu.Lock()
+			o.state.CurrentPhase = ref.Phase
+			o.state.CurrentAction = ref.Action
+			o.state.LastUpdated = time.Now()
+			_ = o.saveState()
+			o.mu.Unlock()
+
+			running++
+			go func(ref ActionRef, actionCtx context.Context) {
+				start := time.Now()
+				result, err := o.executeAction(actionCtx, ref.Phase, phase.Name, ref.Action, action)
+				done <- graphResult{ref: ref, start: start, failure: actionFailure(result, err)}
+			}(ref, p.ctx)
+		}
+
+		if running == 0 {
+			break
+		}
+		r := <-done
+		running--
+
+		phase := o.phases[r.ref.Phase]
+		action := phase.Actions[r.ref.Action]
+		p := &runs[r.ref.Phase]
+		o.observeAction(phase.Name, action, r.start, r.failure == nil)
+
+		failure := r.failure
+		if failure != nil {
+			p.failed = true
+		}
+
+		// Once the phase or the session was stopped, a failure is taken as
+		// the result of the cancellation rather than a new on_error trigger
+		var sibling *ActionError
+		if failure != nil && errors.As(context.Cause(p.ctx), &sibling) {
+			failure = fmt.Errorf("cancelled after %s failed: %w", sibling.Action, failure)
+			o.recordAction(r.ref.Phase, phase.Name, r.ref.Action, action, failure, false)
+			finish(r.ref)
+			continue
+		}
+		o.recordAction(r.ref.Phase, phase.Name, r.ref.Action, action, failure, false)
+
+		if failure != nil {
+			e := &ActionError{Phase: phase.Name, Action: action.Executor.String(), OnError: action.OnError, Err: failure}
+			switch action.OnError {
+			case "abort_phase":
+				p.stop = e
+				p.cancel(e)
+			case "abort_all":
+				stop = e
+				cancelAll(e)
+			default:
+				o.logger.Info("Action failed, continuing", "action", action.Executor.String())
+			}
+		}
+		finish(r.ref)
+	}
+
+	if stop != nil {
+		for _, ref := range refs {
+			if !finished[ref] {
+				o.recordAction(ref.Phase, o.phases[ref.Phase].Name, ref.Action,
+					o.phases[ref.Phase].Actions[ref.Action], stop.skipReason(), true)
+			}
+		}
+		return o.markFailed(stop)
+	}
+	if len(finished) < len(refs) {
+		return o.markAborted()
+	}
+	return o.markCompleted()
+}
+
+// startGraphPhase evaluates the condition of a phase about to start its
+// first action and prepares its context
+func (o *Orchestrator) startGraphPhase(ctx context.Context, index int, p *phaseRun, reason string, results map[string]string) {
+	phase := o.phases[index]
+	p.started = true
+	p.start = time.Now()
+
+	previous := ""
+	if index > 0 {
+		previous = results[o.phases[index-1].Name]
+	}
+	p.run = o.checkCondition(index, phase, reason, results, previous)
+	if !p.run {
+		o.logger.Info("Skipping phase, condition not met", "phase", phase.Name, "condition", phase.Condition)
+		o.notify("phase_skipped", map[string]interface{}{
+			"phase":     phase.Name,
+			"index":     index + 1,
+			"condition": phase.Condition,
+		})
+		results[phase.Name] = condition.PhaseSkipped
+		return
+	}
+
+	o.logger.Info("Starting phase", "phase", phase.Name, "index", index+1, "total", len(o.phases))
+	o.notify("phase_start", map[string]interface{}{
+		"phase": phase.Name,
+		"index": index + 1,
+	})
+
+	cancelTimeout := context.CancelFunc(func() {})
+	if phase.Timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, phase.Timeout)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	p.ctx = ctx
+	p.cancel = func(cause error) {
+		cancel(cause)
+		cancelTimeout()
+	}
+}
+
+// endGraphPhase reports a phase whose actions are all finished
+func (o *Orchestrator) endGraphPhase(index int, p *phaseRun, results map[string]string) {
+	phase := o.phases[index]
+	p.cancel(nil)
+
+	var err error
+	if p.stop != nil {
+		err = p.stop
+		o.logger.Error("Phase failed", "phase", phase.Name, "error", err)
+	}
+	if o.observer != nil {
+		o.observer.PhaseDone(phase.Name, time.Since(p.start), err)
+	}
+
+	results[phase.Name] = condition.PhaseCompleted
+	if p.failed {
+		results[phase.Name] = condition.PhaseFailed
+	}
+
+	data := map[string]interface{}{
+		"phase": phase.Name,
+		"index": index + 1,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	o.notify("phase_complete", data)
+}
@@ -0,0 +1,157 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewGraphErrors(t *testing.T) {
+	action := func(id string, deps ...string) Action {
+		return Action{Type: "mock", ID: id, DependsOn: deps}
+	}
+
+	tests := []struct {
+		name   string
+		phases []Phase
+		want   string
+	}{
+		{"duplicate id", []Phase{
+			{Name: "one", Actions: []Action{action("a"), action("a")}},
+		}, `action id "a" is used by a and a`},
+		{"unknown id", []Phase{
+			{Name: "one", Actions: []Action{action("a", "missing")}},
+		}, `a depends on unknown action "missing"`},
+		{"self", []Phase{
+			{Name: "one", Actions: []Action{action("a", "a")}},
+		}, "a depends on itself"},
+		{"cycle", []Phase{
+			{Name: "one", Actions: []Action{action("a", "b")}},
+			{Name: "two", Actions: []Action{action("b", "a")}},
+		}, "dependency cycle: a -> b -> a"},
+		{"implicit cycle", []Phase{
+			{Name: "one", Actions: []Action{action("a", "c")}},
+			{Name: "two", Actions: []Action{action("b"), action("c")}},
+		}, "dependency cycle"},
+	}
+	for _, tt := range tests {
+		_, err := NewGraph(tt.phases)
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q does not mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGraphLevels(t *testing.T) {
+	phases := []Phase{
+		{Name: "one", Actions: []Action{{ID: "a"}, {}}},
+		{Name: "two", Parallel: true, Actions: []Action{{ID: "c", DependsOn: []string{"a"}}, {}}},
+	}
+
+	g, err := NewGraph(phases)
+	if err != nil {
+		t.Fatalf("NewGraph failed: %v", err)
+	}
+	if !g.Explicit() {
+		t.Error("Expected the graph to have explicit dependencies")
+	}
+
+	levels, err := g.Levels()
+	if err != nil {
+		t.Fatalf("Levels failed: %v", err)
+	}
+	var got []string
+	for _, level := range levels {
+		var names []string
+		for _, ref := range level {
+			names = append(names, g.Name(ref))
+		}
+		got = append(got, strings.Join(names, ", "))
+	}
+	want := []string{"a", "one action 2, c", "two action 2"}
+	if strings.Join(got, " | ") != strings.Join(want, " | ") {
+		t.Errorf("Expected levels %v, got %v", want, got)
+	}
+}
+
+func TestExecuteGraph(t *testing.T) {
+	rec := &recorder{}
+	started := make(chan struct{})
+
+	first := rec.action("a", "")
+	first.ID = "a"
+
+	// Phase one only ends once c, in phase two, has started
+	wait := rec.action("b", "")
+	wait.Executor.(*mockExecutor).onExecute = func() {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Error("Expected c to start before phase one ends")
+		}
+	}
+
+	early := rec.action("c", "")
+	early.DependsOn = []string{"a"}
+	early.Executor.(*mockExecutor).onExecute = func() { close(started) }
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{first, wait}},
+		{Name: "two", Actions: []Action{early, rec.action("d", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	got := rec.executed()
+	if len(got) != 4 || got[0] != "a" || got[3] != "d" {
+		t.Errorf("Expected a first and d last, got %v", got)
+	}
+	if state := orch.GetState(); state.Status != "completed" || len(state.CompletedActions) != 4 {
+		t.Errorf("Expected 4 completed actions, got %s with %d", state.Status, len(state.CompletedActions))
+	}
+}
+
+func TestExecuteGraphAbortAll(t *testing.T) {
+	rec := &recorder{}
+
+	failing := rec.action("a", "")
+	failing.ID = "a"
+	failing.Executor.(*mockExecutor).fail = true
+	failing.OnError = "abort_all"
+
+	after := rec.action("c", "")
+	after.DependsOn = []string{"a"}
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{failing, rec.action("b", "")}},
+		{Name: "two", Actions: []Action{after}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	err := orch.Execute(context.Background(), "test")
+	var stop *ActionError
+	if !errors.As(err, &stop) || stop.Action != "Mock: a" {
+		t.Fatalf("Expected ActionError for action a, got %v", err)
+	}
+
+	if got := rec.executed(); len(got) != 1 {
+		t.Errorf("Expected action a only, got %v", got)
+	}
+	state := orch.GetState()
+	if state.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", state.Status)
+	}
+	if skipped := skippedActions(state); len(skipped) != 2 {
+		t.Errorf("Expected actions b and c to be skipped, got %v", skipped)
+	}
+}
@@ -3,24 +3,47 @@ package orchestrator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/condition"
 	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
 )
 
 // State represents the current shutdown state
 type State struct {
 	SessionID        string            `json:"session_id"`
 	StartedAt        time.Time         `json:"started_at"`
-	Status           string            `json:"status"` // "idle", "in_progress", "completed", "failed", "recovering"
+	Status           string            `json:"status"` // "idle", "in_progress", "completed", "aborted", "failed", "recovering"
 	CurrentPhase     int               `json:"current_phase"`
 	CurrentAction    int               `json:"current_action"`
 	CompletedActions []CompletedAction `json:"completed_actions"`
 	TriggerEvent     string            `json:"trigger_event"`
 	LastUpdated      time.Time         `json:"last_updated"`
+	// Reason tells why a session failed or was aborted
+	Reason string `json:"reason,omitempty"`
+	// DryRun marks a simulated session whose actions were never executed
+	DryRun bool `json:"dry_run,omitempty"`
+	// Conditions records the phase conditions evaluated in the session
+	Conditions []ConditionResult `json:"conditions,omitempty"`
+	// Plan and Fingerprint identify the phases the session ran, which the
+	// phase and action indexes refer to
+	Plan        string `json:"plan,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// ConditionResult records the evaluation of a phase condition
+type ConditionResult struct {
+	PhaseIndex  int       `json:"phase_index"`
+	PhaseName   string    `json:"phase_name"`
+	Condition   string    `json:"condition"`
+	Result      bool      `json:"result"`
+	Error       string    `json:"error,omitempty"` // The phase runs when evaluation fails
+	EvaluatedAt time.Time `json:"evaluated_at"`
 }
 
 // CompletedAction tracks an action that was executed
@@ -33,7 +56,13 @@ type CompletedAction struct {
 	RecoveryCmd string    `json:"recovery_cmd,omitempty"`
 	CompletedAt time.Time `json:"completed_at"`
 	Success     bool      `json:"success"`
-	Error       string    `json:"error,omitempty"`
+	// Skipped is set for an action not run because the phase or session
+	// was stopped by on_error
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Targets is what the action acted on, for executors whose recovery
+	// needs it, e.g. the guests shut down
+	Targets []string `json:"targets,omitempty"`
 }
 
 // Phase represents a shutdown phase
@@ -53,6 +82,41 @@ type Action struct {
 	OnError     string
 	Retry       *executor.RetryConfig
 	Healthcheck *executor.HealthcheckConfig
+
+	// ID names the action in the DependsOn of other actions
+	ID string
+	// DependsOn lists the ids of the actions to wait for, in place of the
+	// phase order
+	DependsOn []string
+}
+
+// ErrAborted is returned by Execute when the sequence was stopped by Abort
+var ErrAborted = errors.New("shutdown aborted")
+
+// ErrPlanChanged is returned by Recover when the phases differ from those
+// the session ran, so its actions cannot be matched to executors
+var ErrPlanChanged = errors.New("phases changed since the session")
+
+// ActionError is returned for a phase stopped by a failed action whose
+// on_error is abort_phase or abort_all
+type ActionError struct {
+	Phase   string
+	Action  string
+	OnError string
+	Err     error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("%s in phase %s failed (%s): %v", e.Action, e.Phase, e.OnError, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// skipReason is recorded for the actions not run because of e
+func (e *ActionError) skipReason() error {
+	return fmt.Errorf("skipped after %s failed (%s)", e.Action, e.OnError)
 }
 
 // Orchestrator manages the shutdown sequence
@@ -63,6 +127,15 @@ type Orchestrator struct {
 	mu        sync.RWMutex
 	logger    Logger
 	notifier  Notifier
+	abortCh   chan struct{}
+	abortOnce sync.Once
+	observer  Observer
+	dryRun    bool
+	trigger   string
+	upsStatus func() *ups.Status
+
+	plan        string
+	fingerprint string
 }
 
 // Logger interface for logging
@@ -77,6 +150,15 @@ type Notifier interface {
 	Notify(event string, data map[string]interface{}) error
 }
 
+// Observer receives the outcome and duration of phases, actions and
+// sessions, e.g. to export metrics
+type Observer interface {
+	PhaseDone(phase string, d time.Duration, err error)
+	ActionDone(phase, action string, d time.Duration, success bool)
+	SessionDone(status string, d time.Duration)
+	RecoveryDone(recovered, failed int)
+}
+
 // NewOrchestrator creates a new orchestrator
 func NewOrchestrator(phases []Phase, stateFile string, logger Logger, notifier Notifier) *Orchestrator {
 	return &Orchestrator{
@@ -84,12 +166,63 @@ func NewOrchestrator(phases []Phase, stateFile string, logger Logger, notifier N
 		stateFile: stateFile,
 		logger:    logger,
 		notifier:  notifier,
+		abortCh:   make(chan struct{}),
 		state: &State{
 			Status: "idle",
 		},
 	}
 }
 
+// SetObserver registers an observer. It must be set before Execute.
+func (o *Orchestrator) SetObserver(obs Observer) {
+	o.observer = obs
+}
+
+// SetDryRun enables dry-run mode: phases are walked as usual but each
+// action only reports what it would do, and recovery is simulated. It must
+// be set before Execute or Recover.
+func (o *Orchestrator) SetDryRun(dryRun bool) {
+	o.dryRun = dryRun
+}
+
+// SetTrigger sets the kind of trigger seen by phase conditions, e.g.
+// "critical" or "manual". It must be set before Execute.
+func (o *Orchestrator) SetTrigger(trigger string) {
+	o.trigger = trigger
+}
+
+// SetStatusFunc sets the function returning the UPS status seen by phase
+// conditions, nil when unknown. It must be set before Execute.
+func (o *Orchestrator) SetStatusFunc(fn func() *ups.Status) {
+	o.upsStatus = fn
+}
+
+// SetPlan names the plan the phases come from, e.g. "graceful", with a
+// fingerprint of their configuration. Execute records both in the state and
+// Recover refuses a session recorded with another fingerprint. It must be
+// set before Execute or Recover.
+func (o *Orchestrator) SetPlan(plan, fingerprint string) {
+	o.plan = plan
+	o.fingerprint = fingerprint
+}
+
+// Abort asks a running Execute to stop at the next safe boundary between
+// actions. Actions already in flight are allowed to finish.
+func (o *Orchestrator) Abort() {
+	o.abortOnce.Do(func() {
+		close(o.abortCh)
+	})
+}
+
+func (o *Orchestrator) aborted() bool {
+	select {
+	case <-o.abortCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // Execute runs the shutdown sequence
 func (o *Orchestrator) Execute(ctx context.Context, triggerEvent string) error {
 	o.mu.Lock()
@@ -104,6 +237,9 @@ func (o *Orchestrator) Execute(ctx context.Context, triggerEvent string) error {
 		TriggerEvent:     triggerEvent,
 		CompletedActions: []CompletedAction{},
 		LastUpdated:      time.Now(),
+		DryRun:           o.dryRun,
+		Plan:             o.plan,
+		Fingerprint:      o.fingerprint,
 	}
 
 	if err := o.saveState(); err != nil {
@@ -112,14 +248,45 @@ func (o *Orchestrator) Execute(ctx context.Context, triggerEvent string) error {
 	}
 	o.mu.Unlock()
 
+	if o.dryRun {
+		o.logger.Info("Dry run: simulating shutdown sequence", "session_id", o.state.SessionID)
+	}
+
 	o.notify("shutdown_start", map[string]interface{}{
 		"trigger":    triggerEvent,
 		"session_id": o.state.SessionID,
 		"phases":     len(o.phases),
+		"dry_run":    o.dryRun,
 	})
 
+	// Actions declaring depends_on run as a graph instead of phase by phase
+	graph, err := NewGraph(o.phases)
+	if err != nil {
+		o.logger.Error("Invalid action dependencies, running phases in order", "error", err)
+	} else if graph.Explicit() {
+		return o.executeGraph(ctx, triggerEvent, graph)
+	}
+
 	// Execute phases
+	results := make(map[string]string, len(o.phases))
+	previous := ""
 	for i, phase := range o.phases {
+		if o.aborted() {
+			return o.markAborted()
+		}
+
+		if !o.checkCondition(i, phase, triggerEvent, results, previous) {
+			o.logger.Info("Skipping phase, condition not met", "phase", phase.Name, "condition", phase.Condition)
+			o.notify("phase_skipped", map[string]interface{}{
+				"phase":     phase.Name,
+				"index":     i + 1,
+				"condition": phase.Condition,
+			})
+			results[phase.Name] = condition.PhaseSkipped
+			previous = condition.PhaseSkipped
+			continue
+		}
+
 		o.logger.Info("Starting phase", "phase", phase.Name, "index", i+1, "total", len(o.phases))
 
 		o.mu.Lock()
@@ -134,19 +301,45 @@ func (o *Orchestrator) Execute(ctx context.Context, triggerEvent string) error {
 			"index": i + 1,
 		})
 
-		if err := o.executePhase(ctx, i, phase); err != nil {
+		phaseStart := time.Now()
+		err := o.executePhase(ctx, i, phase)
+		if o.observer != nil {
+			o.observer.PhaseDone(phase.Name, time.Since(phaseStart), err)
+		}
+		previous = condition.PhaseCompleted
+		if err != nil {
+			if errors.Is(err, ErrAborted) {
+				return o.markAborted()
+			}
 			o.logger.Error("Phase failed", "phase", phase.Name, "error", err)
 
-			// Check if we should continue despite error
-			// For now, continue to next phase
+			// abort_all stops the session, abort_phase only the phase
+			var stop *ActionError
+			if errors.As(err, &stop) && stop.OnError == "abort_all" {
+				o.skipPhases(i+1, stop)
+				return o.markFailed(stop)
+			}
+		}
+		if err != nil || o.actionFailed(i) {
+			previous = condition.PhaseFailed
 		}
+		results[phase.Name] = previous
 
-		o.notify("phase_complete", map[string]interface{}{
+		data := map[string]interface{}{
 			"phase": phase.Name,
 			"index": i + 1,
-		})
+		}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		o.notify("phase_complete", data)
 	}
 
+	return o.markCompleted()
+}
+
+// markCompleted records that the sequence ran to its end
+func (o *Orchestrator) markCompleted() error {
 	o.mu.Lock()
 	o.state.Status = "completed"
 	o.state.LastUpdated = time.Now()
@@ -157,10 +350,128 @@ func (o *Orchestrator) Execute(ctx context.Context, triggerEvent string) error {
 		"session_id": o.state.SessionID,
 		"duration":   time.Since(o.state.StartedAt).String(),
 	})
+	if o.observer != nil {
+		o.observer.SessionDone("completed", time.Since(o.state.StartedAt))
+	}
 
 	return nil
 }
 
+// checkCondition evaluates the condition of a phase and records the
+// outcome in the state. A phase without condition always runs, and so does
+// one whose condition cannot be evaluated: skipping a shutdown step on a
+// missing reading is riskier than running it.
+func (o *Orchestrator) checkCondition(index int, phase Phase, reason string, results map[string]string, previous string) bool {
+	if phase.Condition == "" {
+		return true
+	}
+
+	earlier := make([]string, 0, index)
+	for _, p := range o.phases[:index] {
+		earlier = append(earlier, p.Name)
+	}
+
+	record := ConditionResult{
+		PhaseIndex: index,
+		PhaseName:  phase.Name,
+		Condition:  phase.Condition,
+	}
+	expr, err := condition.Parse(phase.Condition, earlier)
+	if err == nil {
+		env := condition.Env{
+			Trigger:  o.trigger,
+			Reason:   reason,
+			Elapsed:  time.Since(o.state.StartedAt),
+			Phases:   results,
+			Previous: previous,
+		}
+		if o.upsStatus != nil {
+			env.Status = o.upsStatus()
+		}
+		record.Result, err = expr.Eval(env)
+	}
+	if err != nil {
+		o.logger.Error("Phase condition failed, running phase", "phase", phase.Name, "condition", phase.Condition, "error", err)
+		record.Result = true
+		record.Error = err.Error()
+	}
+	record.EvaluatedAt = time.Now()
+
+	o.mu.Lock()
+	o.state.Conditions = append(o.state.Conditions, record)
+	o.state.LastUpdated = time.Now()
+	_ = o.saveState()
+	o.mu.Unlock()
+
+	return record.Result
+}
+
+// actionFailed reports whether an action of the phase at index failed
+func (o *Orchestrator) actionFailed(index int) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	for _, a := range o.state.CompletedActions {
+		if a.PhaseIndex == index && !a.Success {
+			return true
+		}
+	}
+	return false
+}
+
+// skipPhases records the actions of the phases from index on as skipped
+func (o *Orchestrator) skipPhases(from int, stop *ActionError) {
+	for i := from; i < len(o.phases); i++ {
+		for j, action := range o.phases[i].Actions {
+			o.recordAction(i, o.phases[i].Name, j, action, stop.skipReason(), true)
+		}
+	}
+}
+
+// markFailed records that an action stopped the whole sequence and
+// returns err
+func (o *Orchestrator) markFailed(err error) error {
+	o.mu.Lock()
+	o.state.Status = "failed"
+	o.state.Reason = err.Error()
+	o.state.LastUpdated = time.Now()
+	_ = o.saveState()
+	o.mu.Unlock()
+
+	o.logger.Error("Shutdown sequence stopped", "reason", err)
+	o.notify("shutdown_failed", map[string]interface{}{
+		"session_id": o.state.SessionID,
+		"reason":     err.Error(),
+	})
+	if o.observer != nil {
+		o.observer.SessionDone("failed", time.Since(o.state.StartedAt))
+	}
+
+	return err
+}
+
+// markAborted records that the sequence stopped early and returns ErrAborted
+func (o *Orchestrator) markAborted() error {
+	o.mu.Lock()
+	o.state.Status = "aborted"
+	o.state.Reason = "abort requested"
+	o.state.LastUpdated = time.Now()
+	_ = o.saveState()
+	completed := len(o.state.CompletedActions)
+	o.mu.Unlock()
+
+	o.logger.Info("Shutdown sequence aborted", "completed_actions", completed)
+	o.notify("shutdown_aborted", map[string]interface{}{
+		"session_id":        o.state.SessionID,
+		"completed_actions": completed,
+	})
+	if o.observer != nil {
+		o.observer.SessionDone("aborted", time.Since(o.state.StartedAt))
+	}
+
+	return ErrAborted
+}
+
 func (o *Orchestrator) executePhase(ctx context.Context, phaseIndex int, phase Phase) error {
 	// Apply phase timeout
 	if phase.Timeout > 0 {
@@ -177,114 +488,171 @@ func (o *Orchestrator) executePhase(ctx context.Context, phaseIndex int, phase P
 
 func (o *Orchestrator) executeSequential(ctx context.Context, phaseIndex int, phase Phase) error {
 	for i, action := range phase.Actions {
+		if o.aborted() {
+			return ErrAborted
+		}
+
 		o.mu.Lock()
 		o.state.CurrentAction = i
 		o.state.LastUpdated = time.Now()
 		_ = o.saveState()
 		o.mu.Unlock()
 
+		start := time.Now()
 		result, err := o.executeAction(ctx, phaseIndex, phase.Name, i, action)
+		failure := actionFailure(result, err)
+		o.observeAction(phase.Name, action, start, failure == nil)
+		o.recordAction(phaseIndex, phase.Name, i, action, failure, false)
 
-		// Track completed action
-		completed := CompletedAction{
-			PhaseIndex:  phaseIndex,
-			PhaseName:   phase.Name,
-			ActionIndex: i,
-			ActionType:  action.Type,
-			Description: action.Executor.String(),
-			RecoveryCmd: action.Recovery,
-			CompletedAt: time.Now(),
-			Success:     err == nil && result.Success,
-		}
-		if err != nil {
-			completed.Error = err.Error()
-		} else if !result.Success {
-			completed.Error = result.Error
+		if failure == nil {
+			continue
 		}
 
-		o.mu.Lock()
-		o.state.CompletedActions = append(o.state.CompletedActions, completed)
-		_ = o.saveState()
-		o.mu.Unlock()
-
 		// Handle error based on on_error setting
-		if err != nil || !result.Success {
-			switch action.OnError {
-			case "continue":
-				o.logger.Info("Action failed, continuing", "action", action.Executor.String())
-				continue
-			case "abort_phase":
-				return fmt.Errorf("action failed, aborting phase: %w", err)
-			case "abort_all":
-				return fmt.Errorf("action failed, aborting all: %w", err)
-			default:
-				// Default: continue
-				continue
+		switch action.OnError {
+		case "abort_phase", "abort_all":
+			stop := &ActionError{Phase: phase.Name, Action: action.Executor.String(), OnError: action.OnError, Err: failure}
+			for j := i + 1; j < len(phase.Actions); j++ {
+				o.recordAction(phaseIndex, phase.Name, j, phase.Actions[j], stop.skipReason(), true)
 			}
+			return stop
+		default:
+			// Default: continue
+			o.logger.Info("Action failed, continuing", "action", action.Executor.String())
 		}
 	}
 
 	return nil
 }
 
+// executeParallel starts every action of the phase at once. An action
+// failing with abort_phase or abort_all cancels its siblings still running.
 func (o *Orchestrator) executeParallel(ctx context.Context, phaseIndex int, phase Phase) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	var wg sync.WaitGroup
-	errCh := make(chan error, len(phase.Actions))
+	var mu sync.Mutex
+	var stop *ActionError
 
 	for i, action := range phase.Actions {
 		wg.Add(1)
 		go func(idx int, act Action) {
 			defer wg.Done()
 
-			result, err := o.executeAction(ctx, phaseIndex, phase.Name, idx, act)
-
-			// Track completed action
-			completed := CompletedAction{
-				PhaseIndex:  phaseIndex,
-				PhaseName:   phase.Name,
-				ActionIndex: idx,
-				ActionType:  act.Type,
-				Description: act.Executor.String(),
-				RecoveryCmd: act.Recovery,
-				CompletedAt: time.Now(),
-				Success:     err == nil && result.Success,
-			}
-			if err != nil {
-				completed.Error = err.Error()
-			} else if !result.Success {
-				completed.Error = result.Error
+			var sibling *ActionError
+			if errors.As(context.Cause(ctx), &sibling) {
+				o.recordAction(phaseIndex, phase.Name, idx, act, sibling.skipReason(), true)
+				return
 			}
 
-			o.mu.Lock()
-			o.state.CompletedActions = append(o.state.CompletedActions, completed)
-			_ = o.saveState()
-			o.mu.Unlock()
+			start := time.Now()
+			result, err := o.executeAction(ctx, phaseIndex, phase.Name, idx, act)
+			failure := actionFailure(result, err)
+			o.observeAction(phase.Name, act, start, failure == nil)
+
+			// Once a sibling stopped the phase, a failure is taken as the
+			// result of the cancellation rather than a new on_error trigger
+			if failure != nil && errors.As(context.Cause(ctx), &sibling) {
+				failure = fmt.Errorf("cancelled after %s failed: %w", sibling.Action, failure)
+				o.recordAction(phaseIndex, phase.Name, idx, act, failure, false)
+				return
+			}
+			o.recordAction(phaseIndex, phase.Name, idx, act, failure, false)
 
-			if err != nil && act.OnError == "abort_all" {
-				errCh <- err
+			if failure == nil || (act.OnError != "abort_phase" && act.OnError != "abort_all") {
+				return
+			}
+			e := &ActionError{Phase: phase.Name, Action: act.Executor.String(), OnError: act.OnError, Err: failure}
+			mu.Lock()
+			// abort_all wins over abort_phase when both fail together
+			if stop == nil || (e.OnError == "abort_all" && stop.OnError != "abort_all") {
+				stop = e
 			}
+			mu.Unlock()
+			cancel(e)
 		}(i, action)
 	}
 
 	wg.Wait()
-	close(errCh)
 
-	// Check for abort errors
-	for err := range errCh {
-		if err != nil {
-			return err
-		}
+	if stop != nil {
+		return stop
 	}
-
 	return nil
 }
 
+// actionFailure returns why an action failed, or nil if it succeeded
+func actionFailure(result *executor.ActionResult, err error) error {
+	if err != nil {
+		return err
+	}
+	if result == nil || result.Success {
+		return nil
+	}
+	if result.Error == "" {
+		return errors.New("action failed")
+	}
+	return errors.New(result.Error)
+}
+
+// recordAction adds an action to the completed actions of the state.
+// Skipped actions were never started; failure then tells why.
+func (o *Orchestrator) recordAction(phaseIndex int, phaseName string, actionIndex int, action Action, failure error, skipped bool) {
+	completed := CompletedAction{
+		PhaseIndex:  phaseIndex,
+		PhaseName:   phaseName,
+		ActionIndex: actionIndex,
+		ActionType:  action.Type,
+		Description: action.Executor.String(),
+		RecoveryCmd: action.Recovery,
+		CompletedAt: time.Now(),
+		Success:     failure == nil && !skipped,
+		Skipped:     skipped,
+	}
+	if failure != nil {
+		completed.Error = failure.Error()
+	}
+	if tr, ok := action.Executor.(executor.TargetRecorder); ok && !skipped {
+		completed.Targets = tr.Targets()
+	}
+
+	o.mu.Lock()
+	o.state.CompletedActions = append(o.state.CompletedActions, completed)
+	_ = o.saveState()
+	o.mu.Unlock()
+}
+
+func (o *Orchestrator) observeAction(phaseName string, action Action, start time.Time, success bool) {
+	if o.observer != nil {
+		o.observer.ActionDone(phaseName, action.Executor.String(), time.Since(start), success)
+	}
+}
+
 func (o *Orchestrator) executeAction(ctx context.Context, phaseIndex int, phaseName string, actionIndex int, action Action) (*executor.ActionResult, error) {
 	o.logger.Debug("Executing action",
 		"phase", phaseName,
 		"action", action.Executor.String(),
 	)
 
+	if o.dryRun {
+		result, err := executor.DryRun(ctx, action.Executor)
+		if err != nil {
+			o.logger.Error("Dry run: action failed to resolve",
+				"phase", phaseName,
+				"action", action.Executor.String(),
+				"error", err,
+			)
+			return result, err
+		}
+		o.logger.Info("Dry run: action skipped",
+			"phase", phaseName,
+			"action", action.Executor.String(),
+			"would", result.Output,
+		)
+		return result, nil
+	}
+
 	// Execute with retry if configured
 	var result *executor.ActionResult
 	var err error
@@ -325,27 +693,57 @@ func (o *Orchestrator) executeAction(ctx context.Context, phaseIndex int, phaseN
 	return result, nil
 }
 
-// Recover runs recovery for completed actions (in reverse order)
+// Recover runs recovery for completed actions (in reverse order). A failed
+// session, stopped by on_error or by an earlier recovery, can be recovered.
+// Cancelling ctx stops it before the next action and leaves the session
+// failed.
 func (o *Orchestrator) Recover(ctx context.Context) error {
 	o.mu.Lock()
-	if o.state.Status != "in_progress" && o.state.Status != "completed" {
+	switch o.state.Status {
+	case "in_progress", "completed", "aborted", "failed":
+	default:
 		o.mu.Unlock()
 		return fmt.Errorf("nothing to recover")
 	}
+	// Sessions recorded without a fingerprint are taken as matching
+	if o.fingerprint != "" && o.state.Fingerprint != "" && o.state.Fingerprint != o.fingerprint {
+		o.mu.Unlock()
+		return fmt.Errorf("session %s: %w", o.state.SessionID, ErrPlanChanged)
+	}
 	o.state.Status = "recovering"
 	_ = o.saveState()
+	sessionID := o.state.SessionID
+	completed := append([]CompletedAction(nil), o.state.CompletedActions...)
+	// Nothing was really done by a simulated session
+	simulate := o.dryRun || o.state.DryRun
 	o.mu.Unlock()
 
 	o.notify("recovery_start", map[string]interface{}{
-		"session_id": o.state.SessionID,
-		"actions":    len(o.state.CompletedActions),
+		"session_id": sessionID,
+		"actions":    len(completed),
 	})
 
 	// Recover in reverse order
-	for i := len(o.state.CompletedActions) - 1; i >= 0; i-- {
-		action := o.state.CompletedActions[i]
+	recovered, failed := 0, 0
+	var interrupted error
+	for i := len(completed) - 1; i >= 0; i-- {
+		action := completed[i]
+
+		if !action.Success || action.RecoveryCmd == "" {
+			continue
+		}
+		if interrupted = ctx.Err(); interrupted != nil {
+			o.logger.Info("Recovery interrupted", "session_id", sessionID, "error", interrupted)
+			break
+		}
 
-		if action.RecoveryCmd == "" {
+		if simulate {
+			o.logger.Info("Dry run: recovery skipped",
+				"phase", action.PhaseName,
+				"action", action.Description,
+				"would", action.RecoveryCmd,
+			)
+			recovered++
 			continue
 		}
 
@@ -354,23 +752,76 @@ func (o *Orchestrator) Recover(ctx context.Context) error {
 			"action", action.Description,
 		)
 
-		// Find the executor and run recovery
-		// TODO: Need to recreate executor from state
+		exec := o.executorFor(action)
+		if exec == nil {
+			o.logger.Error("No executor for recorded action",
+				"phase", action.PhaseName,
+				"action", action.Description,
+			)
+			failed++
+			continue
+		}
+
+		if tr, ok := exec.(executor.TargetRecorder); ok {
+			tr.SetTargets(action.Targets)
+		}
+		result, err := exec.Recover(ctx)
+		if err == nil && !result.Success {
+			err = errors.New(result.Error)
+		}
+		if err != nil {
+			o.logger.Error("Recovery failed for action",
+				"phase", action.PhaseName,
+				"action", action.Description,
+				"error", err,
+			)
+			failed++
+			continue
+		}
+		recovered++
 	}
 
 	o.mu.Lock()
-	o.state.Status = "idle"
-	o.state.CompletedActions = nil
+	if failed > 0 || interrupted != nil {
+		o.state.Status = "failed"
+	} else {
+		o.state.Status = "idle"
+		o.state.CompletedActions = nil
+	}
+	o.state.LastUpdated = time.Now()
 	_ = o.saveState()
 	o.mu.Unlock()
 
 	o.notify("recovery_complete", map[string]interface{}{
-		"session_id": o.state.SessionID,
+		"session_id":    sessionID,
+		"success_count": recovered,
+		"error_count":   failed,
 	})
+	if o.observer != nil {
+		o.observer.RecoveryDone(recovered, failed)
+	}
 
+	if interrupted != nil {
+		return fmt.Errorf("recovery interrupted: %w", interrupted)
+	}
+	if failed > 0 {
+		return fmt.Errorf("recovery completed with %d errors", failed)
+	}
 	return nil
 }
 
+// executorFor finds the executor that ran a recorded action
+func (o *Orchestrator) executorFor(action CompletedAction) executor.Executor {
+	if action.PhaseIndex < 0 || action.PhaseIndex >= len(o.phases) {
+		return nil
+	}
+	actions := o.phases[action.PhaseIndex].Actions
+	if action.ActionIndex < 0 || action.ActionIndex >= len(actions) {
+		return nil
+	}
+	return actions[action.ActionIndex].Executor
+}
+
 // GetState returns current state
 func (o *Orchestrator) GetState() State {
 	o.mu.RLock()
@@ -394,6 +845,24 @@ func (o *Orchestrator) LoadState() error {
 	return json.Unmarshal(data, o.state)
 }
 
+// ReadState reads the last session recorded in a state file, returning nil
+// when no session was ever recorded
+func ReadState(stateFile string) (*State, error) {
+	data, err := os.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing state file: %w", err)
+	}
+	return &state, nil
+}
+
 func (o *Orchestrator) saveState() error {
 	data, err := json.MarshalIndent(o.state, "", "  ")
 	if err != nil {
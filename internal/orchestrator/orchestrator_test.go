@@ -0,0 +1,484 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/executor"
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/ups"
+)
+
+func TestExecute(t *testing.T) {
+	rec := &recorder{}
+	phases := []Phase{
+		{Name: "one", Actions: []Action{rec.action("a", ""), rec.action("b", "")}},
+		{Name: "two", Parallel: true, Actions: []Action{rec.action("c", ""), rec.action("d", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if got := len(rec.executed()); got != 4 {
+		t.Errorf("Expected 4 executed actions, got %d", got)
+	}
+
+	state := orch.GetState()
+	if state.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+	if len(state.CompletedActions) != 4 {
+		t.Errorf("Expected 4 completed actions, got %d", len(state.CompletedActions))
+	}
+}
+
+func TestAbortStopsBetweenActions(t *testing.T) {
+	rec := &recorder{}
+	var orch *Orchestrator
+
+	first := rec.action("a", "undo-a")
+	first.Executor.(*mockExecutor).onExecute = func() { orch.Abort() }
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{first, rec.action("b", "undo-b")}},
+		{Name: "two", Actions: []Action{rec.action("c", "undo-c")}},
+	}
+
+	orch = NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	err := orch.Execute(context.Background(), "test")
+	if !errors.Is(err, ErrAborted) {
+		t.Fatalf("Expected ErrAborted, got %v", err)
+	}
+
+	executed := rec.executed()
+	if len(executed) != 1 || executed[0] != "a" {
+		t.Errorf("Expected only action a to run, got %v", executed)
+	}
+
+	if status := orch.GetState().Status; status != "aborted" {
+		t.Errorf("Expected status aborted, got %s", status)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	rec := &recorder{}
+	failing := rec.action("b", "undo-b")
+	failing.Executor.(*mockExecutor).fail = true
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{rec.action("a", "undo-a"), failing}},
+		{Name: "two", Actions: []Action{rec.action("c", "undo-c"), rec.action("d", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if err := orch.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+
+	// Failed actions and actions without recovery are skipped, the rest
+	// are recovered in reverse order
+	recovered := rec.recovered()
+	expected := []string{"c", "a"}
+	if len(recovered) != len(expected) {
+		t.Fatalf("Expected recovered %v, got %v", expected, recovered)
+	}
+	for i := range expected {
+		if recovered[i] != expected[i] {
+			t.Errorf("Expected recovered %v, got %v", expected, recovered)
+			break
+		}
+	}
+
+	if status := orch.GetState().Status; status != "idle" {
+		t.Errorf("Expected status idle after recovery, got %s", status)
+	}
+}
+
+func TestRecoverInterrupted(t *testing.T) {
+	rec := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A shutdown starting during recovery cancels it after action b
+	last := rec.action("b", "undo-b")
+	last.Executor.(*mockExecutor).onRecover = cancel
+
+	phases := []Phase{{Name: "one", Actions: []Action{rec.action("a", "undo-a"), last}}}
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if err := orch.Recover(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if got := rec.recovered(); len(got) != 1 || got[0] != "b" {
+		t.Errorf("Expected only b recovered, got %v", got)
+	}
+	if state := orch.GetState(); state.Status != "failed" || len(state.CompletedActions) != 2 {
+		t.Errorf("Expected a failed session keeping its actions, got %s with %d", state.Status, len(state.CompletedActions))
+	}
+}
+
+func TestRecoverRefusesChangedPlan(t *testing.T) {
+	rec := &recorder{}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	phases := []Phase{{Name: "one", Actions: []Action{rec.action("a", "undo-a")}}}
+
+	orch := NewOrchestrator(phases, stateFile, &testLogger{}, nil)
+	orch.SetPlan("emergency", "v1")
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if state := orch.GetState(); state.Plan != "emergency" || state.Fingerprint != "v1" {
+		t.Errorf("Expected the plan to be recorded, got %q %q", state.Plan, state.Fingerprint)
+	}
+
+	changed := NewOrchestrator(phases, stateFile, &testLogger{}, nil)
+	changed.SetPlan("emergency", "v2")
+	if err := changed.LoadState(); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if err := changed.Recover(context.Background()); !errors.Is(err, ErrPlanChanged) {
+		t.Fatalf("Expected ErrPlanChanged, got %v", err)
+	}
+	if got := rec.recovered(); len(got) != 0 {
+		t.Errorf("Expected nothing recovered, got %v", got)
+	}
+}
+
+func TestRecoverRestoresTargets(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	phases := func(exec *targetExecutor) []Phase {
+		return []Phase{{Name: "one", Actions: []Action{{Type: "mock", Executor: exec, Recovery: "start"}}}}
+	}
+
+	orch := NewOrchestrator(phases(&targetExecutor{targets: []string{"vm:100"}}), stateFile, &testLogger{}, nil)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// A new process recovers from the state file
+	exec := &targetExecutor{}
+	recovery := NewOrchestrator(phases(exec), stateFile, &testLogger{}, nil)
+	if err := recovery.LoadState(); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if err := recovery.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if len(exec.recovered) != 1 || exec.recovered[0] != "vm:100" {
+		t.Errorf("Expected vm:100 to be recovered, got %v", exec.recovered)
+	}
+}
+
+// targetExecutor records targets like the Proxmox guest executor
+type targetExecutor struct {
+	targets   []string
+	recovered []string
+}
+
+func (e *targetExecutor) Execute(ctx context.Context) (*executor.ActionResult, error) {
+	return &executor.ActionResult{Success: true}, nil
+}
+
+func (e *targetExecutor) Recover(ctx context.Context) (*executor.ActionResult, error) {
+	e.recovered = append(e.recovered, e.targets...)
+	return &executor.ActionResult{Success: true}, nil
+}
+
+func (e *targetExecutor) Healthcheck(ctx context.Context) (bool, error) { return true, nil }
+func (e *targetExecutor) String() string                                { return "Targets" }
+func (e *targetExecutor) Targets() []string                             { return e.targets }
+func (e *targetExecutor) SetTargets(targets []string)                   { e.targets = targets }
+
+// recorder tracks which mock executors ran
+func TestDryRun(t *testing.T) {
+	rec := &recorder{}
+	phases := []Phase{
+		{Name: "one", Actions: []Action{rec.action("a", "undo-a")}},
+		{Name: "two", Parallel: true, Actions: []Action{rec.action("b", "undo-b"), rec.action("c", "")}},
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	orch := NewOrchestrator(phases, stateFile, &testLogger{}, nil)
+	orch.SetDryRun(true)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if executed := rec.executed(); len(executed) != 0 {
+		t.Errorf("Expected no action to run in dry-run mode, got %v", executed)
+	}
+
+	state, err := ReadState(stateFile)
+	if err != nil {
+		t.Fatalf("ReadState failed: %v", err)
+	}
+	if !state.DryRun || state.Status != "completed" || len(state.CompletedActions) != 3 {
+		t.Errorf("Expected a completed simulated session with 3 actions, got %+v", state)
+	}
+
+	// A simulated session is never really recovered, even without dry-run
+	orch = NewOrchestrator(phases, stateFile, &testLogger{}, nil)
+	if err := orch.LoadState(); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if err := orch.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if recovered := rec.recovered(); len(recovered) != 0 {
+		t.Errorf("Expected no recovery for a simulated session, got %v", recovered)
+	}
+}
+
+func TestPhaseConditions(t *testing.T) {
+	rec := &recorder{}
+	failing := rec.action("a", "")
+	failing.Executor.(*mockExecutor).fail = true
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{failing}},
+		{Name: "low-battery", Condition: "battery.charge < 15", Actions: []Action{rec.action("b", "")}},
+		{Name: "retry", Condition: `phase("one") == "failed" && previous == "skipped"`, Actions: []Action{rec.action("c", "")}},
+		{Name: "manual", Condition: `trigger == "manual"`, Actions: []Action{rec.action("d", "")}},
+		{Name: "broken", Condition: `battery.charge <`, Actions: []Action{rec.action("e", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	orch.SetTrigger("critical")
+	orch.SetStatusFunc(func() *ups.Status { return &ups.Status{Status: "OB", BatteryCharge: 40} })
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// A condition that cannot be evaluated runs the phase
+	if got := rec.executed(); len(got) != 3 || got[0] != "a" || got[1] != "c" || got[2] != "e" {
+		t.Errorf("Expected actions a, c and e, got %v", got)
+	}
+
+	conditions := orch.GetState().Conditions
+	if len(conditions) != 4 {
+		t.Fatalf("Expected 4 recorded conditions, got %+v", conditions)
+	}
+	want := []bool{false, true, false, true}
+	for i, c := range conditions {
+		if c.Result != want[i] {
+			t.Errorf("Condition of %s: expected %v, got %v", c.PhaseName, want[i], c.Result)
+		}
+	}
+	if conditions[3].Error == "" {
+		t.Error("Expected the invalid condition to record its error")
+	}
+}
+
+func TestAbortPhase(t *testing.T) {
+	rec := &recorder{}
+	failing := rec.action("a", "")
+	failing.Executor.(*mockExecutor).fail = true
+	failing.OnError = "abort_phase"
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{failing, rec.action("b", "")}},
+		{Name: "two", Actions: []Action{rec.action("c", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	if err := orch.Execute(context.Background(), "test"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if got := rec.executed(); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("Expected actions a and c, got %v", got)
+	}
+	state := orch.GetState()
+	if state.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+	if skipped := skippedActions(state); len(skipped) != 1 || skipped[0] != "Mock: b" {
+		t.Errorf("Expected action b to be skipped, got %v", skipped)
+	}
+}
+
+func TestAbortAll(t *testing.T) {
+	rec := &recorder{}
+	failing := rec.action("b", "")
+	failing.Executor.(*mockExecutor).fail = true
+	failing.OnError = "abort_all"
+
+	phases := []Phase{
+		{Name: "one", Actions: []Action{rec.action("a", "undo-a"), failing, rec.action("c", "")}},
+		{Name: "two", Actions: []Action{rec.action("d", ""), rec.action("e", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	err := orch.Execute(context.Background(), "test")
+	var stop *ActionError
+	if !errors.As(err, &stop) || stop.Phase != "one" || stop.Action != "Mock: b" {
+		t.Fatalf("Expected ActionError for action b, got %v", err)
+	}
+
+	if got := rec.executed(); len(got) != 2 {
+		t.Errorf("Expected actions a and b only, got %v", got)
+	}
+	state := orch.GetState()
+	if state.Status != "failed" || !strings.Contains(state.Reason, "Mock: b in phase one failed") {
+		t.Errorf("Expected failed session with a reason, got %s (%s)", state.Status, state.Reason)
+	}
+	if skipped := skippedActions(state); len(skipped) != 3 {
+		t.Errorf("Expected actions c, d and e to be skipped, got %v", skipped)
+	}
+
+	// The actions done before the failure can be recovered
+	if err := orch.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if got := rec.recovered(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("Expected action a to be recovered, got %v", got)
+	}
+}
+
+func TestAbortCancelsParallelSiblings(t *testing.T) {
+	rec := &recorder{}
+	started := make(chan struct{})
+
+	slow := rec.action("slow", "")
+	slow.Executor.(*mockExecutor).onExecute = func() { close(started) }
+	slow.Executor.(*mockExecutor).block = true
+
+	failing := rec.action("failing", "")
+	failing.Executor.(*mockExecutor).onExecute = func() { <-started }
+	failing.Executor.(*mockExecutor).fail = true
+	failing.OnError = "abort_all"
+
+	phases := []Phase{
+		{Name: "one", Parallel: true, Actions: []Action{slow, failing}},
+		{Name: "two", Actions: []Action{rec.action("c", "")}},
+	}
+
+	orch := NewOrchestrator(phases, filepath.Join(t.TempDir(), "state.json"), &testLogger{}, nil)
+	done := make(chan error, 1)
+	go func() { done <- orch.Execute(context.Background(), "test") }()
+
+	select {
+	case err := <-done:
+		var stop *ActionError
+		if !errors.As(err, &stop) || stop.OnError != "abort_all" {
+			t.Fatalf("Expected abort_all ActionError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the blocked sibling to be cancelled")
+	}
+
+	state := orch.GetState()
+	var cancelled bool
+	for _, a := range state.CompletedActions {
+		if a.Description == "Mock: slow" && strings.Contains(a.Error, "cancelled after Mock: failing failed") {
+			cancelled = true
+		}
+	}
+	if !cancelled {
+		t.Errorf("Expected the slow action to be recorded as cancelled, got %+v", state.CompletedActions)
+	}
+	if skipped := skippedActions(state); len(skipped) != 1 || skipped[0] != "Mock: c" {
+		t.Errorf("Expected phase two to be skipped, got %v", skipped)
+	}
+}
+
+func skippedActions(state State) []string {
+	var skipped []string
+	for _, a := range state.CompletedActions {
+		if a.Skipped {
+			skipped = append(skipped, a.Description)
+		}
+	}
+	return skipped
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	execs   []string
+	recover []string
+}
+
+func (r *recorder) action(name, recovery string) Action {
+	return Action{
+		Type:     "mock",
+		Executor: &mockExecutor{name: name, rec: r},
+		Recovery: recovery,
+	}
+}
+
+func (r *recorder) executed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.execs...)
+}
+
+func (r *recorder) recovered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.recover...)
+}
+
+type mockExecutor struct {
+	name      string
+	rec       *recorder
+	fail      bool
+	block     bool // Wait for the context to be done
+	onExecute func()
+	onRecover func()
+}
+
+func (m *mockExecutor) Execute(ctx context.Context) (*executor.ActionResult, error) {
+	m.rec.mu.Lock()
+	m.rec.execs = append(m.rec.execs, m.name)
+	m.rec.mu.Unlock()
+
+	if m.onExecute != nil {
+		m.onExecute()
+	}
+	if m.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if m.fail {
+		return &executor.ActionResult{Success: false, Error: "simulated failure"}, nil
+	}
+	return &executor.ActionResult{Success: true}, nil
+}
+
+func (m *mockExecutor) Recover(ctx context.Context) (*executor.ActionResult, error) {
+	m.rec.mu.Lock()
+	m.rec.recover = append(m.rec.recover, m.name)
+	m.rec.mu.Unlock()
+
+	if m.onRecover != nil {
+		m.onRecover()
+	}
+	return &executor.ActionResult{Success: true}, nil
+}
+
+func (m *mockExecutor) Healthcheck(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (m *mockExecutor) String() string {
+	return "Mock: " + m.name
+}
+
+type testLogger struct{}
+
+func (l *testLogger) Info(msg string, fields ...interface{})  {}
+func (l *testLogger) Error(msg string, fields ...interface{}) {}
+func (l *testLogger) Debug(msg string, fields ...interface{}) {}
@@ -48,6 +48,10 @@ type Config struct {
 	TokenSecret string
 	InsecureTLS bool
 	DefaultNode string
+
+	// OnRequest, if set, is called after every API request with its
+	// method, duration and error
+	OnRequest func(method string, d time.Duration, err error)
 }
 
 // NewClient creates a new Proxmox client
@@ -61,6 +65,12 @@ func NewClient(cfg Config) (*Client, error) {
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		}
 	}
+	if cfg.OnRequest != nil {
+		httpClient.Transport = &observedTransport{
+			next:      httpClient.Transport,
+			onRequest: cfg.OnRequest,
+		}
+	}
 
 	opts := []proxmox.Option{
 		proxmox.WithHTTPClient(httpClient),
@@ -78,6 +88,28 @@ func NewClient(cfg Config) (*Client, error) {
 	}, nil
 }
 
+// observedTransport reports the duration of every request
+type observedTransport struct {
+	next      http.RoundTripper
+	onRequest func(method string, d time.Duration, err error)
+}
+
+func (t *observedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	if err == nil && resp.StatusCode >= 500 {
+		t.onRequest(req.Method, time.Since(start), fmt.Errorf("status %d", resp.StatusCode))
+	} else {
+		t.onRequest(req.Method, time.Since(start), err)
+	}
+	return resp, err
+}
+
 // GetVersion checks API connectivity by fetching version
 func (c *Client) GetVersion(ctx context.Context) (string, error) {
 	version, err := c.client.Version(ctx)
@@ -0,0 +1,232 @@
+package snmp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMP
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+
+	tagIPAddress = 0x40
+	tagCounter32 = 0x41
+	tagGauge32   = 0x42
+	tagTimeTicks = 0x43
+	tagOpaque    = 0x44
+	tagCounter64 = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	tagGetRequest = 0xa0
+	tagResponse   = 0xa2
+	tagReport     = 0xa8
+)
+
+// tlv encodes a BER element from its tag and the concatenated contents
+func tlv(tag byte, contents ...[]byte) []byte {
+	n := 0
+	for _, c := range contents {
+		n += len(c)
+	}
+
+	b := appendLength([]byte{tag}, n)
+	for _, c := range contents {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func appendLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+
+	var buf [4]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = byte(n)
+		n >>= 8
+	}
+	b = append(b, 0x80|byte(len(buf)-i))
+	return append(b, buf[i:]...)
+}
+
+// encodeInt encodes v as a minimal two's complement integer
+func encodeInt(tag byte, v int64) []byte {
+	var buf []byte
+	for {
+		b := byte(v)
+		buf = append([]byte{b}, buf...)
+		v >>= 8
+		if (v == 0 && b&0x80 == 0) || (v == -1 && b&0x80 != 0) {
+			break
+		}
+	}
+	return tlv(tag, buf)
+}
+
+// encodeUint encodes an unsigned application type such as Gauge32
+func encodeUint(tag byte, v uint64) []byte {
+	var buf []byte
+	for {
+		buf = append([]byte{byte(v)}, buf...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	if buf[0]&0x80 != 0 {
+		buf = append([]byte{0}, buf...)
+	}
+	return tlv(tag, buf)
+}
+
+// encodeOID encodes a dotted object identifier such as "1.3.6.1.2.1.33"
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = n
+	}
+	if arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+
+	content := appendBase128(nil, arcs[0]*40+arcs[1])
+	for _, arc := range arcs[2:] {
+		content = appendBase128(content, arc)
+	}
+	return tlv(tagOID, content), nil
+}
+
+func appendBase128(b []byte, v uint64) []byte {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+// parseElement splits the first BER element off b
+func parseElement(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated BER element")
+	}
+
+	tag = b[0]
+	n := int(b[1])
+	off := 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < 2+size {
+			return 0, nil, nil, fmt.Errorf("invalid BER length")
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		off += size
+	}
+	if n < 0 || len(b)-off < n {
+		return 0, nil, nil, fmt.Errorf("truncated BER element")
+	}
+
+	// content shares its backing array with b so that the offset of a
+	// field within a message can be computed from the capacities
+	return tag, b[off : off+n], b[off+n:], nil
+}
+
+// expect parses the first element of b and checks its tag
+func expect(b []byte, tag byte) (content, rest []byte, err error) {
+	t, content, rest, err := parseElement(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, fmt.Errorf("unexpected BER tag 0x%02x, expected 0x%02x", t, tag)
+	}
+	return content, rest, nil
+}
+
+// expectInt parses an INTEGER element
+func expectInt(b []byte) (int64, []byte, error) {
+	content, rest, err := expect(b, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	v, err := parseInt(content)
+	return v, rest, err
+}
+
+func parseInt(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, fmt.Errorf("invalid BER integer")
+	}
+	v := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+func parseUint(content []byte) (uint64, error) {
+	if len(content) == 0 || len(content) > 9 {
+		return 0, fmt.Errorf("invalid BER unsigned integer")
+	}
+	var v uint64
+	for _, c := range content {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func parseOID(content []byte) (string, error) {
+	if len(content) == 0 {
+		return "", fmt.Errorf("empty OID")
+	}
+
+	var arcs []string
+	var v uint64
+	first := true
+	for i, c := range content {
+		v = v<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(content)-1 {
+				return "", fmt.Errorf("truncated OID")
+			}
+			continue
+		}
+		if first {
+			x := v / 40
+			if x > 2 {
+				x = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(x, 10), strconv.FormatUint(v-x*40, 10))
+			first = false
+		} else {
+			arcs = append(arcs, strconv.FormatUint(v, 10))
+		}
+		v = 0
+	}
+	return strings.Join(arcs, "."), nil
+}
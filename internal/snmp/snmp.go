@@ -0,0 +1,665 @@
+// Package snmp implements a minimal SNMP v2c/v3 client able to read
+// scalar values with GetRequest
+package snmp
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Protocol versions
+const (
+	Version2c = "2c"
+	Version3  = "3"
+)
+
+const (
+	version2c        = 1
+	version3         = 3
+	securityModelUSM = 3
+	maxMessageSize   = 65507
+)
+
+// Type is the type of a variable binding value
+type Type byte
+
+const (
+	TypeInteger        Type = tagInteger
+	TypeOctetString    Type = tagOctetString
+	TypeNull           Type = tagNull
+	TypeOID            Type = tagOID
+	TypeIPAddress      Type = tagIPAddress
+	TypeCounter32      Type = tagCounter32
+	TypeGauge32        Type = tagGauge32
+	TypeTimeTicks      Type = tagTimeTicks
+	TypeOpaque         Type = tagOpaque
+	TypeCounter64      Type = tagCounter64
+	TypeNoSuchObject   Type = tagNoSuchObject
+	TypeNoSuchInstance Type = tagNoSuchInstance
+	TypeEndOfMibView   Type = tagEndOfMibView
+)
+
+// Value is the value of a variable binding
+type Value struct {
+	Type  Type
+	Int   int64  // INTEGER, Counter32, Gauge32, TimeTicks, Counter64
+	Bytes []byte // OCTET STRING, IpAddress, Opaque
+	OID   string // OBJECT IDENTIFIER
+}
+
+// Integer returns the value of numeric types
+func (v Value) Integer() (int64, bool) {
+	switch v.Type {
+	case TypeInteger, TypeCounter32, TypeGauge32, TypeTimeTicks, TypeCounter64:
+		return v.Int, true
+	}
+	return 0, false
+}
+
+// Exists reports whether the agent returned a value for the variable
+func (v Value) Exists() bool {
+	switch v.Type {
+	case TypeNull, TypeNoSuchObject, TypeNoSuchInstance, TypeEndOfMibView:
+		return false
+	}
+	return true
+}
+
+func (v Value) encode() []byte {
+	switch v.Type {
+	case TypeInteger:
+		return encodeInt(tagInteger, v.Int)
+	case TypeCounter32, TypeGauge32, TypeTimeTicks, TypeCounter64:
+		return encodeUint(byte(v.Type), uint64(v.Int))
+	case TypeOctetString, TypeIPAddress, TypeOpaque:
+		return tlv(byte(v.Type), v.Bytes)
+	case TypeOID:
+		if b, err := encodeOID(v.OID); err == nil {
+			return b
+		}
+	}
+	return tlv(byte(v.Type), nil)
+}
+
+func parseValue(tag byte, content []byte) (Value, error) {
+	v := Value{Type: Type(tag)}
+	var err error
+
+	switch v.Type {
+	case TypeInteger:
+		v.Int, err = parseInt(content)
+	case TypeCounter32, TypeGauge32, TypeTimeTicks, TypeCounter64:
+		var u uint64
+		u, err = parseUint(content)
+		v.Int = int64(u)
+	case TypeOctetString, TypeIPAddress, TypeOpaque:
+		v.Bytes = append([]byte(nil), content...)
+	case TypeOID:
+		v.OID, err = parseOID(content)
+	case TypeNull, TypeNoSuchObject, TypeNoSuchInstance, TypeEndOfMibView:
+	default:
+		err = fmt.Errorf("unsupported SNMP value type 0x%02x", tag)
+	}
+	return v, err
+}
+
+// errorStatuses names the PDU error-status values (RFC 3416)
+var errorStatuses = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+// Error is a non-zero error-status returned by the agent
+type Error struct {
+	Status int
+	Index  int    // 1-based index of the failing variable, if any
+	OID    string // OID of the failing variable, if known
+}
+
+func (e *Error) Error() string {
+	status := fmt.Sprintf("error %d", e.Status)
+	if e.Status > 0 && e.Status < len(errorStatuses) {
+		status = errorStatuses[e.Status]
+	}
+	if e.OID != "" {
+		return fmt.Sprintf("SNMP %s on %s", status, e.OID)
+	}
+	return "SNMP " + status
+}
+
+// usmReports maps the usmStats counters sent in Report PDUs to an error
+var usmReports = map[string]string{
+	"1.3.6.1.6.3.15.1.1.1.0": "unsupported security level",
+	"1.3.6.1.6.3.15.1.1.2.0": "not in time window",
+	"1.3.6.1.6.3.15.1.1.3.0": "unknown user name",
+	"1.3.6.1.6.3.15.1.1.4.0": "unknown engine ID",
+	"1.3.6.1.6.3.15.1.1.5.0": "wrong digest (check the auth password)",
+	"1.3.6.1.6.3.15.1.1.6.0": "decryption error (check the privacy password)",
+}
+
+const oidNotInTimeWindows = "1.3.6.1.6.3.15.1.1.2.0"
+
+type varbind struct {
+	oid   string
+	value Value
+}
+
+type pdu struct {
+	tag         byte
+	requestID   int64
+	errorStatus int64
+	errorIndex  int64
+	varbinds    []varbind
+}
+
+func (p pdu) encode() ([]byte, error) {
+	var vbs []byte
+	for _, vb := range p.varbinds {
+		oid, err := encodeOID(vb.oid)
+		if err != nil {
+			return nil, err
+		}
+		vbs = append(vbs, tlv(tagSequence, oid, vb.value.encode())...)
+	}
+
+	return tlv(p.tag,
+		encodeInt(tagInteger, p.requestID),
+		encodeInt(tagInteger, p.errorStatus),
+		encodeInt(tagInteger, p.errorIndex),
+		tlv(tagSequence, vbs),
+	), nil
+}
+
+func parsePDU(b []byte) (pdu, error) {
+	var p pdu
+	tag, content, _, err := parseElement(b)
+	if err != nil {
+		return p, err
+	}
+	p.tag = tag
+
+	if p.requestID, content, err = expectInt(content); err != nil {
+		return p, err
+	}
+	if p.errorStatus, content, err = expectInt(content); err != nil {
+		return p, err
+	}
+	if p.errorIndex, content, err = expectInt(content); err != nil {
+		return p, err
+	}
+	vbs, _, err := expect(content, tagSequence)
+	if err != nil {
+		return p, err
+	}
+
+	for len(vbs) > 0 {
+		var vb []byte
+		if vb, vbs, err = expect(vbs, tagSequence); err != nil {
+			return p, err
+		}
+		oid, rest, err := expect(vb, tagOID)
+		if err != nil {
+			return p, err
+		}
+		name, err := parseOID(oid)
+		if err != nil {
+			return p, err
+		}
+		tag, content, _, err := parseElement(rest)
+		if err != nil {
+			return p, err
+		}
+		value, err := parseValue(tag, content)
+		if err != nil {
+			return p, err
+		}
+		p.varbinds = append(p.varbinds, varbind{oid: name, value: value})
+	}
+
+	return p, nil
+}
+
+// message is a decoded SNMP message
+type message struct {
+	version   int64
+	community string
+
+	// SNMPv3 only
+	msgID           int64
+	flags           byte
+	usm             usmParams
+	contextEngineID []byte
+
+	pdu pdu
+}
+
+func encodeCommunity(community string, p pdu) ([]byte, error) {
+	b, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+	return tlv(tagSequence,
+		encodeInt(tagInteger, version2c),
+		tlv(tagOctetString, []byte(community)),
+		b,
+	), nil
+}
+
+// encodeV3 encodes, encrypts and authenticates an SNMPv3 message as
+// requested by flags
+func encodeV3(msgID int64, flags byte, params usmParams, contextEngineID []byte, p pdu, sec *security) ([]byte, error) {
+	b, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	msgData := tlv(tagSequence, tlv(tagOctetString, contextEngineID), tlv(tagOctetString, nil), b)
+	if flags&flagPriv != 0 {
+		encrypted, salt, err := sec.encrypt(msgData, params.boots, params.time)
+		if err != nil {
+			return nil, err
+		}
+		params.privParams = salt
+		msgData = tlv(tagOctetString, encrypted)
+	}
+	if flags&flagAuth != 0 {
+		params.authParams = make([]byte, authParamsLen)
+	}
+
+	header := tlv(tagSequence,
+		encodeInt(tagInteger, msgID),
+		encodeInt(tagInteger, maxMessageSize),
+		tlv(tagOctetString, []byte{flags}),
+		encodeInt(tagInteger, securityModelUSM),
+	)
+	msg := tlv(tagSequence, encodeInt(tagInteger, version3), header, params.encode(), msgData)
+
+	if flags&flagAuth != 0 {
+		// The authentication parameters are followed by the privacy
+		// parameters, which end the security parameters, then msgData
+		offset := len(msg) - len(msgData) - len(tlv(tagOctetString, params.privParams)) - authParamsLen
+		copy(msg[offset:], sec.mac(msg, offset))
+	}
+	return msg, nil
+}
+
+// decodeMessage decodes a v2c or v3 message. sec authenticates and
+// decrypts v3 messages and may be nil for unauthenticated ones.
+func decodeMessage(b []byte, sec *security) (*message, error) {
+	content, _, err := expect(b, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	m := &message{}
+	if m.version, content, err = expectInt(content); err != nil {
+		return nil, err
+	}
+
+	if m.version != version3 {
+		community, rest, err := expect(content, tagOctetString)
+		if err != nil {
+			return nil, err
+		}
+		m.community = string(community)
+		m.pdu, err = parsePDU(rest)
+		return m, err
+	}
+
+	header, rest, err := expect(content, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	if m.msgID, header, err = expectInt(header); err != nil {
+		return nil, err
+	}
+	if _, header, err = expectInt(header); err != nil {
+		return nil, err
+	}
+	flags, _, err := expect(header, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if len(flags) != 1 {
+		return nil, fmt.Errorf("invalid SNMPv3 message flags")
+	}
+	m.flags = flags[0]
+
+	secParams, msgData, err := expect(rest, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if m.usm, err = parseUSMParams(secParams); err != nil {
+		return nil, err
+	}
+
+	if m.flags&flagAuth != 0 {
+		if sec == nil || sec.hash == nil {
+			return nil, fmt.Errorf("authenticated SNMPv3 message without credentials")
+		}
+		if len(m.usm.authParams) != authParamsLen {
+			return nil, fmt.Errorf("invalid SNMPv3 authentication parameters")
+		}
+		offset := cap(b) - cap(m.usm.authParams)
+		if !hmac.Equal(sec.mac(b, offset), m.usm.authParams) {
+			return nil, fmt.Errorf("SNMPv3 message authentication failed")
+		}
+	}
+
+	scoped := msgData
+	if m.flags&flagPriv != 0 {
+		if sec == nil || sec.priv == "" {
+			return nil, fmt.Errorf("encrypted SNMPv3 message without credentials")
+		}
+		encrypted, _, err := expect(msgData, tagOctetString)
+		if err != nil {
+			return nil, err
+		}
+		if scoped, err = sec.decrypt(encrypted, m.usm.privParams, m.usm.boots, m.usm.time); err != nil {
+			return nil, err
+		}
+	}
+
+	scoped, _, err = expect(scoped, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	if m.contextEngineID, scoped, err = expect(scoped, tagOctetString); err != nil {
+		return nil, err
+	}
+	if _, scoped, err = expect(scoped, tagOctetString); err != nil {
+		return nil, err
+	}
+	m.pdu, err = parsePDU(scoped)
+	return m, err
+}
+
+// Config configures an SNMP client
+type Config struct {
+	Address   string // host:port
+	Version   string // "2c" (default) or "3"
+	Community string // SNMPv2c community, "public" if empty
+
+	// SNMPv3 user-based security. Leave AuthProtocol or PrivProtocol empty
+	// for the noAuthNoPriv and authNoPriv levels.
+	User         string
+	AuthProtocol string // "MD5" or "SHA"
+	AuthPassword string
+	PrivProtocol string // "DES" or "AES"
+	PrivPassword string
+
+	// Timeout bounds each attempt, Retries the number of extra attempts
+	Timeout time.Duration
+	Retries int
+}
+
+// Client is an SNMP client
+type Client struct {
+	cfg    Config
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID int64
+
+	// Authoritative engine of the agent, SNMPv3 only
+	engineID     []byte
+	engineBoots  int64
+	engineTime   int64
+	discoveredAt time.Time
+	sec          *security
+}
+
+// NewClient creates a new SNMP client
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Version == "" {
+		cfg.Version = Version2c
+	}
+	if cfg.Community == "" {
+		cfg.Community = "public"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+
+	switch cfg.Version {
+	case Version2c:
+	case Version3:
+		if cfg.User == "" {
+			return nil, fmt.Errorf("SNMPv3 requires a user")
+		}
+		// Check the protocols early, the keys need the engine ID
+		if _, err := newSecurity(cfg, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q", cfg.Version)
+	}
+
+	return &Client{
+		cfg:    cfg,
+		nextID: rand.Int63n(1 << 30),
+	}, nil
+}
+
+// Connect opens the UDP socket and, with SNMPv3, discovers the engine of
+// the agent
+func (c *Client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	conn, err := net.DialTimeout("udp", c.cfg.Address, c.cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("connecting to SNMP agent: %w", err)
+	}
+	c.conn = conn
+
+	if c.cfg.Version == Version3 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout*time.Duration(c.cfg.Retries+1))
+		defer cancel()
+		if err := c.discover(ctx); err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("discovering SNMP engine: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the UDP socket
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Get reads the given OIDs. Variables unknown to the agent are returned
+// with a value for which Exists is false.
+func (c *Client) Get(ctx context.Context, oids ...string) (map[string]Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	req := pdu{tag: tagGetRequest}
+	for _, oid := range oids {
+		req.varbinds = append(req.varbinds, varbind{oid: oid, value: Value{Type: TypeNull}})
+	}
+
+	var resp *message
+	var err error
+	if c.cfg.Version == Version3 {
+		resp, err = c.exchangeV3(ctx, req)
+	} else {
+		resp, err = c.exchange(ctx, req, func(id int64, p pdu) ([]byte, error) {
+			return encodeCommunity(c.cfg.Community, p)
+		}, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.pdu.errorStatus != 0 {
+		e := &Error{Status: int(resp.pdu.errorStatus), Index: int(resp.pdu.errorIndex)}
+		if e.Index > 0 && e.Index <= len(oids) {
+			e.OID = oids[e.Index-1]
+		}
+		return nil, e
+	}
+
+	values := make(map[string]Value, len(resp.pdu.varbinds))
+	for _, vb := range resp.pdu.varbinds {
+		values[vb.oid] = vb.value
+	}
+	return values, nil
+}
+
+// exchangeV3 sends an SNMPv3 request, resynchronizing the engine time once
+// if the agent reports it out of its time window. Caller must hold c.mu.
+func (c *Client) exchangeV3(ctx context.Context, req pdu) (*message, error) {
+	for attempt := 0; ; attempt++ {
+		elapsed := int64(time.Since(c.discoveredAt).Seconds())
+		params := usmParams{
+			engineID: c.engineID,
+			boots:    c.engineBoots,
+			time:     c.engineTime + elapsed,
+			user:     c.cfg.User,
+		}
+		resp, err := c.exchange(ctx, req, func(id int64, p pdu) ([]byte, error) {
+			return encodeV3(id, c.sec.flags(), params, c.engineID, p, c.sec)
+		}, c.sec)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.flags&flagAuth != 0 {
+			// Authenticated messages carry the current engine clock
+			c.syncEngine(resp.usm)
+		}
+		if resp.pdu.tag != tagReport {
+			return resp, nil
+		}
+
+		oid := ""
+		if len(resp.pdu.varbinds) > 0 {
+			oid = resp.pdu.varbinds[0].oid
+		}
+		if oid == oidNotInTimeWindows && attempt == 0 {
+			c.syncEngine(resp.usm)
+			continue
+		}
+		if reason, ok := usmReports[oid]; ok {
+			return nil, fmt.Errorf("SNMPv3 agent rejected the request: %s", reason)
+		}
+		return nil, fmt.Errorf("SNMPv3 agent sent an unexpected report %s", oid)
+	}
+}
+
+// discover learns the engine ID, boots and time of the agent and derives
+// the localized keys. Caller must hold c.mu.
+func (c *Client) discover(ctx context.Context) error {
+	resp, err := c.exchange(ctx, pdu{tag: tagGetRequest}, func(id int64, p pdu) ([]byte, error) {
+		return encodeV3(id, flagReportable, usmParams{}, nil, p, nil)
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if len(resp.usm.engineID) == 0 {
+		return fmt.Errorf("agent did not report its engine ID")
+	}
+
+	sec, err := newSecurity(c.cfg, resp.usm.engineID)
+	if err != nil {
+		return err
+	}
+	c.sec = sec
+	c.engineID = resp.usm.engineID
+	c.syncEngine(resp.usm)
+	return nil
+}
+
+func (c *Client) syncEngine(p usmParams) {
+	c.engineBoots = p.boots
+	c.engineTime = p.time
+	c.discoveredAt = time.Now()
+}
+
+// exchange sends a request built by encode and waits for the matching
+// response, retrying on timeouts. Caller must hold c.mu.
+func (c *Client) exchange(ctx context.Context, req pdu, encode func(id int64, p pdu) ([]byte, error), sec *security) (*message, error) {
+	buf := make([]byte, maxMessageSize)
+
+	var lastErr error
+	for attempt := 0; attempt <= c.cfg.Retries; attempt++ {
+		c.nextID = (c.nextID + 1) & 0x7fffffff
+		id := c.nextID
+		req.requestID = id
+
+		msg, err := encode(id, req)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.conn.Write(msg); err != nil {
+			return nil, fmt.Errorf("sending request: %w", err)
+		}
+
+		deadline := time.Now().Add(c.cfg.Timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = c.conn.SetReadDeadline(deadline)
+
+		var badResponse error
+		for {
+			n, err := c.conn.Read(buf)
+			if err != nil {
+				lastErr = fmt.Errorf("reading response: %w", err)
+				if badResponse != nil {
+					lastErr = badResponse
+				}
+				break
+			}
+			resp, err := decodeMessage(buf[:n:n], sec)
+			if err != nil {
+				badResponse = err
+				continue
+			}
+			// Responses to earlier, timed out attempts are skipped
+			if resp.version == version3 && resp.msgID != id {
+				continue
+			}
+			if resp.version != version3 && resp.pdu.requestID != id {
+				continue
+			}
+			return resp, nil
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+		var netErr net.Error
+		if !errors.As(lastErr, &netErr) || !netErr.Timeout() {
+			// e.g. connection refused: retrying will not help
+			break
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,281 @@
+package snmp
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAgent answers GetRequests from a fixed table, in SNMPv2c with its
+// community or in SNMPv3 with the user of cfg
+type fakeAgent struct {
+	conn      net.PacketConn
+	community string
+	engineID  []byte
+	sec       *security
+	values    map[string]Value
+
+	boots, time int64
+	// hideTime reports boots and time as 0 on discovery, as some agents do
+	hideTime bool
+}
+
+func newFakeAgent(t *testing.T, cfg Config, values map[string]Value, hideTime bool) *fakeAgent {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	a := &fakeAgent{
+		conn:      conn,
+		community: cfg.Community,
+		engineID:  []byte{0x80, 0x00, 0x1f, 0x88, 0x04, 't', 'e', 's', 't'},
+		values:    values,
+		boots:     3,
+		time:      1000,
+		hideTime:  hideTime,
+	}
+	if cfg.User != "" {
+		if a.sec, err = newSecurity(cfg, a.engineID); err != nil {
+			t.Fatalf("newSecurity failed: %v", err)
+		}
+	}
+
+	go a.serve()
+	return a
+}
+
+func (a *fakeAgent) addr() string { return a.conn.LocalAddr().String() }
+
+func (a *fakeAgent) serve() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if out := a.respond(buf[:n:n]); out != nil {
+			_, _ = a.conn.WriteTo(out, addr)
+		}
+	}
+}
+
+func (a *fakeAgent) respond(b []byte) []byte {
+	m, err := decodeMessage(b, a.sec)
+	if err != nil {
+		// Wrong digest or undecryptable: stay silent
+		return nil
+	}
+
+	reply := pdu{tag: tagResponse, requestID: m.pdu.requestID}
+	for _, vb := range m.pdu.varbinds {
+		v, ok := a.values[vb.oid]
+		if !ok {
+			v = Value{Type: TypeNoSuchObject}
+		}
+		reply.varbinds = append(reply.varbinds, varbind{oid: vb.oid, value: v})
+	}
+
+	if m.version != version3 {
+		if m.community != a.community {
+			return nil
+		}
+		out, _ := encodeCommunity(a.community, reply)
+		return out
+	}
+
+	params := usmParams{engineID: a.engineID, boots: a.boots, time: a.time, user: m.usm.user}
+	report := func(oid string, flags byte) []byte {
+		p := pdu{tag: tagReport, requestID: m.pdu.requestID, varbinds: []varbind{
+			{oid: oid, value: Value{Type: TypeCounter32, Int: 1}},
+		}}
+		out, _ := encodeV3(m.msgID, flags, params, a.engineID, p, a.sec)
+		return out
+	}
+
+	if len(m.usm.engineID) == 0 {
+		if a.hideTime {
+			params.boots, params.time = 0, 0
+		}
+		return report("1.3.6.1.6.3.15.1.1.4.0", 0)
+	}
+	if m.usm.boots != a.boots || m.usm.time < a.time-150 || m.usm.time > a.time+150 {
+		return report(oidNotInTimeWindows, flagAuth)
+	}
+
+	out, _ := encodeV3(m.msgID, m.flags&^flagReportable, params, a.engineID, reply, a.sec)
+	return out
+}
+
+var upsValues = map[string]Value{
+	"1.3.6.1.2.1.33.1.2.4.0":     {Type: TypeInteger, Int: 87},
+	"1.3.6.1.2.1.33.1.4.4.1.5.1": {Type: TypeInteger, Int: 31},
+	"1.3.6.1.2.1.33.1.1.2.0":     {Type: TypeOctetString, Bytes: []byte("Smart-UPS 1500")},
+	"1.3.6.1.2.1.1.3.0":          {Type: TypeTimeTicks, Int: 4294967295},
+}
+
+func TestGetV2c(t *testing.T) {
+	agent := newFakeAgent(t, Config{Community: "guardian"}, upsValues, false)
+
+	c, err := NewClient(Config{Address: agent.addr(), Community: "guardian"})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	values, err := c.Get(context.Background(),
+		"1.3.6.1.2.1.33.1.2.4.0", "1.3.6.1.2.1.33.1.4.4.1.5.1", "1.3.6.1.2.1.33.1.1.2.0",
+		"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.33.1.2.3.0")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if v, ok := values["1.3.6.1.2.1.33.1.2.4.0"].Integer(); !ok || v != 87 {
+		t.Errorf("Expected charge 87, got %v", values["1.3.6.1.2.1.33.1.2.4.0"])
+	}
+	if v, ok := values["1.3.6.1.2.1.1.3.0"].Integer(); !ok || v != 4294967295 {
+		t.Errorf("Expected unsigned TimeTicks, got %v", values["1.3.6.1.2.1.1.3.0"])
+	}
+	if s := string(values["1.3.6.1.2.1.33.1.1.2.0"].Bytes); s != "Smart-UPS 1500" {
+		t.Errorf("Unexpected model %q", s)
+	}
+	if values["1.3.6.1.2.1.33.1.2.3.0"].Exists() {
+		t.Error("Expected missing OID not to exist")
+	}
+
+	wrong, _ := NewClient(Config{Address: agent.addr(), Community: "public", Timeout: 100 * time.Millisecond})
+	if err := wrong.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer wrong.Close()
+	if _, err := wrong.Get(context.Background(), "1.3.6.1.2.1.33.1.2.4.0"); err == nil {
+		t.Error("Expected timeout with the wrong community")
+	}
+}
+
+func TestGetV3(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		hideTime bool
+	}{
+		{name: "noAuthNoPriv", cfg: Config{User: "monitor"}},
+		{name: "SHA", cfg: Config{User: "monitor", AuthProtocol: AuthSHA, AuthPassword: "authpass1"}},
+		{name: "MD5 DES", cfg: Config{User: "monitor", AuthProtocol: AuthMD5, AuthPassword: "authpass1",
+			PrivProtocol: PrivDES, PrivPassword: "privpass1"}},
+		{name: "SHA AES", cfg: Config{User: "monitor", AuthProtocol: AuthSHA, AuthPassword: "authpass1",
+			PrivProtocol: PrivAES, PrivPassword: "privpass1"}},
+		{name: "time resync", hideTime: true, cfg: Config{User: "monitor", AuthProtocol: AuthSHA,
+			AuthPassword: "authpass1", PrivProtocol: PrivAES, PrivPassword: "privpass1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newFakeAgent(t, tt.cfg, upsValues, tt.hideTime)
+
+			cfg := tt.cfg
+			cfg.Version = Version3
+			cfg.Address = agent.addr()
+			c, err := NewClient(cfg)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+			if err := c.Connect(); err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			defer c.Close()
+
+			values, err := c.Get(context.Background(), "1.3.6.1.2.1.33.1.2.4.0")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if v, _ := values["1.3.6.1.2.1.33.1.2.4.0"].Integer(); v != 87 {
+				t.Errorf("Expected charge 87, got %d", v)
+			}
+		})
+	}
+
+	agent := newFakeAgent(t, Config{User: "monitor", AuthProtocol: AuthSHA, AuthPassword: "authpass1"}, upsValues, false)
+	c, err := NewClient(Config{Version: Version3, Address: agent.addr(), User: "monitor",
+		AuthProtocol: AuthSHA, AuthPassword: "wrongpass", Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Get(context.Background(), "1.3.6.1.2.1.33.1.2.4.0"); err == nil {
+		t.Error("Expected error with the wrong auth password")
+	}
+}
+
+func TestNewClientValidation(t *testing.T) {
+	tests := []Config{
+		{Version: "1"},
+		{Version: Version3},
+		{Version: Version3, User: "u", AuthProtocol: "SHA512", AuthPassword: "authpass1"},
+		{Version: Version3, User: "u", AuthProtocol: AuthSHA, AuthPassword: "short"},
+		{Version: Version3, User: "u", PrivProtocol: PrivAES, PrivPassword: "privpass1"},
+		{Version: Version3, User: "u", AuthProtocol: AuthSHA, AuthPassword: "authpass1", PrivProtocol: "3DES", PrivPassword: "privpass1"},
+	}
+	for _, cfg := range tests {
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+// TestKeyLocalization checks the key derivation against RFC 3414 A.3
+func TestKeyLocalization(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+
+	md5Key := localizeKey(md5.New, passwordToKey(md5.New, "maplesyrup"), engineID)
+	if got := hex.EncodeToString(md5Key); got != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Errorf("Unexpected MD5 localized key %s", got)
+	}
+	shaKey := localizeKey(sha1.New, passwordToKey(sha1.New, "maplesyrup"), engineID)
+	if got := hex.EncodeToString(shaKey); got != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("Unexpected SHA localized key %s", got)
+	}
+}
+
+func TestBER(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40, -(1 << 40)} {
+		content, _, err := expect(encodeInt(tagInteger, v), tagInteger)
+		if err != nil {
+			t.Fatalf("expect failed: %v", err)
+		}
+		if got, err := parseInt(content); err != nil || got != v {
+			t.Errorf("Integer %d round trips to %d (%v)", v, got, err)
+		}
+	}
+
+	for _, oid := range []string{"1.3.6.1.2.1.33.1.2.4.0", "1.3.6.1.4.1.318.1.1.1.2.2.1.0", "2.999.3"} {
+		b, err := encodeOID(oid)
+		if err != nil {
+			t.Fatalf("encodeOID(%s) failed: %v", oid, err)
+		}
+		content, _, _ := expect(b, tagOID)
+		if got, err := parseOID(content); err != nil || got != oid {
+			t.Errorf("OID %s round trips to %s (%v)", oid, got, err)
+		}
+	}
+
+	long := tlv(tagOctetString, []byte(strings.Repeat("x", 300)))
+	content, rest, err := expect(long, tagOctetString)
+	if err != nil || len(content) != 300 || len(rest) != 0 {
+		t.Errorf("Long form length not decoded: %d bytes (%v)", len(content), err)
+	}
+}
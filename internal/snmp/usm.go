@@ -0,0 +1,262 @@
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync/atomic"
+)
+
+// Authentication and privacy protocols of the SNMPv3 user-based security
+// model (RFC 3414, RFC 3826)
+const (
+	AuthMD5 = "MD5"
+	AuthSHA = "SHA"
+	PrivDES = "DES"
+	PrivAES = "AES"
+)
+
+// msgFlags bits
+const (
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+)
+
+// authParamsLen is the length of the truncated HMAC-MD5-96/HMAC-SHA-96
+const authParamsLen = 12
+
+// usmParams are the msgSecurityParameters of an SNMPv3 message
+type usmParams struct {
+	engineID   []byte
+	boots      int64
+	time       int64
+	user       string
+	authParams []byte
+	privParams []byte
+}
+
+func (p usmParams) encode() []byte {
+	return tlv(tagOctetString, tlv(tagSequence,
+		tlv(tagOctetString, p.engineID),
+		encodeInt(tagInteger, p.boots),
+		encodeInt(tagInteger, p.time),
+		tlv(tagOctetString, []byte(p.user)),
+		tlv(tagOctetString, p.authParams),
+		tlv(tagOctetString, p.privParams),
+	))
+}
+
+func parseUSMParams(b []byte) (usmParams, error) {
+	var p usmParams
+	seq, _, err := expect(b, tagSequence)
+	if err != nil {
+		return p, err
+	}
+	if p.engineID, seq, err = expect(seq, tagOctetString); err != nil {
+		return p, err
+	}
+	if p.boots, seq, err = expectInt(seq); err != nil {
+		return p, err
+	}
+	if p.time, seq, err = expectInt(seq); err != nil {
+		return p, err
+	}
+	user, seq, err := expect(seq, tagOctetString)
+	if err != nil {
+		return p, err
+	}
+	p.user = string(user)
+	if p.authParams, seq, err = expect(seq, tagOctetString); err != nil {
+		return p, err
+	}
+	if p.privParams, _, err = expect(seq, tagOctetString); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// security holds the keys of a user localized to an authoritative engine
+type security struct {
+	user    string
+	hash    func() hash.Hash // nil without authentication
+	authKey []byte
+	priv    string // empty without privacy
+	privKey []byte
+	salt    atomic.Uint64
+}
+
+// newSecurity derives the keys of a user for the given engine ID
+func newSecurity(cfg Config, engineID []byte) (*security, error) {
+	s := &security{user: cfg.User}
+
+	switch cfg.AuthProtocol {
+	case "":
+		if cfg.PrivProtocol != "" {
+			return nil, fmt.Errorf("SNMPv3 privacy requires authentication")
+		}
+		return s, nil
+	case AuthMD5:
+		s.hash = md5.New
+	case AuthSHA:
+		s.hash = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 auth protocol %q", cfg.AuthProtocol)
+	}
+	if len(cfg.AuthPassword) < 8 {
+		return nil, fmt.Errorf("SNMPv3 auth password must be at least 8 characters")
+	}
+	s.authKey = localizeKey(s.hash, passwordToKey(s.hash, cfg.AuthPassword), engineID)
+
+	switch cfg.PrivProtocol {
+	case "":
+		return s, nil
+	case PrivDES, PrivAES:
+		s.priv = cfg.PrivProtocol
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 privacy protocol %q", cfg.PrivProtocol)
+	}
+	if len(cfg.PrivPassword) < 8 {
+		return nil, fmt.Errorf("SNMPv3 privacy password must be at least 8 characters")
+	}
+	s.privKey = localizeKey(s.hash, passwordToKey(s.hash, cfg.PrivPassword), engineID)
+
+	return s, nil
+}
+
+// flags returns the msgFlags matching the security level
+func (s *security) flags() byte {
+	var flags byte = flagReportable
+	if s.hash != nil {
+		flags |= flagAuth
+	}
+	if s.priv != "" {
+		flags |= flagPriv
+	}
+	return flags
+}
+
+// passwordToKey implements the password to key algorithm of RFC 3414 A.2
+func passwordToKey(h func() hash.Hash, password string) []byte {
+	d := h()
+	buf := make([]byte, 64)
+	pw := []byte(password)
+	idx := 0
+	for count := 0; count < 1048576; count += len(buf) {
+		for i := range buf {
+			buf[i] = pw[idx%len(pw)]
+			idx++
+		}
+		d.Write(buf)
+	}
+	return d.Sum(nil)
+}
+
+// localizeKey binds a user key to an authoritative engine (RFC 3414 2.6)
+func localizeKey(h func() hash.Hash, key, engineID []byte) []byte {
+	d := h()
+	d.Write(key)
+	d.Write(engineID)
+	d.Write(key)
+	return d.Sum(nil)
+}
+
+// mac computes the authentication parameters of msg, whose own
+// authentication parameters at offset are taken as zeros
+func (s *security) mac(msg []byte, offset int) []byte {
+	buf := append([]byte(nil), msg...)
+	copy(buf[offset:offset+authParamsLen], make([]byte, authParamsLen))
+
+	m := hmac.New(s.hash, s.authKey)
+	m.Write(buf)
+	return m.Sum(nil)[:authParamsLen]
+}
+
+// encrypt encrypts a scoped PDU and returns it with the privacy parameters
+func (s *security) encrypt(plain []byte, boots, engineTime int64) ([]byte, []byte, error) {
+	salt := make([]byte, 8)
+
+	switch s.priv {
+	case PrivAES:
+		binary.BigEndian.PutUint64(salt, s.salt.Add(1))
+		block, err := aes.NewCipher(s.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, plain)
+		return out, salt, nil
+
+	case PrivDES:
+		binary.BigEndian.PutUint32(salt, uint32(boots))
+		binary.BigEndian.PutUint32(salt[4:], uint32(s.salt.Add(1)))
+		block, err := des.NewCipher(s.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		padded := append([]byte(nil), plain...)
+		if r := len(padded) % des.BlockSize; r != 0 {
+			padded = append(padded, make([]byte, des.BlockSize-r)...)
+		}
+		out := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, s.desIV(salt)).CryptBlocks(out, padded)
+		return out, salt, nil
+	}
+
+	return nil, nil, fmt.Errorf("privacy is not configured")
+}
+
+// decrypt decrypts a scoped PDU. DES padding is left in place, after the
+// end of the BER sequence.
+func (s *security) decrypt(data, salt []byte, boots, engineTime int64) ([]byte, error) {
+	if len(salt) != 8 {
+		return nil, fmt.Errorf("invalid SNMPv3 privacy parameters")
+	}
+
+	switch s.priv {
+	case PrivAES:
+		block, err := aes.NewCipher(s.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, data)
+		return out, nil
+
+	case PrivDES:
+		if len(data)%des.BlockSize != 0 {
+			return nil, fmt.Errorf("invalid DES encrypted data length")
+		}
+		block, err := des.NewCipher(s.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, s.desIV(salt)).CryptBlocks(out, data)
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("privacy is not configured")
+}
+
+func aesIV(boots, engineTime int64, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+func (s *security) desIV(salt []byte) []byte {
+	iv := make([]byte, des.BlockSize)
+	for i := range iv {
+		iv[i] = s.privKey[8+i] ^ salt[i]
+	}
+	return iv
+}
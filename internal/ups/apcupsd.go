@@ -0,0 +1,209 @@
+package ups
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrApcupsdCommLost is returned when apcupsd itself lost contact with the UPS
+var ErrApcupsdCommLost = errors.New("apcupsd lost communication with the UPS")
+
+// apcStatusFlags maps apcupsd STATUS words to NUT ups.status flags
+var apcStatusFlags = map[string]string{
+	"ONLINE":      "OL",
+	"ONBATT":      "OB",
+	"LOWBATT":     "LB",
+	"CAL":         "CAL",
+	"TRIM":        "TRIM",
+	"BOOST":       "BOOST",
+	"OVERLOAD":    "OVER",
+	"REPLACEBATT": "RB",
+}
+
+// ApcupsdClient reads UPS status from the apcupsd Network Information
+// Server (NIS). Like apcaccess, it opens a connection per request.
+type ApcupsdClient struct {
+	host    string
+	upsName string
+	timeout time.Duration
+}
+
+// NewApcupsdClient creates a new apcupsd NIS client. upsName is only used
+// to name the UPS in its status.
+func NewApcupsdClient(host, upsName string) *ApcupsdClient {
+	return &ApcupsdClient{
+		host:    host,
+		upsName: upsName,
+		timeout: 10 * time.Second,
+	}
+}
+
+// Connect checks that the NIS server is reachable
+func (c *ApcupsdClient) Connect() error {
+	conn, err := net.DialTimeout("tcp", c.host, c.timeout)
+	if err != nil {
+		return fmt.Errorf("connecting to apcupsd: %w", err)
+	}
+	return conn.Close()
+}
+
+// Close is a no-op as no connection is kept open
+func (c *ApcupsdClient) Close() error {
+	return nil
+}
+
+// GetStatus retrieves current UPS status
+func (c *ApcupsdClient) GetStatus(ctx context.Context) (*Status, error) {
+	fields, err := c.StatusFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := apcStatus(fields["STATUS"])
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Name:      c.upsName,
+		Status:    flags,
+		Timestamp: time.Now(),
+	}
+	status.BatteryCharge, _ = apcNumber(fields["BCHARGE"])
+	status.Load, _ = apcNumber(fields["LOADPCT"])
+	if runtime, err := apcDuration(fields["TIMELEFT"]); err == nil {
+		status.Runtime = int(runtime.Seconds())
+	}
+
+	return status, nil
+}
+
+// StatusFields returns the output of the NIS status command as key/value
+// pairs, such as STATUS, BCHARGE or TIMELEFT
+func (c *ApcupsdClient) StatusFields(ctx context.Context) (map[string]string, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.host)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to apcupsd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	if err := writeNISRecord(conn, "status"); err != nil {
+		return nil, fmt.Errorf("sending command: %w", err)
+	}
+
+	fields := make(map[string]string)
+	for {
+		record, err := readNISRecord(conn)
+		if err != nil {
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+		if record == "" {
+			// A zero length record ends the response
+			return fields, nil
+		}
+
+		key, value, ok := strings.Cut(record, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+}
+
+// writeNISRecord writes a record prefixed with its 16-bit length
+func writeNISRecord(w io.Writer, record string) error {
+	buf := make([]byte, 2+len(record))
+	binary.BigEndian.PutUint16(buf, uint16(len(record)))
+	copy(buf[2:], record)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readNISRecord reads a length prefixed record
+func readNISRecord(r io.Reader) (string, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// apcStatus converts an apcupsd STATUS value such as "ONBATT LOWBATT" into
+// NUT status flags
+func apcStatus(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("apcupsd returned no STATUS")
+	}
+	if strings.Contains(value, "COMMLOST") {
+		return "", ErrApcupsdCommLost
+	}
+
+	var flags []string
+	if strings.Contains(value, "SHUTTING DOWN") {
+		flags = append(flags, "FSD")
+	}
+	for _, word := range strings.Fields(value) {
+		if flag, ok := apcStatusFlags[word]; ok {
+			flags = append(flags, flag)
+		}
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// apcNumber parses a value such as "100.0 Percent" as a rounded integer
+func apcNumber(value string) (int, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty value")
+	}
+	f, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(f + 0.5), nil
+}
+
+// apcDuration parses a value such as "45.5 Minutes"
+func apcDuration(value string) (time.Duration, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty value")
+	}
+	f, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	unit := time.Minute
+	if len(fields) > 1 {
+		switch strings.ToLower(fields[1]) {
+		case "seconds":
+			unit = time.Second
+		case "minutes":
+			unit = time.Minute
+		case "hours":
+			unit = time.Hour
+		default:
+			return 0, fmt.Errorf("unknown unit %q", fields[1])
+		}
+	}
+	return time.Duration(f * float64(unit)), nil
+}
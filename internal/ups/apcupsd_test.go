@@ -0,0 +1,102 @@
+package ups
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeNIS answers the apcupsd status command with a fixed output
+func fakeNIS(t *testing.T, lines []string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd, err := readNISRecord(conn)
+				if err != nil || cmd != "status" {
+					return
+				}
+				for _, line := range lines {
+					if err := writeNISRecord(conn, line+"\n"); err != nil {
+						return
+					}
+				}
+				_ = writeNISRecord(conn, "")
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestApcupsdStatus(t *testing.T) {
+	addr := fakeNIS(t, []string{
+		"APC      : 001,036,0878",
+		"UPSNAME  : rack-ups",
+		"STATUS   : ONBATT LOWBATT ",
+		"LINEV    : 0.0 Volts",
+		"LOADPCT  : 23.6 Percent",
+		"BCHARGE  : 18.0 Percent",
+		"TIMELEFT : 4.5 Minutes",
+		"END APC  : 2024-01-01 12:00:00 +0000",
+	})
+
+	c := NewApcupsdClient(addr, "rack")
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	status, err := c.GetStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if status.Name != "rack" || status.Status != "OB LB" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if status.BatteryCharge != 18 || status.Load != 24 || status.Runtime != 270 {
+		t.Errorf("Expected charge 18, load 24, runtime 270, got %+v", status)
+	}
+	if !status.IsOnBattery() || !status.IsLowBattery() {
+		t.Error("Expected on battery and low battery")
+	}
+}
+
+func TestApcupsdCommLost(t *testing.T) {
+	addr := fakeNIS(t, []string{"STATUS   : COMMLOST "})
+
+	_, err := NewApcupsdClient(addr, "rack").GetStatus(context.Background())
+	if !errors.Is(err, ErrApcupsdCommLost) {
+		t.Errorf("Expected comm lost error, got %v", err)
+	}
+}
+
+func TestApcStatus(t *testing.T) {
+	tests := map[string]string{
+		"ONLINE":               "OL",
+		"ONLINE REPLACEBATT":   "OL RB",
+		"ONBATT":               "OB",
+		"ONLINE TRIM OVERLOAD": "OL TRIM OVER",
+		"SHUTTING DOWN ONBATT": "FSD OB",
+		"ONLINE SLAVE":         "OL",
+	}
+	for value, expected := range tests {
+		got, err := apcStatus(value)
+		if err != nil || got != expected {
+			t.Errorf("apcStatus(%q) = %q (%v), expected %q", value, got, err, expected)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package ups
+
+import "context"
+
+// Supported UPS drivers
+const (
+	DriverNUT      = "nut"
+	DriverApcupsd  = "apcupsd"
+	DriverSNMP     = "snmp"
+	DriverSimulate = "simulate"
+)
+
+// Driver reads the status of a UPS from the daemon monitoring it, such as
+// NUT's upsd, apcupsd or an SNMP management card
+type Driver interface {
+	Connect() error
+	Close() error
+	GetStatus(ctx context.Context) (*Status, error)
+}
@@ -0,0 +1,183 @@
+package ups
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// estimateWindow is how far back samples are used to compute the
+	// discharge rate
+	estimateWindow = 5 * time.Minute
+	// minEstimateSpan and minEstimateSamples avoid predictions from a
+	// couple of readings of an integer charge
+	minEstimateSpan    = 30 * time.Second
+	minEstimateSamples = 3
+)
+
+// Estimate is the discharge trend of a UPS on battery and the predicted
+// time until each threshold is crossed. A zero duration means the
+// threshold is already crossed, a negative one that it cannot be predicted.
+type Estimate struct {
+	Rate float64 // Charge lost per minute, in percent
+
+	Empty     time.Duration // Until the battery is empty
+	Warning   time.Duration
+	Critical  time.Duration
+	Emergency time.Duration
+	// MinRuntime is the time until the reported runtime drops below
+	// Thresholds.MinRuntime
+	MinRuntime time.Duration
+}
+
+// Next returns the name of the next threshold to be crossed and the time
+// until then, or false when none is ahead
+func (e *Estimate) Next() (string, time.Duration, bool) {
+	name, next := "", time.Duration(-1)
+	for _, t := range []struct {
+		name string
+		eta  time.Duration
+	}{
+		{"warning", e.Warning},
+		{"critical", e.Critical},
+		{"min_runtime", e.MinRuntime},
+		{"emergency", e.Emergency},
+		{"empty", e.Empty},
+	} {
+		if t.eta > 0 && (next < 0 || t.eta < next) {
+			name, next = t.name, t.eta
+		}
+	}
+	return name, next, next > 0
+}
+
+// String summarizes the estimate, e.g. "-1.5%/min, critical in 4m10s,
+// empty in 20m"
+func (e *Estimate) String() string {
+	parts := []string{fmt.Sprintf("-%.1f%%/min", e.Rate)}
+	if name, eta, ok := e.Next(); ok && name != "empty" {
+		parts = append(parts, fmt.Sprintf("%s in %s", name, eta.Round(time.Second)))
+	}
+	if e.Empty > 0 {
+		parts = append(parts, fmt.Sprintf("empty in %s", e.Empty.Round(time.Second)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sample is a status reading kept by the estimator
+type sample struct {
+	at      time.Time
+	charge  float64
+	runtime float64 // Seconds, 0 when not reported
+}
+
+// Estimator keeps a sliding window of the statuses read while on battery
+// and fits a linear trend to the charge and runtime
+type Estimator struct {
+	window  time.Duration
+	samples []sample
+}
+
+// NewEstimator creates an estimator using the samples of the last window
+func NewEstimator(window time.Duration) *Estimator {
+	if window <= 0 {
+		window = estimateWindow
+	}
+	return &Estimator{window: window}
+}
+
+// Add records a status and returns the estimate against thresholds, or
+// nil while the UPS is not discharging or the samples are too few. The
+// window is cleared when the UPS is back on line power.
+func (e *Estimator) Add(status *Status, thresholds Thresholds) *Estimate {
+	if !status.IsOnBattery() {
+		e.samples = e.samples[:0]
+		return nil
+	}
+
+	at := status.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	e.samples = append(e.samples, sample{
+		at:      at,
+		charge:  float64(status.BatteryCharge),
+		runtime: float64(status.Runtime),
+	})
+	for len(e.samples) > 0 && at.Sub(e.samples[0].at) > e.window {
+		e.samples = e.samples[1:]
+	}
+
+	if len(e.samples) < minEstimateSamples || at.Sub(e.samples[0].at) < minEstimateSpan {
+		return nil
+	}
+	chargeSlope := e.slope(func(s sample) float64 { return s.charge })
+	if chargeSlope >= 0 {
+		return nil
+	}
+
+	rate := -chargeSlope // percent per second
+	until := func(threshold int) time.Duration {
+		left := float64(status.BatteryCharge - threshold)
+		if left <= 0 {
+			return 0
+		}
+		return time.Duration(left / rate * float64(time.Second))
+	}
+
+	threshold := func(level int) time.Duration {
+		if level <= 0 {
+			return -1
+		}
+		return until(level)
+	}
+
+	estimate := &Estimate{
+		Rate:       rate * 60,
+		Empty:      until(0),
+		Warning:    threshold(thresholds.Warning),
+		Critical:   threshold(thresholds.Critical),
+		Emergency:  threshold(thresholds.Emergency),
+		MinRuntime: -1,
+	}
+
+	// The reported runtime shrinks faster than the clock under load, so
+	// its own trend predicts the runtime threshold
+	if thresholds.MinRuntime > 0 && status.Runtime > 0 {
+		left := time.Duration(status.Runtime)*time.Second - thresholds.MinRuntime
+		runtimeSlope := e.slope(func(s sample) float64 { return s.runtime })
+		switch {
+		case left <= 0:
+			estimate.MinRuntime = 0
+		case runtimeSlope < 0:
+			estimate.MinRuntime = time.Duration(left.Seconds() / -runtimeSlope * float64(time.Second))
+		}
+	}
+
+	return estimate
+}
+
+// slope returns the least squares slope of value over time, per second
+func (e *Estimator) slope(value func(sample) float64) float64 {
+	n := float64(len(e.samples))
+	origin := e.samples[0].at
+
+	var sumT, sumV float64
+	for _, s := range e.samples {
+		sumT += s.at.Sub(origin).Seconds()
+		sumV += value(s)
+	}
+	meanT, meanV := sumT/n, sumV/n
+
+	var cov, variance float64
+	for _, s := range e.samples {
+		dt := s.at.Sub(origin).Seconds() - meanT
+		cov += dt * (value(s) - meanV)
+		variance += dt * dt
+	}
+	if variance == 0 {
+		return 0
+	}
+	return cov / variance
+}
@@ -0,0 +1,74 @@
+package ups
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimator(t *testing.T) {
+	thresholds := Thresholds{Warning: 50, Critical: 30, Emergency: 10, MinRuntime: 5 * time.Minute}
+	e := NewEstimator(time.Minute)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// 1% and 20s of runtime lost every 10s: 6%/min, runtime twice as fast
+	// as the clock
+	var estimate *Estimate
+	for i := 0; i <= 12; i++ {
+		estimate = e.Add(&Status{
+			Status:        "OB",
+			BatteryCharge: 60 - i,
+			Runtime:       900 - 20*i,
+			Timestamp:     start.Add(time.Duration(i) * 10 * time.Second),
+		}, thresholds)
+		if i < 3 && estimate != nil {
+			t.Fatalf("Expected no estimate from %d samples, got %+v", i+1, estimate)
+		}
+	}
+	if len(e.samples) != 7 {
+		t.Errorf("Expected the window to keep 7 samples, got %d", len(e.samples))
+	}
+	if estimate == nil {
+		t.Fatal("Expected an estimate")
+	}
+
+	// At 48% and 660s runtime
+	approx := func(name string, got, expected time.Duration) {
+		if d := got - expected; d < -time.Second || d > time.Second {
+			t.Errorf("Expected %s in %s, got %s", name, expected, got)
+		}
+	}
+	if estimate.Rate < 5.99 || estimate.Rate > 6.01 {
+		t.Errorf("Expected 6%%/min, got %f", estimate.Rate)
+	}
+	if estimate.Warning != 0 {
+		t.Errorf("Expected warning already crossed, got %s", estimate.Warning)
+	}
+	approx("critical", estimate.Critical, 3*time.Minute)
+	approx("emergency", estimate.Emergency, 380*time.Second)
+	approx("empty", estimate.Empty, 8*time.Minute)
+	approx("min_runtime", estimate.MinRuntime, 3*time.Minute)
+
+	if name, eta, ok := estimate.Next(); !ok || name != "critical" || eta != estimate.Critical {
+		t.Errorf("Expected critical next, got %s in %s", name, eta)
+	}
+	if got := estimate.String(); got != "-6.0%/min, critical in 3m0s, empty in 8m0s" {
+		t.Errorf("Unexpected summary %q", got)
+	}
+
+	// Back on line power clears the window
+	if e.Add(&Status{Status: "OL CHRG", BatteryCharge: 48, Timestamp: start.Add(3 * time.Minute)}, thresholds) != nil {
+		t.Error("Expected no estimate on line power")
+	}
+	if len(e.samples) != 0 {
+		t.Errorf("Expected no samples left, got %d", len(e.samples))
+	}
+
+	// A steady charge cannot be predicted
+	for i := 0; i < 6; i++ {
+		estimate = e.Add(&Status{Status: "OB", BatteryCharge: 100,
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Second)}, thresholds)
+	}
+	if estimate != nil {
+		t.Errorf("Expected no estimate without discharge, got %+v", estimate)
+	}
+}
@@ -0,0 +1,295 @@
+package ups
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy decides when a group of UPS units puts the host at risk
+type Policy string
+
+const (
+	PolicyAny    Policy = "any"    // Any single UPS at risk
+	PolicyAll    Policy = "all"    // Every UPS at risk
+	PolicyQuorum Policy = "quorum" // At least Quorum UPS units at risk
+)
+
+// Source is a monitored UPS that can be aggregated in a Group
+type Source interface {
+	Start(ctx context.Context) error
+	Stop()
+	Events() <-chan Event
+	Status() <-chan *Status
+}
+
+// Member is a labelled UPS source within a group
+type Member struct {
+	Label  string
+	Source Source
+}
+
+// eventLevels ranks events by how much they put the host at risk
+var eventLevels = map[EventType]int{
+	EventPowerRestored:   0,
+	EventPowerLost:       1,
+	EventLowBattery:      2,
+	EventCriticalBattery: 3,
+	EventEmergency:       4,
+}
+
+// levelEvents maps a risk level back to the event announcing it
+var levelEvents = []EventType{
+	EventPowerRestored,
+	EventPowerLost,
+	EventLowBattery,
+	EventCriticalBattery,
+	EventEmergency,
+}
+
+// Group monitors several UPS units independently and emits a single
+// event stream according to a redundancy policy
+type Group struct {
+	members []Member
+	policy  Policy
+	quorum  int
+
+	mu        sync.Mutex
+	levels    map[string]int
+	aggregate int
+
+	statusCh chan *Status
+	eventCh  chan Event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewGroup creates a new UPS group. quorum is only used with PolicyQuorum.
+func NewGroup(policy Policy, quorum int, members []Member) *Group {
+	if policy == "" {
+		policy = PolicyAny
+	}
+
+	return &Group{
+		members:  members,
+		policy:   policy,
+		quorum:   quorum,
+		levels:   make(map[string]int),
+		statusCh: make(chan *Status, 10*len(members)),
+		eventCh:  make(chan Event, 10),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start starts every member source
+func (g *Group) Start(ctx context.Context) error {
+	for i, m := range g.members {
+		if err := m.Source.Start(ctx); err != nil {
+			for _, started := range g.members[:i] {
+				started.Source.Stop()
+			}
+			return fmt.Errorf("%s: %w", m.Label, err)
+		}
+	}
+
+	for _, m := range g.members {
+		go g.forward(ctx, m)
+	}
+
+	return nil
+}
+
+// Stop stops every member source
+func (g *Group) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.stopCh)
+		for _, m := range g.members {
+			m.Source.Stop()
+		}
+	})
+}
+
+// Events returns the aggregated event channel
+func (g *Group) Events() <-chan Event {
+	return g.eventCh
+}
+
+// Status returns the status updates of all members
+func (g *Group) Status() <-chan *Status {
+	return g.statusCh
+}
+
+// Members returns the labels of the UPS units in the group
+func (g *Group) Members() []string {
+	labels := make([]string, len(g.members))
+	for i, m := range g.members {
+		labels[i] = m.Label
+	}
+	return labels
+}
+
+// SetThresholds changes the thresholds of the member with the given label.
+// The member source must support it, as *Monitor does.
+func (g *Group) SetThresholds(label string, t Thresholds) error {
+	for _, m := range g.members {
+		if m.Label != label {
+			continue
+		}
+		setter, ok := m.Source.(interface{ SetThresholds(Thresholds) })
+		if !ok {
+			return fmt.Errorf("%s: thresholds cannot be changed at runtime", label)
+		}
+		setter.SetThresholds(t)
+		return nil
+	}
+	return fmt.Errorf("unknown UPS: %s", label)
+}
+
+// LastPoll returns the oldest last poll time among the members that report
+// it, or the zero time if none does
+func (g *Group) LastPoll() time.Time {
+	var oldest time.Time
+	for _, m := range g.members {
+		poller, ok := m.Source.(interface{ LastPoll() time.Time })
+		if !ok {
+			continue
+		}
+		if t := poller.LastPoll(); oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+func (g *Group) forward(ctx context.Context, m Member) {
+	events := m.Source.Events()
+	statuses := m.Source.Status()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.stopCh:
+			return
+		case status := <-statuses:
+			tagged := *status
+			tagged.Source = m.Label
+			select {
+			case g.statusCh <- &tagged:
+			default:
+			}
+		case event := <-events:
+			g.HandleEvent(m.Label, event)
+		}
+	}
+}
+
+// HandleEvent records a member event and emits the aggregated event.
+// Communication events are passed through as they do not change the risk
+// level by themselves.
+func (g *Group) HandleEvent(label string, event Event) {
+	level, ok := eventLevels[event.Type]
+	if !ok {
+		switch event.Type {
+		case EventCommLost, EventNoComm, EventCommRestored:
+			if len(g.members) > 1 {
+				event.Message = fmt.Sprintf("%s: %s", label, event.Message)
+			}
+			g.emit(event)
+		}
+		return
+	}
+
+	g.mu.Lock()
+	g.levels[label] = level
+	previous := g.aggregate
+	g.aggregate = g.aggregateLevel()
+	current := g.aggregate
+	atRisk := g.countAtLeast(current)
+	g.mu.Unlock()
+
+	// Like a single monitor, risk events repeat while the host is at risk,
+	// but power restored is only announced once
+	if current == 0 && previous == 0 {
+		return
+	}
+
+	out := Event{
+		Type:      levelEvents[current],
+		Status:    event.Status,
+		Timestamp: event.Timestamp,
+		Message:   event.Message,
+	}
+	if len(g.members) > 1 {
+		out.Message = fmt.Sprintf("%s: %s (%d/%d UPS, policy %s)",
+			label, event.Message, atRisk, len(g.members), g.policy)
+	}
+	g.emit(out)
+}
+
+func (g *Group) emit(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	select {
+	case g.eventCh <- event:
+	default:
+		// Channel full, drop event
+	}
+}
+
+// CommStates returns the communication state of every member that
+// reports it, by label
+func (g *Group) CommStates() map[string]CommState {
+	states := make(map[string]CommState)
+	for _, m := range g.members {
+		if s, ok := m.Source.(interface{ CommState() CommState }); ok {
+			states[m.Label] = s.CommState()
+		}
+	}
+	return states
+}
+
+// aggregateLevel returns the highest risk level reached by enough members
+// to satisfy the policy. Caller must hold g.mu.
+func (g *Group) aggregateLevel() int {
+	levels := make([]int, 0, len(g.members))
+	for _, m := range g.members {
+		levels = append(levels, g.levels[m.Label])
+	}
+	if len(levels) == 0 {
+		return 0
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+
+	needed := 1
+	switch g.policy {
+	case PolicyAll:
+		needed = len(levels)
+	case PolicyQuorum:
+		needed = g.quorum
+	}
+	if needed < 1 {
+		needed = 1
+	}
+	if needed > len(levels) {
+		needed = len(levels)
+	}
+
+	return levels[needed-1]
+}
+
+// countAtLeast counts members at or above the given level. Caller must
+// hold g.mu.
+func (g *Group) countAtLeast(level int) int {
+	count := 0
+	for _, m := range g.members {
+		if g.levels[m.Label] >= level {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,111 @@
+package ups
+
+import (
+	"testing"
+)
+
+func TestGroupPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		quorum   int
+		events   map[string]EventType
+		expected EventType
+	}{
+		{
+			name:     "any with one UPS critical",
+			policy:   PolicyAny,
+			events:   map[string]EventType{"a": EventCriticalBattery},
+			expected: EventCriticalBattery,
+		},
+		{
+			name:     "all with one UPS critical",
+			policy:   PolicyAll,
+			events:   map[string]EventType{"a": EventCriticalBattery},
+			expected: "",
+		},
+		{
+			name:     "all takes the least severe level",
+			policy:   PolicyAll,
+			events:   map[string]EventType{"a": EventCriticalBattery, "b": EventPowerLost, "c": EventLowBattery},
+			expected: EventPowerLost,
+		},
+		{
+			name:     "quorum of two",
+			policy:   PolicyQuorum,
+			quorum:   2,
+			events:   map[string]EventType{"a": EventEmergency, "b": EventCriticalBattery},
+			expected: EventCriticalBattery,
+		},
+		{
+			name:     "quorum not reached",
+			policy:   PolicyQuorum,
+			quorum:   2,
+			events:   map[string]EventType{"a": EventEmergency},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGroup(tt.policy, tt.quorum, testMembers("a", "b", "c"))
+
+			var last EventType
+			for _, label := range []string{"a", "b", "c"} {
+				ev, ok := tt.events[label]
+				if !ok {
+					continue
+				}
+				g.HandleEvent(label, Event{Type: ev})
+				last = drainLast(g)
+			}
+
+			if last != tt.expected {
+				t.Errorf("Expected aggregated event %q, got %q", tt.expected, last)
+			}
+		})
+	}
+}
+
+func TestGroupPowerRestored(t *testing.T) {
+	g := NewGroup(PolicyAny, 0, testMembers("a", "b"))
+
+	g.HandleEvent("a", Event{Type: EventPowerLost})
+	g.HandleEvent("b", Event{Type: EventPowerLost})
+	g.HandleEvent("a", Event{Type: EventPowerRestored})
+	if last := drainLast(g); last != EventPowerLost {
+		t.Errorf("Expected host still on battery, got %q", last)
+	}
+
+	g.HandleEvent("b", Event{Type: EventPowerRestored})
+	if last := drainLast(g); last != EventPowerRestored {
+		t.Errorf("Expected power restored, got %q", last)
+	}
+
+	// Restored is only announced once
+	g.HandleEvent("b", Event{Type: EventPowerRestored})
+	if last := drainLast(g); last != "" {
+		t.Errorf("Expected no further event, got %q", last)
+	}
+}
+
+func testMembers(labels ...string) []Member {
+	members := make([]Member, len(labels))
+	for i, l := range labels {
+		members[i] = Member{Label: l}
+	}
+	return members
+}
+
+// drainLast empties the group event channel and returns the last event type
+func drainLast(g *Group) EventType {
+	var last EventType
+	for {
+		select {
+		case ev := <-g.Events():
+			last = ev.Type
+		default:
+			return last
+		}
+	}
+}
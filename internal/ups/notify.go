@@ -0,0 +1,51 @@
+package ups
+
+import "strings"
+
+// NotifyType is an event type passed by upsmon to its NOTIFYCMD
+type NotifyType string
+
+const (
+	NotifyOnline   NotifyType = "ONLINE"
+	NotifyOnBatt   NotifyType = "ONBATT"
+	NotifyLowBatt  NotifyType = "LOWBATT"
+	NotifyFSD      NotifyType = "FSD"
+	NotifyCommOK   NotifyType = "COMMOK"
+	NotifyCommBad  NotifyType = "COMMBAD"
+	NotifyShutdown NotifyType = "SHUTDOWN"
+	NotifyReplBatt NotifyType = "REPLBATT"
+	NotifyNoComm   NotifyType = "NOCOMM"
+)
+
+// notifyEvents maps the NOTIFYCMD types that change the power state to
+// the equivalent monitor event
+var notifyEvents = map[NotifyType]struct {
+	event   EventType
+	message string
+}{
+	NotifyOnline:  {EventPowerRestored, "Power restored"},
+	NotifyOnBatt:  {EventPowerLost, "Power lost, running on battery"},
+	NotifyLowBatt: {EventCriticalBattery, "UPS reports low battery"},
+	NotifyFSD:     {EventEmergency, "UPS forced shutdown (FSD)"},
+}
+
+// ParseNotifyType parses a NOTIFYCMD event type, case-insensitively
+func ParseNotifyType(s string) (NotifyType, bool) {
+	t := NotifyType(strings.ToUpper(strings.TrimSpace(s)))
+	switch t {
+	case NotifyOnline, NotifyOnBatt, NotifyLowBatt, NotifyFSD, NotifyCommOK,
+		NotifyCommBad, NotifyShutdown, NotifyReplBatt, NotifyNoComm:
+		return t, true
+	}
+	return "", false
+}
+
+// Event returns the monitor event equivalent to the notification, or false
+// when it is informational only (communication, battery replacement...)
+func (t NotifyType) Event() (Event, bool) {
+	e, ok := notifyEvents[t]
+	if !ok {
+		return Event{}, false
+	}
+	return Event{Type: e.event, Message: e.message}, true
+}
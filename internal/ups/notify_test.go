@@ -0,0 +1,32 @@
+package ups
+
+import "testing"
+
+func TestParseNotifyType(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected NotifyType
+		ok       bool
+		event    EventType
+	}{
+		{"ONBATT", NotifyOnBatt, true, EventPowerLost},
+		{"online", NotifyOnline, true, EventPowerRestored},
+		{" LOWBATT ", NotifyLowBatt, true, EventCriticalBattery},
+		{"FSD", NotifyFSD, true, EventEmergency},
+		{"REPLBATT", NotifyReplBatt, true, ""},
+		{"UPS eaton@localhost on battery", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseNotifyType(tt.input)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("ParseNotifyType(%q): expected %q/%v, got %q/%v", tt.input, tt.expected, tt.ok, got, ok)
+			continue
+		}
+
+		ev, isEvent := got.Event()
+		if isEvent != (tt.event != "") || ev.Type != tt.event {
+			t.Errorf("%s.Event(): expected %q, got %q/%v", got, tt.event, ev.Type, isEvent)
+		}
+	}
+}
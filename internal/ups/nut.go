@@ -3,6 +3,8 @@ package ups
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -14,11 +16,15 @@ import (
 // Status represents UPS status
 type Status struct {
 	Name          string
+	Source        string // Label of the UPS within a Group, if any
 	Status        string // OL (online), OB (on battery), LB (low battery)
 	BatteryCharge int    // Percentage
 	Runtime       int    // Seconds remaining
 	Load          int    // Percentage
 	Timestamp     time.Time
+	// Estimate is the discharge trend while on battery, nil until enough
+	// samples are known
+	Estimate *Estimate
 }
 
 // IsOnline returns true if UPS is on line power
@@ -36,52 +42,159 @@ func (s *Status) IsLowBattery() bool {
 	return strings.Contains(s.Status, "LB")
 }
 
+// IsForcedShutdown returns true if a forced shutdown (FSD) was raised
+func (s *Status) IsForcedShutdown() bool {
+	return strings.Contains(s.Status, "FSD")
+}
+
+// Login roles registering the client with upsd
+const (
+	RolePrimary   = "primary"
+	RoleSecondary = "secondary"
+)
+
+// Options configures authentication and encryption of a NUT client
+type Options struct {
+	Username string
+	Password string
+	// Role logs in to the UPS as a primary or secondary upsmon so that
+	// upsd counts this host among its clients. Requires credentials.
+	Role string
+	// TLS upgrades the connection with STARTTLS when set
+	TLS *tls.Config
+}
+
 // Client is a NUT (Network UPS Tools) client
 type Client struct {
-	host    string
-	upsName string
-	conn    net.Conn
-	mu      sync.Mutex
-	timeout time.Duration
+	host     string
+	upsName  string
+	opts     Options
+	conn     net.Conn
+	r        *bufio.Reader
+	mu       sync.Mutex
+	timeout  time.Duration
+	loggedIn bool
 }
 
-// NewClient creates a new NUT client
+// NewClient creates a new NUT client connecting anonymously
 func NewClient(host, upsName string) *Client {
+	return NewClientWithOptions(host, upsName, Options{})
+}
+
+// NewClientWithOptions creates a new NUT client with credentials, login
+// role and STARTTLS
+func NewClientWithOptions(host, upsName string, opts Options) *Client {
 	return &Client{
 		host:    host,
 		upsName: upsName,
+		opts:    opts,
 		timeout: 10 * time.Second,
 	}
 }
 
-// Connect establishes connection to NUT server
+// Connect establishes connection to NUT server, upgrades it with STARTTLS
+// and logs in as configured
 func (c *Client) Connect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.conn != nil {
+		// Reconnecting: drop the previous, likely broken, connection
+		c.conn.Close()
+		c.conn = nil
+	}
+
 	conn, err := net.DialTimeout("tcp", c.host, c.timeout)
 	if err != nil {
 		return fmt.Errorf("connecting to NUT server: %w", err)
 	}
-
+	_ = conn.SetDeadline(time.Now().Add(c.timeout))
 	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	c.loggedIn = false
+
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		c.conn = nil
+		return err
+	}
+
+	_ = c.conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// handshake runs STARTTLS, authentication and login. Caller must hold c.mu.
+func (c *Client) handshake() error {
+	if c.opts.TLS != nil {
+		if _, err := c.roundTrip("STARTTLS"); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+		tlsConn := tls.Client(c.conn, c.opts.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake: %w", err)
+		}
+		c.conn = tlsConn
+		c.r = bufio.NewReader(tlsConn)
+	}
+
+	if c.opts.Username != "" {
+		if _, err := c.roundTrip("USERNAME " + quote(c.opts.Username)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+		if _, err := c.roundTrip("PASSWORD " + quote(c.opts.Password)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	switch c.opts.Role {
+	case "":
+	case RolePrimary, RoleSecondary:
+		if _, err := c.roundTrip("LOGIN " + c.upsName); err != nil {
+			return fmt.Errorf("logging in to %s: %w", c.upsName, err)
+		}
+		c.loggedIn = true
+		if c.opts.Role == RolePrimary {
+			_, err := c.roundTrip("PRIMARY " + c.upsName)
+			if errors.Is(err, ErrUnknownCommand) {
+				// upsd older than 2.8 only knows the former name
+				_, err = c.roundTrip("MASTER " + c.upsName)
+			}
+			if err != nil {
+				return fmt.Errorf("claiming primary on %s: %w", c.upsName, err)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid NUT role: %s", c.opts.Role)
+	}
+
 	return nil
 }
 
-// Close closes the connection
+// Close logs out and closes the connection
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.conn != nil {
-		return c.conn.Close()
+	if c.conn == nil {
+		return nil
 	}
-	return nil
+
+	if c.loggedIn {
+		// Tell upsd we left on purpose rather than lost the connection
+		_ = c.conn.SetDeadline(time.Now().Add(time.Second))
+		_, _ = c.conn.Write([]byte("LOGOUT\n"))
+		c.loggedIn = false
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	c.r = nil
+	return err
 }
 
 // GetStatus retrieves current UPS status
 func (c *Client) GetStatus(ctx context.Context) (*Status, error) {
-	vars, err := c.getVariables(ctx)
+	vars, err := c.ListVars(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -107,77 +220,134 @@ func (c *Client) GetStatus(ctx context.Context) (*Status, error) {
 	return status, nil
 }
 
-// getVariables retrieves all UPS variables
-func (c *Client) getVariables(ctx context.Context) (map[string]string, error) {
+// InstCmd runs an instant command, such as "shutdown.return", on the UPS
+func (c *Client) InstCmd(ctx context.Context, cmd string) error {
+	return c.simpleCommand(ctx, fmt.Sprintf("INSTCMD %s %s", c.upsName, cmd))
+}
+
+// SetVar changes a writable UPS variable, such as "ups.delay.shutdown"
+func (c *Client) SetVar(ctx context.Context, name, value string) error {
+	return c.simpleCommand(ctx, fmt.Sprintf("SET VAR %s %s %s", c.upsName, name, quote(value)))
+}
+
+// FSD raises the forced shutdown flag on the UPS. upsd only accepts it from
+// a primary upsmon.
+func (c *Client) FSD(ctx context.Context) error {
+	return c.simpleCommand(ctx, fmt.Sprintf("FSD %s", c.upsName))
+}
+
+// simpleCommand sends a command answered by a single OK or ERR line
+func (c *Client) simpleCommand(ctx context.Context, cmd string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if err := c.begin(ctx); err != nil {
+		return err
+	}
+	_, err := c.roundTrip(cmd)
+	return err
+}
+
+// begin checks the connection and sets the deadline of the next exchange
+// from ctx. Caller must hold c.mu.
+func (c *Client) begin(ctx context.Context) error {
 	if c.conn == nil {
-		return nil, fmt.Errorf("not connected")
+		return fmt.Errorf("not connected")
 	}
 
-	// Set deadline from context
 	if deadline, ok := ctx.Deadline(); ok {
 		_ = c.conn.SetDeadline(deadline)
 	} else {
 		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
 	}
+	return nil
+}
 
-	// Request all variables
-	cmd := fmt.Sprintf("LIST VAR %s\n", c.upsName)
-	if _, err := c.conn.Write([]byte(cmd)); err != nil {
-		return nil, fmt.Errorf("sending command: %w", err)
+// roundTrip sends a command and reads its single line response, which
+// must start with OK. Caller must hold c.mu.
+func (c *Client) roundTrip(cmd string) (string, error) {
+	if err := c.send(cmd); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
 	}
 
-	vars := make(map[string]string)
-	scanner := bufio.NewScanner(c.conn)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if strings.HasPrefix(line, "BEGIN LIST VAR") {
-			continue
-		}
-		if strings.HasPrefix(line, "END LIST VAR") {
-			break
-		}
-		if strings.HasPrefix(line, "ERR") {
-			return nil, fmt.Errorf("NUT error: %s", line)
-		}
-
-		// Parse: VAR upsname varname "value"
-		if strings.HasPrefix(line, "VAR ") {
-			parts := strings.SplitN(line, " ", 4)
-			if len(parts) >= 4 {
-				varName := parts[2]
-				varValue := strings.Trim(parts[3], "\"")
-				vars[varName] = varValue
-			}
-		}
+	if err := parseError(line); err != nil {
+		return "", err
+	}
+	if line != "OK" && !strings.HasPrefix(line, "OK ") {
+		return "", fmt.Errorf("unexpected NUT response to %s: %q", strings.Fields(cmd)[0], line)
 	}
+	return line, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
+// send writes a command line. Caller must hold c.mu.
+func (c *Client) send(cmd string) error {
+	if _, err := c.conn.Write([]byte(cmd + "\n")); err != nil {
+		return fmt.Errorf("sending command: %w", err)
 	}
+	return nil
+}
 
-	return vars, nil
+// readLine reads a response line without its line ending. Caller must
+// hold c.mu.
+func (c *Client) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
+// CommState is the state of the communication with a monitored UPS, named
+// after the matching upsmon notifications
+type CommState string
+
+const (
+	CommOK  CommState = "OK"
+	CommBad CommState = "COMMBAD" // Polls failing, reconnecting
+	NoComm  CommState = "NOCOMM"  // Polls failing for a prolonged period
+)
+
+const (
+	// reconnectMin and reconnectMax bound the exponential backoff between
+	// attempts to reconnect to upsd
+	reconnectMin = time.Second
+	reconnectMax = 30 * time.Second
+
+	// defaultNoComm is how long polls fail before NOCOMM when
+	// Thresholds.CommLoss is not set
+	defaultNoComm = 5 * time.Minute
+)
+
 // Monitor continuously monitors UPS status and sends updates to channel
 type Monitor struct {
-	client     *Client
+	client     Driver
 	interval   time.Duration
+	mu         sync.Mutex
 	thresholds Thresholds
+	lastPoll   time.Time
+	comm       CommState
+	commLostAt time.Time
+	estimator  *Estimator
 	statusCh   chan *Status
 	eventCh    chan Event
 	stopCh     chan struct{}
+	onError    func(error)
 }
 
 // Thresholds for battery levels
 type Thresholds struct {
-	Warning   int // Notify at this level
-	Critical  int // Start shutdown at this level
-	Emergency int // Force immediate shutdown
+	Warning    int           // Notify at this level
+	Critical   int           // Start shutdown at this level
+	Emergency  int           // Force immediate shutdown
+	MinRuntime time.Duration // Start shutdown below this runtime (0 = disabled)
+	// CommLoss assumes the worst for a UPS last seen on battery once it
+	// has not answered for this long, and reports it critical (0 = keep
+	// waiting for it)
+	CommLoss time.Duration
 }
 
 // Event types
@@ -189,6 +359,9 @@ const (
 	EventLowBattery      EventType = "LOW_BATTERY"
 	EventCriticalBattery EventType = "CRITICAL_BATTERY"
 	EventEmergency       EventType = "EMERGENCY"
+	EventCommLost        EventType = "COMM_LOST"
+	EventNoComm          EventType = "NO_COMM"
+	EventCommRestored    EventType = "COMM_RESTORED"
 )
 
 // Event represents a UPS event
@@ -200,11 +373,13 @@ type Event struct {
 }
 
 // NewMonitor creates a new UPS monitor
-func NewMonitor(client *Client, thresholds Thresholds) *Monitor {
+func NewMonitor(client Driver, thresholds Thresholds) *Monitor {
 	return &Monitor{
 		client:     client,
 		interval:   5 * time.Second,
 		thresholds: thresholds,
+		comm:       CommOK,
+		estimator:  NewEstimator(estimateWindow),
 		statusCh:   make(chan *Status, 10),
 		eventCh:    make(chan Event, 10),
 		stopCh:     make(chan struct{}),
@@ -217,11 +392,46 @@ func (m *Monitor) Start(ctx context.Context) error {
 		return err
 	}
 
+	m.markPolled()
 	go m.monitorLoop(ctx)
 
 	return nil
 }
 
+// OnPollError registers fn to be called whenever polling the UPS fails.
+// It must be set before Start.
+func (m *Monitor) OnPollError(fn func(error)) {
+	m.onError = fn
+}
+
+// SetThresholds replaces the thresholds used from the next poll on
+func (m *Monitor) SetThresholds(t Thresholds) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.thresholds = t
+}
+
+// CommState returns the state of the communication with the UPS
+func (m *Monitor) CommState() CommState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.comm
+}
+
+// LastPoll returns when the UPS was last polled, successfully or not. It
+// stops advancing if the monitor loop hangs.
+func (m *Monitor) LastPoll() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastPoll
+}
+
+func (m *Monitor) markPolled() {
+	m.mu.Lock()
+	m.lastPoll = time.Now()
+	m.mu.Unlock()
+}
+
 // Stop stops monitoring
 func (m *Monitor) Stop() {
 	close(m.stopCh)
@@ -239,10 +449,12 @@ func (m *Monitor) Status() <-chan *Status {
 }
 
 func (m *Monitor) monitorLoop(ctx context.Context) {
-	ticker := time.NewTicker(m.interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(m.interval)
+	defer timer.Stop()
 
 	var lastStatus *Status
+	connected := true
+	backoff := time.Duration(0)
 
 	for {
 		select {
@@ -250,29 +462,135 @@ func (m *Monitor) monitorLoop(ctx context.Context) {
 			return
 		case <-m.stopCh:
 			return
-		case <-ticker.C:
-			status, err := m.client.GetStatus(ctx)
-			if err != nil {
-				// TODO: Log error, maybe emit event
+		case <-timer.C:
+		}
+
+		if !connected {
+			if err := m.client.Connect(); err != nil {
+				m.markPolled()
+				m.commFailed(err, lastStatus)
+				backoff = min(backoff*2, reconnectMax)
+				timer.Reset(backoff)
 				continue
 			}
-
-			// Send status update
 			select {
-			case m.statusCh <- status:
+			case <-m.stopCh:
+				// Stopped while reconnecting
+				m.client.Close()
+				return
 			default:
 			}
+			connected = true
+		}
+
+		status, err := m.client.GetStatus(ctx)
+		m.markPolled()
+		if err != nil {
+			m.commFailed(err, lastStatus)
 
-			// Check for events
-			m.checkEvents(status, lastStatus)
-			lastStatus = status
+			var nutErr *Error
+			if errors.As(err, &nutErr) {
+				// upsd answered, e.g. DATA-STALE: the driver lost the UPS
+				timer.Reset(m.interval)
+				continue
+			}
+			// The connection is broken, e.g. upsd restarted
+			m.client.Close()
+			connected = false
+			backoff = reconnectMin
+			timer.Reset(backoff)
+			continue
+		}
+		m.commRestored(status)
+		m.estimate(status)
+
+		// Send status update
+		select {
+		case m.statusCh <- status:
+		default:
 		}
+
+		// Check for events
+		m.checkEvents(status, lastStatus)
+		lastStatus = status
+		timer.Reset(m.interval)
+	}
+}
+
+// commFailed records a failed poll, moving to COMMBAD then NOCOMM, and
+// reports a UPS last seen on battery as critical once Thresholds.CommLoss
+// has elapsed
+func (m *Monitor) commFailed(err error, last *Status) {
+	if m.onError != nil {
+		m.onError(err)
+	}
+
+	m.mu.Lock()
+	thresholds := m.thresholds
+	lost := m.comm == CommOK
+	if lost {
+		m.comm = CommBad
+		m.commLostAt = time.Now()
+	}
+	elapsed := time.Since(m.commLostAt)
+	noCommAfter := thresholds.CommLoss
+	if noCommAfter <= 0 {
+		noCommAfter = defaultNoComm
+	}
+	noComm := m.comm == CommBad && elapsed >= noCommAfter
+	if noComm {
+		m.comm = NoComm
+	}
+	m.mu.Unlock()
+
+	if lost {
+		m.emitEvent(EventCommLost, last, fmt.Sprintf("Communication with UPS lost: %v", err))
+	}
+	if noComm {
+		m.emitEvent(EventNoComm, last, fmt.Sprintf("No communication with UPS for %s", elapsed.Round(time.Second)))
+	}
+	if thresholds.CommLoss > 0 && elapsed >= thresholds.CommLoss && last != nil && last.IsOnBattery() {
+		m.emitEvent(EventCriticalBattery, last, fmt.Sprintf("No communication with UPS for %s while on battery",
+			elapsed.Round(time.Second)))
 	}
 }
 
+// commRestored leaves COMMBAD or NOCOMM after a successful poll
+func (m *Monitor) commRestored(status *Status) {
+	m.mu.Lock()
+	if m.comm == CommOK {
+		m.mu.Unlock()
+		return
+	}
+	m.comm = CommOK
+	elapsed := time.Since(m.commLostAt)
+	m.mu.Unlock()
+
+	m.emitEvent(EventCommRestored, status, fmt.Sprintf("Communication with UPS restored after %s",
+		elapsed.Round(time.Second)))
+}
+
+// estimate adds the discharge trend to a status read on battery
+func (m *Monitor) estimate(status *Status) {
+	m.mu.Lock()
+	thresholds := m.thresholds
+	m.mu.Unlock()
+
+	status.Estimate = m.estimator.Add(status, thresholds)
+}
+
 func (m *Monitor) checkEvents(current, last *Status) {
+	m.mu.Lock()
+	thresholds := m.thresholds
+	m.mu.Unlock()
+
 	// Power transition events
-	if last != nil {
+	if last == nil {
+		// Already on battery when monitoring started
+		if current.IsOnBattery() {
+			m.emitEvent(EventPowerLost, current, "Running on battery")
+		}
+	} else {
 		if last.IsOnline() && current.IsOnBattery() {
 			m.emitEvent(EventPowerLost, current, "Power lost, running on battery")
 		}
@@ -281,18 +599,39 @@ func (m *Monitor) checkEvents(current, last *Status) {
 		}
 	}
 
+	// Forced shutdown raised by the UPS or the primary upsmon
+	if current.IsForcedShutdown() {
+		m.emitEvent(EventEmergency, current, "UPS forced shutdown (FSD)")
+		return
+	}
+
 	// Battery level events
 	if current.IsOnBattery() {
-		if current.BatteryCharge <= m.thresholds.Emergency {
+		if current.BatteryCharge <= thresholds.Emergency {
 			m.emitEvent(EventEmergency, current, fmt.Sprintf("EMERGENCY: Battery at %d%%", current.BatteryCharge))
-		} else if current.BatteryCharge <= m.thresholds.Critical {
+		} else if current.IsLowBattery() {
+			m.emitEvent(EventCriticalBattery, current, "UPS reports low battery")
+		} else if current.BatteryCharge <= thresholds.Critical {
 			m.emitEvent(EventCriticalBattery, current, fmt.Sprintf("Critical battery: %d%%", current.BatteryCharge))
-		} else if current.BatteryCharge <= m.thresholds.Warning {
+		} else if runtimeBelowThreshold(current, thresholds.MinRuntime) {
+			m.emitEvent(EventCriticalBattery, current, fmt.Sprintf("Battery runtime %ds below required %s",
+				current.Runtime, thresholds.MinRuntime))
+		} else if current.BatteryCharge <= thresholds.Warning {
 			m.emitEvent(EventLowBattery, current, fmt.Sprintf("Low battery: %d%%", current.BatteryCharge))
 		}
 	}
 }
 
+// runtimeBelowThreshold reports whether the remaining runtime is too short
+// to complete the shutdown sequence. A runtime of 0 means the UPS does not
+// report battery.runtime.
+func runtimeBelowThreshold(status *Status, minRuntime time.Duration) bool {
+	if minRuntime <= 0 || status.Runtime <= 0 {
+		return false
+	}
+	return time.Duration(status.Runtime)*time.Second < minRuntime
+}
+
 func (m *Monitor) emitEvent(eventType EventType, status *Status, message string) {
 	event := Event{
 		Type:      eventType,
@@ -0,0 +1,417 @@
+package ups
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUpsd answers NUT commands from a fixed table and records them
+type fakeUpsd struct {
+	ln        net.Listener
+	responses map[string]string
+	tls       *tls.Config // Accepts STARTTLS when set
+
+	mu       sync.Mutex
+	commands []string
+	conns    []net.Conn
+}
+
+func newFakeUpsd(t *testing.T, responses map[string]string) *fakeUpsd {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeUpsd{ln: ln, responses: responses}
+	go f.serve()
+	return f
+}
+
+func (f *fakeUpsd) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeUpsd) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeUpsd) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.mu.Unlock()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		f.mu.Lock()
+		f.commands = append(f.commands, line)
+		f.mu.Unlock()
+
+		if line == "STARTTLS" && f.tls != nil {
+			if _, err := conn.Write([]byte("OK STARTTLS\n")); err != nil {
+				return
+			}
+			conn = tls.Server(conn, f.tls)
+			scanner = bufio.NewScanner(conn)
+			continue
+		}
+
+		resp, ok := f.responses[line]
+		if !ok {
+			resp = "ERR UNKNOWN-COMMAND"
+		}
+		if _, err := conn.Write([]byte(resp + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+// dropConnections closes every client connection, as a restarting upsd
+func (f *fakeUpsd) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeUpsd) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func TestClientCommands(t *testing.T) {
+	upsd := newFakeUpsd(t, map[string]string{
+		`INSTCMD eaton shutdown.return`:             "OK",
+		`SET VAR eaton ups.delay.shutdown "120"`:    "OK",
+		`SET VAR eaton ups.id "rack \"A\" \\ left"`: "OK",
+		`FSD eaton`: "ERR ACCESS-DENIED",
+	})
+
+	c := NewClient(upsd.addr(), "eaton")
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if err := c.InstCmd(ctx, "shutdown.return"); err != nil {
+		t.Errorf("InstCmd failed: %v", err)
+	}
+	if err := c.SetVar(ctx, "ups.delay.shutdown", "120"); err != nil {
+		t.Errorf("SetVar failed: %v", err)
+	}
+	if err := c.SetVar(ctx, "ups.id", `rack "A" \ left`); err != nil {
+		t.Errorf("SetVar with quotes failed: %v", err)
+	}
+	err := c.FSD(ctx)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected access denied error, got: %v", err)
+	}
+
+	if got := len(upsd.received()); got != 4 {
+		t.Errorf("Expected 4 commands, got %d", got)
+	}
+}
+
+func TestClientQueries(t *testing.T) {
+	upsd := newFakeUpsd(t, map[string]string{
+		"LIST UPS": "BEGIN LIST UPS\n" +
+			`UPS eaton "Eaton 5PX \"rack\""` + "\n" +
+			`UPS apc "Back-UPS"` + "\n" +
+			"END LIST UPS",
+		"LIST VAR eaton": "BEGIN LIST VAR eaton\n" +
+			`VAR eaton ups.status "OB LB"` + "\n" +
+			`VAR eaton battery.charge "42"` + "\n" +
+			`VAR eaton battery.runtime "360"` + "\n" +
+			`VAR eaton ups.load "17"` + "\n" +
+			`VAR eaton ups.id "C:\\ups \"A\""` + "\n" +
+			"END LIST VAR eaton",
+		"LIST RW eaton": "BEGIN LIST RW eaton\n" +
+			`VAR eaton ups.delay.shutdown "20"` + "\n" +
+			"END LIST RW eaton",
+		"LIST CMD eaton": "BEGIN LIST CMD eaton\n" +
+			"CMD eaton beeper.disable\n" +
+			"CMD eaton shutdown.return\n" +
+			"END LIST CMD eaton",
+		"GET VAR eaton battery.charge":  `VAR eaton battery.charge "42"`,
+		"GET VAR eaton ambient.temp":    "ERR VAR-NOT-SUPPORTED",
+		"GET DESC eaton battery.charge": `DESC eaton battery.charge "Battery charge (percent of full)"`,
+		"GET TYPE eaton ups.id":         "TYPE eaton ups.id RW STRING:32",
+		"VER":                           "Network UPS Tools upsd 2.8.1 - https://www.networkupstools.org/",
+		"NETVER":                        "1.3",
+	})
+
+	c := NewClient(upsd.addr(), "eaton")
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	upsList, err := c.ListUPS(ctx)
+	if err != nil {
+		t.Fatalf("ListUPS failed: %v", err)
+	}
+	if !reflect.DeepEqual(upsList, map[string]string{"eaton": `Eaton 5PX "rack"`, "apc": "Back-UPS"}) {
+		t.Errorf("Unexpected UPS list: %q", upsList)
+	}
+
+	vars, err := c.ListVars(ctx)
+	if err != nil {
+		t.Fatalf("ListVars failed: %v", err)
+	}
+	if vars["ups.id"] != `C:\ups "A"` {
+		t.Errorf("Expected escaped value to be unquoted, got %q", vars["ups.id"])
+	}
+
+	// The response of LIST RW echoes VAR items, which is invalid
+	if _, err := c.ListRW(ctx); err == nil {
+		t.Error("Expected error for mismatched list items")
+	}
+
+	cmds, err := c.ListCommands(ctx)
+	if err != nil {
+		t.Fatalf("ListCommands failed: %v", err)
+	}
+	if !reflect.DeepEqual(cmds, []string{"beeper.disable", "shutdown.return"}) {
+		t.Errorf("Unexpected commands: %q", cmds)
+	}
+
+	status, err := c.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if status.Status != "OB LB" || status.BatteryCharge != 42 || status.Runtime != 360 || status.Load != 17 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	if v, err := c.GetVar(ctx, "battery.charge"); err != nil || v != "42" {
+		t.Errorf("Expected battery.charge 42, got %q (%v)", v, err)
+	}
+	if _, err := c.GetVar(ctx, "ambient.temp"); !errors.Is(err, ErrVarNotSupported) {
+		t.Errorf("Expected VAR-NOT-SUPPORTED, got %v", err)
+	}
+	if d, err := c.GetDesc(ctx, "battery.charge"); err != nil || d != "Battery charge (percent of full)" {
+		t.Errorf("Unexpected description %q (%v)", d, err)
+	}
+	if typ, err := c.GetType(ctx, "ups.id"); err != nil || !reflect.DeepEqual(typ, []string{"RW", "STRING:32"}) {
+		t.Errorf("Unexpected type %q (%v)", typ, err)
+	}
+	if v, err := c.Version(ctx); err != nil || !strings.HasPrefix(v, "Network UPS Tools upsd 2.8.1") {
+		t.Errorf("Unexpected version %q (%v)", v, err)
+	}
+	if v, err := c.ProtocolVersion(ctx); err != nil || v != "1.3" {
+		t.Errorf("Unexpected protocol version %q (%v)", v, err)
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		wantErr  bool
+	}{
+		{line: "VAR eaton ups.status \"OL\"", expected: []string{"VAR", "eaton", "ups.status", "OL"}},
+		{line: `VAR eaton ups.id "a \"b\" \\ c"`, expected: []string{"VAR", "eaton", "ups.id", `a "b" \ c`}},
+		{line: `VAR eaton ups.id ""`, expected: []string{"VAR", "eaton", "ups.id", ""}},
+		{line: "TYPE  eaton  ups.id RW", expected: []string{"TYPE", "eaton", "ups.id", "RW"}},
+		{line: `VAR eaton ups.id "open`, wantErr: true},
+		{line: `VAR eaton ups.id trailing\`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitLine(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitLine(%q): expected error", tt.line)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("splitLine(%q) = %q (%v), expected %q", tt.line, got, err, tt.expected)
+		}
+	}
+
+	for _, s := range []string{"plain", `rack "A" \ left`, ""} {
+		words, err := splitLine("SET VAR eaton ups.id " + quote(s))
+		if err != nil || words[len(words)-1] != s {
+			t.Errorf("quote(%q) does not round trip: %q (%v)", s, words, err)
+		}
+	}
+}
+
+func TestClientLogin(t *testing.T) {
+	upsd := newFakeUpsd(t, map[string]string{
+		`USERNAME "monuser"`: "OK",
+		`PASSWORD "s3cr\"t"`: "OK",
+		`LOGIN eaton`:        "OK",
+		`MASTER eaton`:       "OK MASTER-GRANTED",
+	})
+
+	c := NewClientWithOptions(upsd.addr(), "eaton", Options{
+		Username: "monuser",
+		Password: `s3cr"t`,
+		Role:     RolePrimary,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	c.Close()
+
+	// PRIMARY is unknown to this upsd, MASTER is used instead
+	expected := []string{`USERNAME "monuser"`, `PASSWORD "s3cr\"t"`, "LOGIN eaton", "PRIMARY eaton", "MASTER eaton"}
+	got := upsd.received()
+	if len(got) < len(expected) {
+		t.Fatalf("Expected commands %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected command %d to be %q, got %q", i, expected[i], got[i])
+		}
+	}
+
+	bad := NewClientWithOptions(newFakeUpsd(t, map[string]string{}).addr(), "eaton", Options{
+		Username: "monuser",
+		Password: "wrong",
+	})
+	if err := bad.Connect(); err == nil {
+		t.Error("Expected authentication failure")
+	}
+}
+
+func TestClientStartTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	upsd := newFakeUpsd(t, map[string]string{
+		`INSTCMD eaton beeper.disable`: "OK",
+	})
+	upsd.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	c := NewClientWithOptions(upsd.addr(), "eaton", Options{
+		TLS: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.InstCmd(context.Background(), "beeper.disable"); err != nil {
+		t.Errorf("InstCmd over TLS failed: %v", err)
+	}
+
+	untrusted := NewClientWithOptions(upsd.addr(), "eaton", Options{
+		TLS: &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "127.0.0.1"},
+	})
+	if err := untrusted.Connect(); err == nil {
+		t.Error("Expected certificate verification to fail")
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a
+// pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "upsd"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestMonitorReconnect(t *testing.T) {
+	upsd := newFakeUpsd(t, map[string]string{
+		"LIST VAR eaton": "BEGIN LIST VAR eaton\n" +
+			`VAR eaton ups.status "OB"` + "\n" +
+			`VAR eaton battery.charge "80"` + "\n" +
+			"END LIST VAR eaton",
+	})
+
+	m := NewMonitor(NewClient(upsd.addr(), "eaton"), Thresholds{Critical: 20, CommLoss: 100 * time.Millisecond})
+	m.interval = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer m.Stop()
+
+	expectEvent := func(expected EventType) {
+		t.Helper()
+		select {
+		case event := <-m.Events():
+			if event.Type != expected {
+				t.Fatalf("Expected %s, got %s (%s)", expected, event.Type, event.Message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", expected)
+		}
+	}
+
+	expectEvent(EventPowerLost)
+
+	// upsd restarts: the monitor reconnects by itself
+	upsd.dropConnections()
+	expectEvent(EventCommLost)
+	if state := m.CommState(); state != CommBad {
+		t.Errorf("Expected %s, got %s", CommBad, state)
+	}
+	expectEvent(EventCommRestored)
+	if state := m.CommState(); state != CommOK {
+		t.Errorf("Expected %s, got %s", CommOK, state)
+	}
+
+	// upsd is gone for good while on battery: assume the worst
+	upsd.ln.Close()
+	upsd.dropConnections()
+	expectEvent(EventCommLost)
+	expectEvent(EventNoComm)
+	expectEvent(EventCriticalBattery)
+}
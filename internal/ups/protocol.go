@@ -0,0 +1,336 @@
+package ups
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ErrorCode is an error code returned by upsd (RFC 9271 section 5.3). It
+// can be matched with errors.Is against the error returned by a Client.
+type ErrorCode string
+
+func (c ErrorCode) Error() string {
+	return "NUT error: " + string(c)
+}
+
+// Error codes returned by upsd
+const (
+	ErrAccessDenied         ErrorCode = "ACCESS-DENIED"
+	ErrUnknownUPS           ErrorCode = "UNKNOWN-UPS"
+	ErrVarNotSupported      ErrorCode = "VAR-NOT-SUPPORTED"
+	ErrCmdNotSupported      ErrorCode = "CMD-NOT-SUPPORTED"
+	ErrInvalidArgument      ErrorCode = "INVALID-ARGUMENT"
+	ErrInstCmdFailed        ErrorCode = "INSTCMD-FAILED"
+	ErrSetFailed            ErrorCode = "SET-FAILED"
+	ErrReadOnly             ErrorCode = "READONLY"
+	ErrTooLong              ErrorCode = "TOO-LONG"
+	ErrFeatureNotSupported  ErrorCode = "FEATURE-NOT-SUPPORTED"
+	ErrFeatureNotConfigured ErrorCode = "FEATURE-NOT-CONFIGURED"
+	ErrAlreadySSLMode       ErrorCode = "ALREADY-SSL-MODE"
+	ErrDriverNotConnected   ErrorCode = "DRIVER-NOT-CONNECTED"
+	ErrDataStale            ErrorCode = "DATA-STALE"
+	ErrAlreadyLoggedIn      ErrorCode = "ALREADY-LOGGED-IN"
+	ErrInvalidPassword      ErrorCode = "INVALID-PASSWORD"
+	ErrAlreadySetPassword   ErrorCode = "ALREADY-SET-PASSWORD"
+	ErrInvalidUsername      ErrorCode = "INVALID-USERNAME"
+	ErrAlreadySetUsername   ErrorCode = "ALREADY-SET-USERNAME"
+	ErrUsernameRequired     ErrorCode = "USERNAME-REQUIRED"
+	ErrPasswordRequired     ErrorCode = "PASSWORD-REQUIRED"
+	ErrUnknownCommand       ErrorCode = "UNKNOWN-COMMAND"
+	ErrInvalidValue         ErrorCode = "INVALID-VALUE"
+)
+
+// Error is an ERR response from upsd
+type Error struct {
+	Code   ErrorCode
+	Detail string // Optional extra information following the code
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("NUT error: %s (%s)", e.Code, e.Detail)
+	}
+	return e.Code.Error()
+}
+
+// Is matches the error against its ErrorCode
+func (e *Error) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.Code
+}
+
+// parseError returns the error carried by an ERR line, if any
+func parseError(line string) error {
+	if line != "ERR" && !strings.HasPrefix(line, "ERR ") {
+		return nil
+	}
+	fields := strings.SplitN(strings.TrimPrefix(line, "ERR"), " ", 3)
+	e := &Error{}
+	if len(fields) > 1 {
+		e.Code = ErrorCode(fields[1])
+	}
+	if len(fields) > 2 {
+		e.Detail = fields[2]
+	}
+	return e
+}
+
+// splitLine splits a protocol line into words. Double quotes group words
+// containing spaces and a backslash escapes the next character.
+func splitLine(line string) ([]string, error) {
+	var (
+		words    []string
+		word     strings.Builder
+		inWord   bool
+		quoted   bool
+		escaping bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escaping:
+			word.WriteRune(r)
+			escaping = false
+		case r == '\\':
+			escaping, inWord = true, true
+		case r == '"':
+			quoted, inWord = !quoted, true
+		case r == ' ' && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quoted || escaping {
+		return nil, fmt.Errorf("malformed NUT response: %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quote returns s as a NUT protocol quoted string
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// queryLine sends a command answered by a single line, such as GET or
+// VER, and returns that line
+func (c *Client) queryLine(ctx context.Context, cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(ctx); err != nil {
+		return "", err
+	}
+	if err := c.send(cmd); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	if err := parseError(line); err != nil {
+		return "", err
+	}
+	return line, nil
+}
+
+// query is queryLine returning the words of the response
+func (c *Client) query(ctx context.Context, cmd string) ([]string, error) {
+	line, err := c.queryLine(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return splitLine(line)
+}
+
+// get runs GET <kind> <ups> <name> and returns the words following the
+// echoed request
+func (c *Client) get(ctx context.Context, kind, name string) ([]string, error) {
+	words, err := c.query(ctx, fmt.Sprintf("GET %s %s %s", kind, c.upsName, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(words) < 3 || words[0] != kind || words[1] != c.upsName || words[2] != name {
+		return nil, fmt.Errorf("unexpected NUT response to GET %s %s: %q", kind, name, words)
+	}
+	return words[3:], nil
+}
+
+// list runs LIST <args> and returns the words of every item following the
+// echoed arguments
+func (c *Client) list(ctx context.Context, args ...string) ([][]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.begin(ctx); err != nil {
+		return nil, err
+	}
+
+	request := strings.Join(args, " ")
+	if err := c.send("LIST " + request); err != nil {
+		return nil, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if err := parseError(line); err != nil {
+		return nil, err
+	}
+	if line != "BEGIN LIST "+request {
+		return nil, fmt.Errorf("unexpected NUT response to LIST %s: %q", request, line)
+	}
+
+	// Read up to END LIST even past a bad item so that the next command
+	// does not get the rest of the list as its response
+	var (
+		items  [][]string
+		badErr error
+	)
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END LIST "+request {
+			break
+		}
+
+		words, err := splitLine(line)
+		if err == nil && !hasPrefix(words, args) {
+			err = fmt.Errorf("unexpected NUT list item: %q", line)
+		}
+		if err != nil {
+			if badErr == nil {
+				badErr = err
+			}
+			continue
+		}
+		items = append(items, words[len(args):])
+	}
+
+	if badErr != nil {
+		return nil, badErr
+	}
+	return items, nil
+}
+
+// hasPrefix reports whether words start with prefix
+func hasPrefix(words, prefix []string) bool {
+	if len(words) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if words[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// listPairs runs LIST <args> for items of the form <name> <value>
+func (c *Client) listPairs(ctx context.Context, args ...string) (map[string]string, error) {
+	items, err := c.list(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make(map[string]string, len(items))
+	for _, item := range items {
+		if len(item) != 2 {
+			return nil, fmt.Errorf("unexpected NUT list item: %q", item)
+		}
+		pairs[item[0]] = item[1]
+	}
+	return pairs, nil
+}
+
+// ListUPS returns the UPS units served by upsd with their description
+func (c *Client) ListUPS(ctx context.Context) (map[string]string, error) {
+	return c.listPairs(ctx, "UPS")
+}
+
+// ListVars returns all variables of the UPS with their value
+func (c *Client) ListVars(ctx context.Context) (map[string]string, error) {
+	return c.listPairs(ctx, "VAR", c.upsName)
+}
+
+// ListRW returns the writable variables of the UPS with their value
+func (c *Client) ListRW(ctx context.Context) (map[string]string, error) {
+	return c.listPairs(ctx, "RW", c.upsName)
+}
+
+// ListCommands returns the instant commands supported by the UPS
+func (c *Client) ListCommands(ctx context.Context) ([]string, error) {
+	items, err := c.list(ctx, "CMD", c.upsName)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]string, 0, len(items))
+	for _, item := range items {
+		if len(item) != 1 {
+			return nil, fmt.Errorf("unexpected NUT list item: %q", item)
+		}
+		cmds = append(cmds, item[0])
+	}
+	return cmds, nil
+}
+
+// GetVar returns the value of a UPS variable
+func (c *Client) GetVar(ctx context.Context, name string) (string, error) {
+	words, err := c.get(ctx, "VAR", name)
+	if err != nil {
+		return "", err
+	}
+	if len(words) != 1 {
+		return "", fmt.Errorf("unexpected NUT response to GET VAR %s: %q", name, words)
+	}
+	return words[0], nil
+}
+
+// GetDesc returns the description of a UPS variable
+func (c *Client) GetDesc(ctx context.Context, name string) (string, error) {
+	words, err := c.get(ctx, "DESC", name)
+	if err != nil {
+		return "", err
+	}
+	if len(words) != 1 {
+		return "", fmt.Errorf("unexpected NUT response to GET DESC %s: %q", name, words)
+	}
+	return words[0], nil
+}
+
+// GetType returns the type flags of a UPS variable, such as RW, ENUM,
+// RANGE, NUMBER or STRING:<length>
+func (c *Client) GetType(ctx context.Context, name string) ([]string, error) {
+	return c.get(ctx, "TYPE", name)
+}
+
+// Version returns the upsd server version banner
+func (c *Client) Version(ctx context.Context) (string, error) {
+	return c.queryLine(ctx, "VER")
+}
+
+// ProtocolVersion returns the network protocol version spoken by upsd
+func (c *Client) ProtocolVersion(ctx context.Context) (string, error) {
+	words, err := c.query(ctx, "NETVER")
+	if err != nil {
+		return "", err
+	}
+	if len(words) != 1 {
+		return "", fmt.Errorf("unexpected NUT response to NETVER: %q", words)
+	}
+	return words[0], nil
+}
@@ -0,0 +1,296 @@
+package ups
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScenarioStep is the state of a simulated UPS from a point of its timeline
+type ScenarioStep struct {
+	At      time.Duration // Offset from the start of the scenario
+	Status  string
+	Charge  int
+	Runtime time.Duration
+	Load    int
+}
+
+// Scenario is the timeline replayed by the simulated driver. Charge,
+// runtime and load move linearly from one step to the next; the status
+// changes at each step. The last step holds once the timeline is over.
+type Scenario []ScenarioStep
+
+// scenarioRecord is a step as written in a scenario file. Empty fields
+// keep the value of the previous step.
+type scenarioRecord struct {
+	At      string `yaml:"at"`
+	Status  string `yaml:"status"`
+	Charge  *int   `yaml:"charge"`
+	Runtime string `yaml:"runtime"`
+	Load    *int   `yaml:"load"`
+}
+
+// LoadScenario reads a scenario from a YAML file, or a CSV file with an
+// "at,status,charge,runtime,load" header when its extension is .csv
+func LoadScenario(path string) (Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading scenario: %w", err)
+	}
+	defer f.Close()
+
+	var scenario Scenario
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		scenario, err = ParseScenarioCSV(f)
+	} else {
+		scenario, err = ParseScenarioYAML(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing scenario %s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// ParseScenarioYAML parses a list of steps with at, status, charge,
+// runtime and load fields
+func ParseScenarioYAML(r io.Reader) (Scenario, error) {
+	var records []scenarioRecord
+	if err := yaml.NewDecoder(r).Decode(&records); err != nil {
+		return nil, err
+	}
+	return buildScenario(records)
+}
+
+// ParseScenarioCSV parses a CSV timeline whose header names the columns
+func ParseScenarioCSV(r io.Reader) (Scenario, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing CSV header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["at"]; !ok {
+		return nil, fmt.Errorf("missing at column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	number := func(row []string, name string, line int) (*int, error) {
+		value := field(row, name)
+		if value == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s %q", line, name, value)
+		}
+		return &n, nil
+	}
+
+	records := make([]scenarioRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		record := scenarioRecord{
+			At:      field(row, "at"),
+			Status:  field(row, "status"),
+			Runtime: field(row, "runtime"),
+		}
+		if record.Charge, err = number(row, "charge", i+2); err != nil {
+			return nil, err
+		}
+		if record.Load, err = number(row, "load", i+2); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return buildScenario(records)
+}
+
+// buildScenario checks the records and fills the fields they leave empty
+func buildScenario(records []scenarioRecord) (Scenario, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("scenario has no steps")
+	}
+
+	scenario := make(Scenario, len(records))
+	var prev ScenarioStep
+	for i, r := range records {
+		step := prev
+
+		at, err := parseScenarioDuration(r.At)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: invalid at: %w", i+1, err)
+		}
+		if at < prev.At {
+			return nil, fmt.Errorf("step %d: at %s is before the previous step", i+1, at)
+		}
+		step.At = at
+
+		if r.Status != "" {
+			step.Status = r.Status
+		}
+		if step.Status == "" {
+			return nil, fmt.Errorf("step %d: status is required", i+1)
+		}
+		if r.Charge != nil {
+			if *r.Charge < 0 || *r.Charge > 100 {
+				return nil, fmt.Errorf("step %d: charge must be between 0 and 100", i+1)
+			}
+			step.Charge = *r.Charge
+		}
+		if r.Runtime != "" {
+			if step.Runtime, err = parseScenarioDuration(r.Runtime); err != nil {
+				return nil, fmt.Errorf("step %d: invalid runtime: %w", i+1, err)
+			}
+		}
+		if r.Load != nil {
+			step.Load = *r.Load
+		}
+
+		scenario[i] = step
+		prev = step
+	}
+	return scenario, nil
+}
+
+// parseScenarioDuration parses a duration such as "2m30s", or a number of
+// seconds
+func parseScenarioDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	d, err := time.ParseDuration(value)
+	if secs, ferr := strconv.ParseFloat(value, 64); ferr == nil {
+		d, err = time.Duration(secs*float64(time.Second)), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %s", value)
+	}
+	return d, nil
+}
+
+// Duration returns the offset of the last step
+func (s Scenario) Duration() time.Duration {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[len(s)-1].At
+}
+
+// StatusAt returns the state of the UPS at offset t of the timeline
+func (s Scenario) StatusAt(t time.Duration) Status {
+	i := 0
+	for i+1 < len(s) && s[i+1].At <= t {
+		i++
+	}
+	cur := s[i]
+	status := Status{
+		Status:        cur.Status,
+		BatteryCharge: cur.Charge,
+		Runtime:       int(cur.Runtime.Seconds()),
+		Load:          cur.Load,
+	}
+	if i+1 == len(s) || t <= cur.At {
+		return status
+	}
+
+	next := s[i+1]
+	f := float64(t-cur.At) / float64(next.At-cur.At)
+	lerp := func(from, to float64) int {
+		return int(from + (to-from)*f + 0.5)
+	}
+	status.BatteryCharge = lerp(float64(cur.Charge), float64(next.Charge))
+	status.Runtime = lerp(cur.Runtime.Seconds(), next.Runtime.Seconds())
+	status.Load = lerp(float64(cur.Load), float64(next.Load))
+	return status
+}
+
+// SimulatedClient replays a scenario instead of reading a real UPS, so that
+// outages can be rehearsed without one. The timeline starts on the first
+// Connect and runs speed times faster than the wall clock.
+type SimulatedClient struct {
+	scenario Scenario
+	upsName  string
+	speed    float64
+	now      func() time.Time
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// NewSimulatedClient creates a driver replaying scenario. A speed of 0
+// replays it in real time.
+func NewSimulatedClient(scenario Scenario, upsName string, speed float64) *SimulatedClient {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &SimulatedClient{
+		scenario: scenario,
+		upsName:  upsName,
+		speed:    speed,
+		now:      time.Now,
+	}
+}
+
+// Connect starts the playback; reconnecting does not rewind it
+func (c *SimulatedClient) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.start.IsZero() {
+		c.start = c.now()
+	}
+	return nil
+}
+
+// Close is a no-op
+func (c *SimulatedClient) Close() error {
+	return nil
+}
+
+// Elapsed returns the current offset in the scenario timeline
+func (c *SimulatedClient) Elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.start.IsZero() {
+		return 0
+	}
+	return time.Duration(float64(c.now().Sub(c.start)) * c.speed)
+}
+
+// GetStatus returns the state of the scenario at the current offset
+func (c *SimulatedClient) GetStatus(ctx context.Context) (*Status, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	status := c.scenario.StatusAt(c.Elapsed())
+	status.Name = c.upsName
+	status.Timestamp = time.Now()
+	return &status, nil
+}
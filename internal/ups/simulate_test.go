@@ -0,0 +1,118 @@
+package ups
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const outageScenario = `
+- at: 0s
+  status: OL
+  charge: 100
+  runtime: 30m
+  load: 40
+- at: 1m
+  status: OB
+- at: 11m
+  charge: 50
+  runtime: 900
+- at: 12m
+  status: OB LB
+`
+
+func TestParseScenario(t *testing.T) {
+	fromYAML, err := ParseScenarioYAML(strings.NewReader(outageScenario))
+	if err != nil {
+		t.Fatalf("ParseScenarioYAML failed: %v", err)
+	}
+
+	fromCSV, err := ParseScenarioCSV(strings.NewReader(`at,status,charge,runtime,load
+# mains, then an outage
+0s,OL,100,30m,40
+60,OB,,,
+11m,,50,15m,
+12m,OB LB,,,
+`))
+	if err != nil {
+		t.Fatalf("ParseScenarioCSV failed: %v", err)
+	}
+
+	for _, scenario := range []Scenario{fromYAML, fromCSV} {
+		if len(scenario) != 4 || scenario.Duration() != 12*time.Minute {
+			t.Fatalf("Expected 4 steps over 12m, got %+v", scenario)
+		}
+		last := scenario[3]
+		if last.Status != "OB LB" || last.Charge != 50 || last.Runtime != 15*time.Minute || last.Load != 40 {
+			t.Errorf("Expected the last step to inherit the previous values, got %+v", last)
+		}
+	}
+
+	invalid := []string{
+		"[]",
+		"- at: 1m\n",
+		"- {at: 2m, status: OL}\n- {at: 1m}\n",
+		"- {at: 0s, status: OL, charge: 120}\n",
+		"- {at: -1, status: OL}\n",
+		"- {at: soon, status: OL}\n",
+	}
+	for _, data := range invalid {
+		if _, err := ParseScenarioYAML(strings.NewReader(data)); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
+
+func TestScenarioStatusAt(t *testing.T) {
+	scenario, err := ParseScenarioYAML(strings.NewReader(outageScenario))
+	if err != nil {
+		t.Fatalf("ParseScenarioYAML failed: %v", err)
+	}
+
+	tests := []struct {
+		at       time.Duration
+		expected Status
+	}{
+		{0, Status{Status: "OL", BatteryCharge: 100, Runtime: 1800, Load: 40}},
+		{30 * time.Second, Status{Status: "OL", BatteryCharge: 100, Runtime: 1800, Load: 40}},
+		{6 * time.Minute, Status{Status: "OB", BatteryCharge: 75, Runtime: 1350, Load: 40}},
+		{12 * time.Minute, Status{Status: "OB LB", BatteryCharge: 50, Runtime: 900, Load: 40}},
+		{time.Hour, Status{Status: "OB LB", BatteryCharge: 50, Runtime: 900, Load: 40}},
+	}
+	for _, tt := range tests {
+		if got := scenario.StatusAt(tt.at); got != tt.expected {
+			t.Errorf("StatusAt(%s): expected %+v, got %+v", tt.at, tt.expected, got)
+		}
+	}
+}
+
+func TestSimulatedClient(t *testing.T) {
+	scenario, err := ParseScenarioYAML(strings.NewReader(outageScenario))
+	if err != nil {
+		t.Fatalf("ParseScenarioYAML failed: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewSimulatedClient(scenario, "sim", 60)
+	c.now = func() time.Time { return now }
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	status, err := c.GetStatus(context.Background())
+	if err != nil || !status.IsOnline() || status.Name != "sim" {
+		t.Fatalf("Expected sim online at start, got %+v (%v)", status, err)
+	}
+
+	// 6 real seconds at 60x are 6 minutes into the scenario
+	now = now.Add(6 * time.Second)
+	_ = c.Connect()
+	if c.Elapsed() != 6*time.Minute {
+		t.Errorf("Expected 6m elapsed, got %s", c.Elapsed())
+	}
+	status, _ = c.GetStatus(context.Background())
+	if !status.IsOnBattery() || status.BatteryCharge != 75 {
+		t.Errorf("Expected on battery at 75%%, got %+v", status)
+	}
+}
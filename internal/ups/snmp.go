@@ -0,0 +1,105 @@
+package ups
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/snmp"
+)
+
+// UPS-MIB (RFC 1628) objects read by the SNMP driver
+const (
+	oidBatteryStatus    = "1.3.6.1.2.1.33.1.2.1.0"
+	oidMinutesRemaining = "1.3.6.1.2.1.33.1.2.3.0"
+	oidChargeRemaining  = "1.3.6.1.2.1.33.1.2.4.0"
+	oidOutputSource     = "1.3.6.1.2.1.33.1.4.1.0"
+	oidOutputLoad       = "1.3.6.1.2.1.33.1.4.4.1.5.1" // first output line
+)
+
+// upsOutputSource values mapped to NUT ups.status flags
+var snmpOutputFlags = map[int64]string{
+	2: "OFF",       // none
+	3: "OL",        // normal
+	4: "OL BYPASS", // bypass
+	5: "OB",        // battery
+	6: "OL BOOST",  // booster
+	7: "OL TRIM",   // reducer
+}
+
+// SNMPClient reads UPS status from a network management card through the
+// standard UPS-MIB
+type SNMPClient struct {
+	client  *snmp.Client
+	upsName string
+}
+
+// NewSNMPClient creates a new SNMP UPS-MIB client. upsName is only used to
+// name the UPS in its status.
+func NewSNMPClient(cfg snmp.Config, upsName string) (*SNMPClient, error) {
+	client, err := snmp.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SNMPClient{client: client, upsName: upsName}, nil
+}
+
+// Connect opens the SNMP session
+func (c *SNMPClient) Connect() error {
+	return c.client.Connect()
+}
+
+// Close closes the SNMP session
+func (c *SNMPClient) Close() error {
+	return c.client.Close()
+}
+
+// GetStatus retrieves current UPS status
+func (c *SNMPClient) GetStatus(ctx context.Context) (*Status, error) {
+	values, err := c.client.Get(ctx,
+		oidBatteryStatus, oidMinutesRemaining, oidChargeRemaining, oidOutputSource, oidOutputLoad)
+	if err != nil {
+		return nil, fmt.Errorf("reading UPS-MIB: %w", err)
+	}
+
+	status, err := snmpStatus(values)
+	if err != nil {
+		return nil, err
+	}
+	status.Name = c.upsName
+	return status, nil
+}
+
+// snmpStatus converts UPS-MIB values into a Status
+func snmpStatus(values map[string]snmp.Value) (*Status, error) {
+	source, ok := values[oidOutputSource].Integer()
+	if !ok {
+		return nil, fmt.Errorf("agent does not support the UPS-MIB (no upsOutputSource)")
+	}
+
+	var flags []string
+	if f, ok := snmpOutputFlags[source]; ok {
+		flags = append(flags, f)
+	}
+	// upsBatteryStatus: batteryLow(3) or batteryDepleted(4)
+	if battery, ok := values[oidBatteryStatus].Integer(); ok && (battery == 3 || battery == 4) {
+		flags = append(flags, "LB")
+	}
+
+	status := &Status{
+		Status:    strings.Join(flags, " "),
+		Timestamp: time.Now(),
+	}
+	if charge, ok := values[oidChargeRemaining].Integer(); ok {
+		status.BatteryCharge = int(charge)
+	}
+	if minutes, ok := values[oidMinutesRemaining].Integer(); ok {
+		status.Runtime = int(minutes) * 60
+	}
+	if load, ok := values[oidOutputLoad].Integer(); ok {
+		status.Load = int(load)
+	}
+
+	return status, nil
+}
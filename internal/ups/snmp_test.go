@@ -0,0 +1,59 @@
+package ups
+
+import (
+	"testing"
+
+	"github.com/Guilhem-Bonnet/proxmox-guardian/internal/snmp"
+)
+
+func integer(v int64) snmp.Value {
+	return snmp.Value{Type: snmp.TypeInteger, Int: v}
+}
+
+func TestSNMPStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]snmp.Value
+		expected Status
+	}{
+		{
+			name: "online",
+			values: map[string]snmp.Value{
+				oidBatteryStatus: integer(2), oidMinutesRemaining: integer(42),
+				oidChargeRemaining: integer(100), oidOutputSource: integer(3), oidOutputLoad: integer(27),
+			},
+			expected: Status{Status: "OL", BatteryCharge: 100, Runtime: 2520, Load: 27},
+		},
+		{
+			name: "on battery low",
+			values: map[string]snmp.Value{
+				oidBatteryStatus: integer(3), oidMinutesRemaining: integer(3),
+				oidChargeRemaining: integer(12), oidOutputSource: integer(5),
+				oidOutputLoad: {Type: snmp.TypeNoSuchInstance},
+			},
+			expected: Status{Status: "OB LB", BatteryCharge: 12, Runtime: 180},
+		},
+		{
+			name:     "trimming",
+			values:   map[string]snmp.Value{oidOutputSource: integer(7)},
+			expected: Status{Status: "OL TRIM"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := snmpStatus(tt.values)
+			if err != nil {
+				t.Fatalf("snmpStatus failed: %v", err)
+			}
+			if got.Status != tt.expected.Status || got.BatteryCharge != tt.expected.BatteryCharge ||
+				got.Runtime != tt.expected.Runtime || got.Load != tt.expected.Load {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := snmpStatus(map[string]snmp.Value{oidOutputSource: {Type: snmp.TypeNoSuchObject}}); err == nil {
+		t.Error("Expected error without upsOutputSource")
+	}
+}